@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/activity"
+)
+
+func activityCommand() *command {
+	return &command{
+		name:  "activity",
+		usage: "tail",
+		short: "read the audit log",
+		sub: []*command{
+			{name: "tail", usage: "[-n 20] [-f] [-resource r] [-by user]", short: "print the latest activities", run: activityTail},
+		},
+	}
+}
+
+func activityTail(args []string) error {
+	flags := flag.NewFlagSet("activity tail", flag.ContinueOnError)
+	n := flags.Uint64("n", 20, "number of activities")
+	follow := flags.Bool("f", false, "keep printing new activities")
+	interval := flags.Duration("interval", 2*time.Second, "poll interval with -f")
+	resource := flags.String("resource", "", "only this resource")
+	by := flags.String("by", "", "only activities created by this user")
+	if _, err := parse(flags, args, "[flags]", 0); err != nil {
+		return err
+	}
+	filter := activity.FilterActivity{Resource: *resource, CreatedBy: *by, Limit: *n}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	return withServices(func(_ context.Context, s *services) error {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "CREATED AT\tBY\tRESOURCE\tACTION\tTITLE\tREQUEST ID")
+		// seen holds the ids of the last page, polling compares pages rather
+		// than timestamps, whose precision differs between the drivers.
+		seen := map[string]bool{}
+		for {
+			res, err := s.activity.ListActivity(ctx, filter)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			page := make(map[string]bool, len(res))
+			for i := len(res) - 1; i >= 0; i-- {
+				a := res[i]
+				page[a.ID] = true
+				if seen[a.ID] {
+					continue
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", a.CreatedAt, a.CreatedBy, a.Resource, a.Action, a.Title, a.RequestID)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			seen = page
+			if !*follow {
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(*interval):
+			}
+		}
+	})
+}
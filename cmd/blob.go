@@ -0,0 +1,16 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/anousonefs/golang-htmx-template/internal/blob"
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+)
+
+// newBlobStore opens the store of the uploaded files chosen by BLOB_STORE.
+func newBlobStore(ctx context.Context, cfg config.Config) (blob.Store, error) {
+	if cfg.BlobStore() == "minio" {
+		return blob.NewMinio(ctx, cfg.MinioEndpoint(), cfg.MinioAccessKey(), cfg.MinioSecretKey(), cfg.MinioBucket(), cfg.MinioUseSSL())
+	}
+	return blob.NewDisk(cfg.BlobDir()), nil
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/event"
+	"github.com/anousonefs/golang-htmx-template/internal/middleware"
+	"github.com/anousonefs/golang-htmx-template/internal/user"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed seed.yaml
+var seedFile []byte
+
+type seed struct {
+	AdminRole string `yaml:"adminRole"`
+	Roles     []struct {
+		ID   string `yaml:"id"`
+		Code string `yaml:"code"`
+		Name string `yaml:"name"`
+	} `yaml:"roles"`
+	Permissions []struct {
+		Resource string   `yaml:"resource"`
+		Actions  []string `yaml:"actions"`
+	} `yaml:"permissions"`
+	Policies []struct {
+		Role     string   `yaml:"role"`
+		Resource string   `yaml:"resource"`
+		Actions  []string `yaml:"actions"`
+		Scope    string   `yaml:"scope"`
+	} `yaml:"policies"`
+}
+
+// Bootstrap seeds the default roles, the permission catalog and the first
+// admin user. Every step skips rows that already exist so it is safe to
+// run on each deploy.
+func Bootstrap(args []string) error {
+	flags := flag.NewFlagSet("bootstrap", flag.ContinueOnError)
+	email := flags.String("email", config.GetEnv("BOOTSTRAP_ADMIN_EMAIL", ""), "admin email")
+	password := flags.String("password", config.GetEnv("BOOTSTRAP_ADMIN_PASSWORD", ""), "admin password")
+	phone := flags.String("phone", config.GetEnv("BOOTSTRAP_ADMIN_PHONE", ""), "admin phone")
+	firstName := flags.String("first-name", config.GetEnv("BOOTSTRAP_ADMIN_FIRST_NAME", "Admin"), "admin first name")
+	lastName := flags.String("last-name", config.GetEnv("BOOTSTRAP_ADMIN_LAST_NAME", "Admin"), "admin last name")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var s seed
+	if err := yaml.Unmarshal(seedFile, &s); err != nil {
+		return fmt.Errorf("parse seed.yaml: %v", err)
+	}
+	if s.AdminRole == "" {
+		return errors.New("seed.yaml: adminRole is empty")
+	}
+
+	ctx := context.Background()
+	svc, err := newServices(ctx)
+	if err != nil {
+		return err
+	}
+	defer svc.Close()
+	userService := svc.user
+
+	for _, r := range s.Roles {
+		id := r.ID
+		created, err := userService.CreateRole(ctx, user.Role{ID: &id, Code: r.Code, Name: r.Name})
+		if err != nil {
+			return fmt.Errorf("role %s: %v", r.ID, err)
+		}
+		report("role", r.ID, created)
+	}
+
+	for _, p := range s.Permissions {
+		for _, a := range p.Actions {
+			created, err := userService.CreateAllPermission(ctx, user.AllPermission{Resource: p.Resource, Action: a})
+			if err != nil {
+				return fmt.Errorf("permission %s:%s: %v", p.Resource, a, err)
+			}
+			report("permission", p.Resource+":"+a, created)
+		}
+	}
+
+	// matches the `p.obj == '*' && p.act == '*'` branch of policy.conf.
+	added, err := userService.GrantPermission(ctx, s.AdminRole, "*", "*", middleware.ScopeAll)
+	if err != nil {
+		return fmt.Errorf("grant %s: %v", s.AdminRole, err)
+	}
+	report("policy", s.AdminRole+" *:*", added)
+
+	for _, p := range s.Policies {
+		for _, a := range p.Actions {
+			name := p.Role + " " + p.Resource + ":" + a + "@" + p.Scope
+			added, err := userService.GrantPermission(ctx, p.Role, p.Resource, a, p.Scope)
+			if err != nil {
+				return fmt.Errorf("grant %s: %v", name, err)
+			}
+			report("policy", name, added)
+		}
+	}
+
+	if *email == "" {
+		fmt.Println("skip admin user: -email or BOOTSTRAP_ADMIN_EMAIL not set")
+		return nil
+	}
+	if _, err := userService.GetUser(ctx, user.FilterUser{Email: *email}); err == nil {
+		report("admin", *email, false)
+		return nil
+	} else if !errors.Is(err, apperror.ErrStatusNotFound) {
+		return err
+	}
+	if *password == "" || *phone == "" {
+		return errors.New("admin password and phone are required to create the admin user")
+	}
+	admin := user.User{
+		RoleID:    s.AdminRole,
+		FirstName: *firstName,
+		LastName:  *lastName,
+		Gender:    string(user.GendersO),
+		Email:     *email,
+		Phone:     *phone,
+		Password:  *password,
+		CreatedBy: "bootstrap",
+	}
+	if err := admin.Validate(); err != nil {
+		return err
+	}
+	if err := userService.CreateUser(ctx, admin, event.Actor{ID: "bootstrap"}); err != nil {
+		return err
+	}
+	report("admin", *email, true)
+	return nil
+}
+
+func report(kind, name string, created bool) {
+	state := "exists"
+	if created {
+		state = "created"
+	}
+	fmt.Printf("%-10s %-30s %s\n", kind, name, state)
+}
@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/user"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/anousonefs/golang-htmx-template/internal/activity"
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/department"
+	"github.com/anousonefs/golang-htmx-template/internal/event"
+	usr "github.com/anousonefs/golang-htmx-template/internal/user"
+)
+
+// command is one node of the CLI, a group of sub commands or a leaf with
+// run. The args given to run are the ones after its name.
+type command struct {
+	name  string
+	usage string
+	short string
+	run   func(args []string) error
+	sub   []*command
+	// note is printed under the commands in the help.
+	note string
+}
+
+func (c *command) execute(path string, args []string) error {
+	if c.run != nil {
+		return c.run(args)
+	}
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		c.help(os.Stdout, path)
+		return nil
+	}
+	for _, s := range c.sub {
+		if s.name == args[0] {
+			return s.execute(path+" "+s.name, args[1:])
+		}
+	}
+	c.help(os.Stderr, path)
+	return fmt.Errorf("unknown command %q", strings.TrimSpace(path+" "+args[0]))
+}
+
+func (c *command) help(w io.Writer, path string) {
+	fmt.Fprintf(w, "usage: %s <command>\n\ncommands:\n", path)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, s := range c.sub {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", s.name, s.usage, s.short)
+	}
+	tw.Flush()
+	if c.note != "" {
+		fmt.Fprintf(w, "\n%s\n", c.note)
+	}
+}
+
+func root() *command {
+	return &command{
+		sub: []*command{
+			{name: "serve", short: "run the http server (default)", run: serve},
+			{name: "worker", short: "run the job workers without the http server", run: runWorker},
+			{name: "migrate", usage: "up|down [n]|status|create <name>", short: "manage the database schema", run: Migrate},
+			{name: "bootstrap", usage: "[-email ...]", short: "seed the roles, permissions and first admin", run: Bootstrap},
+			userCommand(),
+			roleCommand(),
+			policyCommand(),
+			activityCommand(),
+		},
+	}
+}
+
+// Execute runs the command named by os.Args, the server when there is none
+// so existing deployments keep working.
+func Execute() error {
+	args := os.Args[1:]
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	err := root().execute(os.Args[0], args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	return err
+}
+
+func serve(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("serve takes no arguments, configure it with the environment")
+	}
+	return Run()
+}
+
+// services are the application services the operational commands go
+// through, so their validation, policy reload and activities apply as they
+// do over http.
+type services struct {
+	db       *sql.DB
+	events   *event.Bus
+	user     usr.Service
+	activity *activity.Service
+}
+
+func newServices(ctx context.Context) (*services, error) {
+	cfg := config.NewDBConfig()
+	newLogger(cfg)
+	db, err := sql.Open(cfg.DBDriver(), cfg.DSNInfo())
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if cfg.AutoMigrate() {
+		if err := migrateUp(ctx, db, cfg.DBDriver()); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	authz, adapter, model, err := newAuthz(db, cfg)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	bus := newBus(cfg, slog.Default(), db)
+	departments := department.NewService(department.NewRepo(db, cfg.SQL()))
+	return &services{
+		db:       db,
+		events:   bus,
+		user:     usr.NewService(usr.NewRepo(db, cfg.SQL(), model, adapter, authz), bus, departments),
+		activity: activity.NewService(activity.NewRepo(db, cfg.SQL())),
+	}, nil
+}
+
+// Close delivers the events published by the command, so its activity is
+// recorded without waiting for a server, and closes the database. The
+// pushes and webhooks are only queued, a worker sends them.
+func (s *services) Close() error {
+	if _, err := s.events.Dispatch(context.Background()); err != nil {
+		slog.Error("dispatch events", "err", err)
+	}
+	return s.db.Close()
+}
+
+// withServices runs fn with the services and closes them afterwards.
+func withServices(fn func(ctx context.Context, s *services) error) error {
+	ctx := context.Background()
+	s, err := newServices(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return fn(ctx, s)
+}
+
+// actor is recorded as created_by of the changes made from the CLI.
+func actor() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return "cli:" + u.Username
+	}
+	return "cli"
+}
+
+// parse parses args into flags and checks the number of positional
+// arguments left.
+func parse(flags *flag.FlagSet, args []string, usage string, n int) ([]string, error) {
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s %s\n", flags.Name(), usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() != n {
+		flags.Usage()
+		return nil, fmt.Errorf("%s: expected %d argument(s), got %d", flags.Name(), n, flags.NArg())
+	}
+	return flags.Args(), nil
+}
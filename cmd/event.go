@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/activity"
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/event"
+)
+
+// newBus builds the event bus with its subscribers, the server and the CLI
+// deliver the events the same way.
+func newBus(cfg config.Config, log *slog.Logger, db *sql.DB) *event.Bus {
+	bus := event.NewBus(event.NewRepo(db, cfg.SQL(), cfg.DBDriver()), time.Second, log)
+	activity.NewService(activity.NewRepo(db, cfg.SQL())).Subscribe(bus)
+	newNotification(cfg, log, db).Subscribe(bus)
+	newWebhook(cfg, db).Subscribe(bus)
+	return bus
+}
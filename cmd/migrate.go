@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/migration"
+)
+
+const migrationsDir = "migrations"
+
+var drivers = []string{config.DriverPostgres, config.DriverMysql, config.DriverSqlite}
+
+func newMigrator(db *sql.DB, driver string) (*migration.Migrator, error) {
+	return migration.New(db, driver, fs, path.Join(migrationsDir, driver))
+}
+
+// migrateUp applies pending migrations, treating "nothing to do" as success.
+func migrateUp(ctx context.Context, db *sql.DB, driver string) error {
+	m, err := newMigrator(db, driver)
+	if err != nil {
+		return err
+	}
+	if _, err := m.Up(ctx); err != nil && !errors.Is(err, migration.ErrNoChange) {
+		return fmt.Errorf("auto migrate: %v", err)
+	}
+	return nil
+}
+
+// Migrate runs `migrate up|down [n]|status|create <name>`.
+func Migrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dir := flags.String("dir", "cmd/migrations", "directory holding one sub directory of migrations per driver")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down [n]|status|create <name>")
+	}
+
+	if args[0] == "create" {
+		if len(args) < 2 {
+			return errors.New("usage: migrate create <name>")
+		}
+		dirs := make([]string, 0, len(drivers))
+		for _, d := range drivers {
+			dirs = append(dirs, filepath.Join(*dir, d))
+		}
+		files, err := migration.Create(args[1], dirs...)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			fmt.Printf("created %s\n", f)
+		}
+		return nil
+	}
+
+	cfg := config.NewDBConfig()
+	newLogger(cfg)
+	db, err := sql.Open(cfg.DBDriver(), cfg.DSNInfo())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	m, err := newMigrator(db, cfg.DBDriver())
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := m.Up(ctx)
+		if errors.Is(err, migration.ErrNoChange) {
+			fmt.Println("no change")
+			return nil
+		}
+		for _, mig := range applied {
+			fmt.Printf("applied %06d_%s\n", mig.Version, mig.Name)
+		}
+		return err
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+				return fmt.Errorf("invalid number of steps: %q", args[1])
+			}
+		}
+		reverted, err := m.Down(ctx, n)
+		if errors.Is(err, migration.ErrNoChange) {
+			fmt.Println("no change")
+			return nil
+		}
+		for _, mig := range reverted {
+			fmt.Printf("reverted %06d_%s\n", mig.Version, mig.Name)
+		}
+		return err
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.Applied {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(os.Stdout, "%06d_%-40s %s\n", s.Version, s.Name, appliedAt)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command %q", args[0])
+}
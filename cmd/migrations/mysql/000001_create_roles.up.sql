@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id varchar(64) PRIMARY KEY,
+    code varchar(64) NOT NULL DEFAULT '',
+    name varchar(128) NOT NULL,
+    status varchar(16) NOT NULL DEFAULT 'ACTIVE',
+    created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+);
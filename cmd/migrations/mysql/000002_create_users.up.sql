@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS users (
+    id char(36) PRIMARY KEY DEFAULT (UUID()),
+    role_id varchar(64) NOT NULL DEFAULT '',
+    tenant_id varchar(64) NOT NULL DEFAULT '',
+    username varchar(128),
+    first_name varchar(128) NOT NULL,
+    last_name varchar(128) NOT NULL,
+    gender varchar(1) NOT NULL DEFAULT 'O',
+    email varchar(256) NOT NULL,
+    phone varchar(32) NOT NULL,
+    password varchar(256) NOT NULL,
+    status varchar(16) NOT NULL DEFAULT 'ACTIVE',
+    department_id varchar(64) NOT NULL DEFAULT '',
+    position_id varchar(64) NOT NULL DEFAULT '',
+    is_signer boolean NOT NULL DEFAULT false,
+    signature varchar(512) NOT NULL DEFAULT '',
+    avatar varchar(512) NOT NULL DEFAULT '',
+    cif varchar(64) NOT NULL DEFAULT '',
+    created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    created_by varchar(64) NOT NULL DEFAULT '',
+    updated_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    updated_by varchar(64) NOT NULL DEFAULT '',
+    UNIQUE KEY users_email_key (email),
+    UNIQUE KEY users_phone_key (phone),
+    UNIQUE KEY users_username_key (username),
+    KEY users_role_id_idx (role_id),
+    KEY users_department_id_idx (department_id)
+);
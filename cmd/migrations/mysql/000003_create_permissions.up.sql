@@ -0,0 +1,24 @@
+-- casbin policy storage, see internal/rbac.
+CREATE TABLE IF NOT EXISTS permissions (
+    p_type varchar(256) NOT NULL DEFAULT '',
+    v0 varchar(256) NOT NULL DEFAULT '',
+    v1 varchar(256) NOT NULL DEFAULT '',
+    v2 varchar(256) NOT NULL DEFAULT '',
+    v3 varchar(256) NOT NULL DEFAULT '',
+    v4 varchar(256) NOT NULL DEFAULT '',
+    v5 varchar(256) NOT NULL DEFAULT '',
+    KEY idx_permissions_p_type (p_type),
+    KEY idx_permissions_v0 (v0),
+    KEY idx_permissions_v1 (v1),
+    KEY idx_permissions_v2 (v2),
+    KEY idx_permissions_v3 (v3),
+    KEY idx_permissions_v4 (v4),
+    KEY idx_permissions_v5 (v5)
+);
+
+CREATE TABLE IF NOT EXISTS all_permissions (
+    resource varchar(128) NOT NULL,
+    action varchar(64) NOT NULL,
+    created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    PRIMARY KEY (resource, action)
+);
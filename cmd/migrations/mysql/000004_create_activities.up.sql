@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS activities (
+    id char(36) PRIMARY KEY DEFAULT (UUID()),
+    title varchar(256) NOT NULL DEFAULT '',
+    resource varchar(128) NOT NULL DEFAULT '',
+    action varchar(64) NOT NULL DEFAULT '',
+    req_data longblob,
+    res_data longblob,
+    department_id varchar(64) NOT NULL DEFAULT '',
+    created_by varchar(64) NOT NULL DEFAULT '',
+    created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    KEY activities_created_at_idx (created_at),
+    KEY activities_resource_idx (resource, action)
+);
@@ -0,0 +1 @@
+ALTER TABLE activities ADD COLUMN request_id varchar(64) NOT NULL DEFAULT '';
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    id varchar(64) PRIMARY KEY,
+    user_id varchar(64) NOT NULL DEFAULT '',
+    data text NOT NULL,
+    user_agent varchar(512) NOT NULL DEFAULT '',
+    ip_address varchar(64) NOT NULL DEFAULT '',
+    created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    expires_at datetime(6) NOT NULL,
+    KEY sessions_user_id_idx (user_id),
+    KEY sessions_expires_at_idx (expires_at)
+);
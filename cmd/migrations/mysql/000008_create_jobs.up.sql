@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS jobs (
+    id bigint AUTO_INCREMENT PRIMARY KEY,
+    queue varchar(64) NOT NULL DEFAULT 'default',
+    kind varchar(128) NOT NULL,
+    payload text NOT NULL,
+    status varchar(16) NOT NULL DEFAULT 'pending',
+    attempts int NOT NULL DEFAULT 0,
+    max_attempts int NOT NULL DEFAULT 5,
+    last_error text NOT NULL,
+    locked_by varchar(128) NOT NULL DEFAULT '',
+    locked_at datetime(6),
+    run_at datetime(6) NOT NULL,
+    finished_at datetime(6),
+    created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    KEY jobs_fetch_idx (status, queue, run_at),
+    KEY jobs_status_idx (status, updated_at)
+);
+
+CREATE TABLE IF NOT EXISTS job_schedules (
+    name varchar(128) PRIMARY KEY,
+    spec varchar(128) NOT NULL,
+    queue varchar(64) NOT NULL DEFAULT 'default',
+    kind varchar(128) NOT NULL,
+    payload text NOT NULL,
+    next_run_at datetime(6) NOT NULL,
+    last_run_at datetime(6),
+    updated_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+);
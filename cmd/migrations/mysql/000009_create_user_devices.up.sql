@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS user_devices (
+    player_id varchar(128) PRIMARY KEY,
+    user_id varchar(64) NOT NULL,
+    platform varchar(32) NOT NULL DEFAULT '',
+    locale varchar(16) NOT NULL DEFAULT '',
+    created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    KEY user_devices_user_id_idx (user_id)
+);
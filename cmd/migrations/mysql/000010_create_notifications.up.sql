@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS notifications (
+    id bigint AUTO_INCREMENT PRIMARY KEY,
+    user_id varchar(64) NOT NULL,
+    title varchar(255) NOT NULL,
+    message text NOT NULL,
+    args text NOT NULL,
+    url varchar(512) NOT NULL DEFAULT '',
+    data text NOT NULL,
+    read_at datetime(6),
+    created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    KEY notifications_user_id_idx (user_id, id),
+    KEY notifications_created_at_idx (created_at)
+);
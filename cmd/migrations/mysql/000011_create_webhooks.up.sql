@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+    id bigint AUTO_INCREMENT PRIMARY KEY,
+    url varchar(2048) NOT NULL,
+    secret varchar(128) NOT NULL,
+    events text NOT NULL,
+    description varchar(255) NOT NULL DEFAULT '',
+    active boolean NOT NULL DEFAULT true,
+    created_by varchar(64) NOT NULL DEFAULT '',
+    created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id bigint AUTO_INCREMENT PRIMARY KEY,
+    webhook_id bigint NOT NULL,
+    event varchar(64) NOT NULL,
+    payload text NOT NULL,
+    status varchar(16) NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    response_code integer NOT NULL DEFAULT 0,
+    response_body text NOT NULL,
+    last_error text NOT NULL,
+    duration_ms integer NOT NULL DEFAULT 0,
+    created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    delivered_at datetime(6),
+    KEY webhook_deliveries_webhook_id_idx (webhook_id, id),
+    CONSTRAINT webhook_deliveries_webhook_id_fkey FOREIGN KEY (webhook_id) REFERENCES webhooks (id) ON DELETE CASCADE
+);
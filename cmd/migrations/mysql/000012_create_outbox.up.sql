@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS outbox (
+    id bigint AUTO_INCREMENT PRIMARY KEY,
+    name varchar(128) NOT NULL,
+    payload text NOT NULL,
+    request_id varchar(128) NOT NULL DEFAULT '',
+    status varchar(16) NOT NULL DEFAULT 'pending',
+    attempts int NOT NULL DEFAULT 0,
+    last_error text NOT NULL,
+    available_at datetime(6) NOT NULL,
+    published_at datetime(6),
+    created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    KEY outbox_fetch_idx (status, available_at, id),
+    KEY outbox_status_idx (status, updated_at)
+);
@@ -0,0 +1,3 @@
+DROP INDEX users_position_id_idx ON users;
+DROP TABLE IF EXISTS positions;
+DROP TABLE IF EXISTS departments;
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS departments (
+    id varchar(36) PRIMARY KEY,
+    parent_id varchar(36) NOT NULL DEFAULT '',
+    code varchar(64) NOT NULL,
+    name varchar(255) NOT NULL,
+    created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    created_by varchar(64) NOT NULL DEFAULT '',
+    updated_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    updated_by varchar(64) NOT NULL DEFAULT '',
+    UNIQUE KEY departments_code_key (code),
+    KEY departments_parent_id_idx (parent_id)
+);
+
+CREATE TABLE IF NOT EXISTS positions (
+    id varchar(36) PRIMARY KEY,
+    code varchar(64) NOT NULL,
+    name varchar(255) NOT NULL,
+    created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    created_by varchar(64) NOT NULL DEFAULT '',
+    updated_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    updated_by varchar(64) NOT NULL DEFAULT '',
+    UNIQUE KEY positions_code_key (code)
+);
+
+CREATE INDEX users_position_id_idx ON users (position_id);
@@ -0,0 +1,2 @@
+-- the rules written before the department scope reach every record.
+UPDATE permissions SET v3 = 'all' WHERE p_type = 'p' AND v3 = '';
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS signatures (
+    id bigint AUTO_INCREMENT PRIMARY KEY,
+    user_id varchar(64) NOT NULL,
+    blob_key varchar(255) NOT NULL,
+    sha256 varchar(64) NOT NULL,
+    width int NOT NULL,
+    height int NOT NULL,
+    size bigint NOT NULL,
+    valid_from datetime(6) NOT NULL,
+    valid_to datetime(6),
+    created_at datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    created_by varchar(64) NOT NULL DEFAULT '',
+    KEY signatures_user_id_idx (user_id, valid_from)
+);
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS users (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    role_id varchar(64) NOT NULL DEFAULT '',
+    tenant_id varchar(64) NOT NULL DEFAULT '',
+    username varchar(128),
+    first_name varchar(128) NOT NULL,
+    last_name varchar(128) NOT NULL,
+    gender varchar(1) NOT NULL DEFAULT 'O',
+    email varchar(256) NOT NULL,
+    phone varchar(32) NOT NULL,
+    password varchar(256) NOT NULL,
+    status varchar(16) NOT NULL DEFAULT 'ACTIVE',
+    department_id varchar(64) NOT NULL DEFAULT '',
+    position_id varchar(64) NOT NULL DEFAULT '',
+    is_signer boolean NOT NULL DEFAULT false,
+    signature varchar(512) NOT NULL DEFAULT '',
+    avatar varchar(512) NOT NULL DEFAULT '',
+    cif varchar(64) NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    created_by varchar(64) NOT NULL DEFAULT '',
+    updated_at timestamptz NOT NULL DEFAULT now(),
+    updated_by varchar(64) NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (email);
+CREATE UNIQUE INDEX IF NOT EXISTS users_phone_key ON users (phone);
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_key ON users (username);
+CREATE INDEX IF NOT EXISTS users_role_id_idx ON users (role_id);
+CREATE INDEX IF NOT EXISTS users_department_id_idx ON users (department_id);
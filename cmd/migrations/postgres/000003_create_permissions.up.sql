@@ -0,0 +1,25 @@
+-- casbin policy storage, see internal/rbac.
+CREATE TABLE IF NOT EXISTS permissions (
+    p_type varchar(256) NOT NULL DEFAULT '',
+    v0 varchar(256) NOT NULL DEFAULT '',
+    v1 varchar(256) NOT NULL DEFAULT '',
+    v2 varchar(256) NOT NULL DEFAULT '',
+    v3 varchar(256) NOT NULL DEFAULT '',
+    v4 varchar(256) NOT NULL DEFAULT '',
+    v5 varchar(256) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_permissions_p_type ON permissions (p_type);
+CREATE INDEX IF NOT EXISTS idx_permissions_v0 ON permissions (v0);
+CREATE INDEX IF NOT EXISTS idx_permissions_v1 ON permissions (v1);
+CREATE INDEX IF NOT EXISTS idx_permissions_v2 ON permissions (v2);
+CREATE INDEX IF NOT EXISTS idx_permissions_v3 ON permissions (v3);
+CREATE INDEX IF NOT EXISTS idx_permissions_v4 ON permissions (v4);
+CREATE INDEX IF NOT EXISTS idx_permissions_v5 ON permissions (v5);
+
+CREATE TABLE IF NOT EXISTS all_permissions (
+    resource varchar(128) NOT NULL,
+    action varchar(64) NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (resource, action)
+);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS activities (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    title varchar(256) NOT NULL DEFAULT '',
+    resource varchar(128) NOT NULL DEFAULT '',
+    action varchar(64) NOT NULL DEFAULT '',
+    req_data bytea,
+    res_data bytea,
+    department_id varchar(64) NOT NULL DEFAULT '',
+    created_by varchar(64) NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS activities_created_at_idx ON activities (created_at DESC);
+CREATE INDEX IF NOT EXISTS activities_resource_idx ON activities (resource, action);
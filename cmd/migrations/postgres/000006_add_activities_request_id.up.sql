@@ -0,0 +1 @@
+ALTER TABLE activities ADD COLUMN IF NOT EXISTS request_id varchar(64) NOT NULL DEFAULT '';
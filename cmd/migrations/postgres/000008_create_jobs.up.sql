@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS jobs (
+    id bigserial PRIMARY KEY,
+    queue varchar(64) NOT NULL DEFAULT 'default',
+    kind varchar(128) NOT NULL,
+    payload text NOT NULL DEFAULT '{}',
+    status varchar(16) NOT NULL DEFAULT 'pending',
+    attempts int NOT NULL DEFAULT 0,
+    max_attempts int NOT NULL DEFAULT 5,
+    last_error text NOT NULL DEFAULT '',
+    locked_by varchar(128) NOT NULL DEFAULT '',
+    locked_at timestamptz,
+    run_at timestamptz NOT NULL,
+    finished_at timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS jobs_fetch_idx ON jobs (queue, run_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS jobs_status_idx ON jobs (status, updated_at);
+
+CREATE TABLE IF NOT EXISTS job_schedules (
+    name varchar(128) PRIMARY KEY,
+    spec varchar(128) NOT NULL,
+    queue varchar(64) NOT NULL DEFAULT 'default',
+    kind varchar(128) NOT NULL,
+    payload text NOT NULL DEFAULT '{}',
+    next_run_at timestamptz NOT NULL,
+    last_run_at timestamptz,
+    updated_at timestamptz NOT NULL DEFAULT now()
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS user_devices (
+    player_id varchar(128) PRIMARY KEY,
+    user_id varchar(64) NOT NULL,
+    platform varchar(32) NOT NULL DEFAULT '',
+    locale varchar(16) NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS user_devices_user_id_idx ON user_devices (user_id);
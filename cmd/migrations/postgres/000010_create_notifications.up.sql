@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS notifications (
+    id bigserial PRIMARY KEY,
+    user_id varchar(64) NOT NULL,
+    title varchar(255) NOT NULL,
+    message text NOT NULL,
+    args text NOT NULL DEFAULT '[]',
+    url varchar(512) NOT NULL DEFAULT '',
+    data text NOT NULL DEFAULT '{}',
+    read_at timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS notifications_user_id_idx ON notifications (user_id, id);
+CREATE INDEX IF NOT EXISTS notifications_created_at_idx ON notifications (created_at);
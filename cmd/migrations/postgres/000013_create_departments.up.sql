@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS departments (
+    id varchar(36) PRIMARY KEY,
+    parent_id varchar(36) NOT NULL DEFAULT '',
+    code varchar(64) NOT NULL,
+    name varchar(255) NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    created_by varchar(64) NOT NULL DEFAULT '',
+    updated_at timestamptz NOT NULL DEFAULT now(),
+    updated_by varchar(64) NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS departments_code_key ON departments (code);
+CREATE INDEX IF NOT EXISTS departments_parent_id_idx ON departments (parent_id);
+
+CREATE TABLE IF NOT EXISTS positions (
+    id varchar(36) PRIMARY KEY,
+    code varchar(64) NOT NULL,
+    name varchar(255) NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    created_by varchar(64) NOT NULL DEFAULT '',
+    updated_at timestamptz NOT NULL DEFAULT now(),
+    updated_by varchar(64) NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS positions_code_key ON positions (code);
+CREATE INDEX IF NOT EXISTS users_position_id_idx ON users (position_id);
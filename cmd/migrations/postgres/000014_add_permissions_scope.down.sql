@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE p_type = 'p' AND v3 = 'department';
+UPDATE permissions SET v3 = '' WHERE p_type = 'p' AND v3 = 'all';
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS signatures (
+    id bigserial PRIMARY KEY,
+    user_id varchar(64) NOT NULL,
+    blob_key varchar(255) NOT NULL,
+    sha256 varchar(64) NOT NULL,
+    width int NOT NULL,
+    height int NOT NULL,
+    size bigint NOT NULL,
+    valid_from timestamptz NOT NULL,
+    valid_to timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    created_by varchar(64) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS signatures_user_id_idx ON signatures (user_id, valid_from);
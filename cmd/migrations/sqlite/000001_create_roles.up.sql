@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id text PRIMARY KEY,
+    code text NOT NULL DEFAULT '',
+    name text NOT NULL,
+    status text NOT NULL DEFAULT 'ACTIVE',
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
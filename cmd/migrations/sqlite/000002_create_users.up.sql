@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS users (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(4))) || '-' || lower(hex(randomblob(2))) || '-4' || substr(lower(hex(randomblob(2))), 2) || '-' || substr('89ab', 1 + (abs(random()) % 4), 1) || substr(lower(hex(randomblob(2))), 2) || '-' || lower(hex(randomblob(6)))),
+    role_id text NOT NULL DEFAULT '',
+    tenant_id text NOT NULL DEFAULT '',
+    username text,
+    first_name text NOT NULL,
+    last_name text NOT NULL,
+    gender text NOT NULL DEFAULT 'O',
+    email text NOT NULL,
+    phone text NOT NULL,
+    password text NOT NULL,
+    status text NOT NULL DEFAULT 'ACTIVE',
+    department_id text NOT NULL DEFAULT '',
+    position_id text NOT NULL DEFAULT '',
+    is_signer boolean NOT NULL DEFAULT 0,
+    signature text NOT NULL DEFAULT '',
+    avatar text NOT NULL DEFAULT '',
+    cif text NOT NULL DEFAULT '',
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_by text NOT NULL DEFAULT '',
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_by text NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (email);
+CREATE UNIQUE INDEX IF NOT EXISTS users_phone_key ON users (phone);
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_key ON users (username);
+CREATE INDEX IF NOT EXISTS users_role_id_idx ON users (role_id);
+CREATE INDEX IF NOT EXISTS users_department_id_idx ON users (department_id);
@@ -0,0 +1,22 @@
+-- casbin policy storage, see internal/rbac.
+CREATE TABLE IF NOT EXISTS permissions (
+    p_type text NOT NULL DEFAULT '',
+    v0 text NOT NULL DEFAULT '',
+    v1 text NOT NULL DEFAULT '',
+    v2 text NOT NULL DEFAULT '',
+    v3 text NOT NULL DEFAULT '',
+    v4 text NOT NULL DEFAULT '',
+    v5 text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_permissions_p_type ON permissions (p_type);
+CREATE INDEX IF NOT EXISTS idx_permissions_v0 ON permissions (v0);
+CREATE INDEX IF NOT EXISTS idx_permissions_v1 ON permissions (v1);
+CREATE INDEX IF NOT EXISTS idx_permissions_v2 ON permissions (v2);
+
+CREATE TABLE IF NOT EXISTS all_permissions (
+    resource text NOT NULL,
+    action text NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (resource, action)
+);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS activities (
+    id text PRIMARY KEY DEFAULT (lower(hex(randomblob(4))) || '-' || lower(hex(randomblob(2))) || '-4' || substr(lower(hex(randomblob(2))), 2) || '-' || substr('89ab', 1 + (abs(random()) % 4), 1) || substr(lower(hex(randomblob(2))), 2) || '-' || lower(hex(randomblob(6)))),
+    title text NOT NULL DEFAULT '',
+    resource text NOT NULL DEFAULT '',
+    action text NOT NULL DEFAULT '',
+    req_data blob,
+    res_data blob,
+    department_id text NOT NULL DEFAULT '',
+    created_by text NOT NULL DEFAULT '',
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS activities_created_at_idx ON activities (created_at DESC);
+CREATE INDEX IF NOT EXISTS activities_resource_idx ON activities (resource, action);
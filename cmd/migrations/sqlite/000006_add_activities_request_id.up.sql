@@ -0,0 +1 @@
+ALTER TABLE activities ADD COLUMN request_id text NOT NULL DEFAULT '';
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS jobs (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    queue text NOT NULL DEFAULT 'default',
+    kind text NOT NULL,
+    payload text NOT NULL DEFAULT '{}',
+    status text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    max_attempts integer NOT NULL DEFAULT 5,
+    last_error text NOT NULL DEFAULT '',
+    locked_by text NOT NULL DEFAULT '',
+    locked_at datetime,
+    run_at datetime NOT NULL,
+    finished_at datetime,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS jobs_fetch_idx ON jobs (queue, run_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS jobs_status_idx ON jobs (status, updated_at);
+
+CREATE TABLE IF NOT EXISTS job_schedules (
+    name text PRIMARY KEY,
+    spec text NOT NULL,
+    queue text NOT NULL DEFAULT 'default',
+    kind text NOT NULL,
+    payload text NOT NULL DEFAULT '{}',
+    next_run_at datetime NOT NULL,
+    last_run_at datetime,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
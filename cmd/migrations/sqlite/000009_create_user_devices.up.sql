@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS user_devices (
+    player_id text PRIMARY KEY,
+    user_id text NOT NULL,
+    platform text NOT NULL DEFAULT '',
+    locale text NOT NULL DEFAULT '',
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS user_devices_user_id_idx ON user_devices (user_id);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS notifications (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    user_id text NOT NULL,
+    title text NOT NULL,
+    message text NOT NULL,
+    args text NOT NULL DEFAULT '[]',
+    url text NOT NULL DEFAULT '',
+    data text NOT NULL DEFAULT '{}',
+    read_at datetime,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS notifications_user_id_idx ON notifications (user_id, id);
+CREATE INDEX IF NOT EXISTS notifications_created_at_idx ON notifications (created_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS users_position_id_idx;
+DROP TABLE IF EXISTS positions;
+DROP TABLE IF EXISTS departments;
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS departments (
+    id text PRIMARY KEY,
+    parent_id text NOT NULL DEFAULT '',
+    code text NOT NULL,
+    name text NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_by text NOT NULL DEFAULT '',
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_by text NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS departments_code_key ON departments (code);
+CREATE INDEX IF NOT EXISTS departments_parent_id_idx ON departments (parent_id);
+
+CREATE TABLE IF NOT EXISTS positions (
+    id text PRIMARY KEY,
+    code text NOT NULL,
+    name text NOT NULL,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_by text NOT NULL DEFAULT '',
+    updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_by text NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS positions_code_key ON positions (code);
+CREATE INDEX IF NOT EXISTS users_position_id_idx ON users (position_id);
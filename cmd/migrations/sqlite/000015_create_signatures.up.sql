@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS signatures (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    user_id text NOT NULL,
+    blob_key text NOT NULL,
+    sha256 text NOT NULL,
+    width integer NOT NULL,
+    height integer NOT NULL,
+    size integer NOT NULL,
+    valid_from datetime NOT NULL,
+    valid_to datetime,
+    created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_by text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS signatures_user_id_idx ON signatures (user_id, valid_from);
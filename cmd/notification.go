@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"database/sql"
+	"log/slog"
+
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/job"
+	"github.com/anousonefs/golang-htmx-template/internal/notification"
+)
+
+// newNotification builds the notification service, its pushes are queued
+// as jobs and sent by the workers with the notifier of cfg.
+func newNotification(cfg config.Config, log *slog.Logger, db *sql.DB) *notification.Service {
+	var notifier notification.Notifier = notification.NewLogNotifier(log)
+	if cfg.Notifier() == "onesignal" {
+		notifier = notification.NewOneSignal(cfg.OneSignalAppID(), cfg.OneSignalApiKey())
+	}
+	return notification.NewService(
+		notification.NewRepo(db, cfg.SQL()),
+		job.NewService(job.NewRepo(db, cfg.SQL(), cfg.DBDriver())),
+		notifier,
+	)
+}
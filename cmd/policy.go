@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/anousonefs/golang-htmx-template/internal/middleware"
+)
+
+// policyNote tells when the changes of the role and policy commands apply.
+const policyNote = "Running servers reload the policy within POLICY_RELOAD_INTERVAL (10s by\ndefault) of a grant, revoke or import, the signed in users included."
+
+func roleCommand() *command {
+	return &command{
+		name:  "role",
+		usage: "list|grant|revoke",
+		short: "manage roles and their permissions",
+		note:  policyNote,
+		sub: []*command{
+			{name: "list", short: "list the roles and their permissions", run: roleList},
+			{name: "grant", usage: "[-scope all|department] <role> <resource> <action>", short: "allow the role an action", run: roleGrant},
+			{name: "revoke", usage: "<role> <resource> <action>", short: "remove an action from the role", run: roleRevoke},
+		},
+	}
+}
+
+func policyCommand() *command {
+	return &command{
+		name:  "policy",
+		usage: "export|import|check",
+		short: "back up, restore and test the casbin policy",
+		note:  policyNote,
+		sub: []*command{
+			{name: "export", usage: "[-o file]", short: "write the policy as casbin csv", run: policyExport},
+			{name: "import", usage: "[-prune] <file>", short: "grant every rule of a casbin csv", run: policyImport},
+			{name: "check", usage: "<role> <resource> <action>", short: "tell whether the role is allowed", run: policyCheck},
+		},
+	}
+}
+
+func roleList(args []string) error {
+	if _, err := parse(flag.NewFlagSet("role list", flag.ContinueOnError), args, "", 0); err != nil {
+		return err
+	}
+	return withServices(func(ctx context.Context, s *services) error {
+		roles, err := s.user.ListRoles(ctx)
+		if err != nil {
+			return err
+		}
+		rules, err := s.user.ListPolicies(ctx)
+		if err != nil {
+			return err
+		}
+		perms := map[string][]string{}
+		for _, r := range rules {
+			if len(r) != 4 {
+				continue
+			}
+			perm := r[1] + ":" + r[2]
+			if r[3] != middleware.ScopeAll {
+				perm += "@" + r[3]
+			}
+			perms[r[0]] = append(perms[r[0]], perm)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tCODE\tNAME\tSTATUS\tPERMISSIONS")
+		for _, r := range roles {
+			var id string
+			if r.ID != nil {
+				id = *r.ID
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", id, r.Code, r.Name, r.Status, strings.Join(perms[id], " "))
+		}
+		return w.Flush()
+	})
+}
+
+func roleGrant(args []string) error {
+	flags := flag.NewFlagSet("role grant", flag.ContinueOnError)
+	scope := flags.String("scope", middleware.ScopeAll, "all, or department for the records of the user's department subtree")
+	args, err := parse(flags, args, "[-scope all|department] <role> <resource> <action>", 3)
+	if err != nil {
+		return err
+	}
+	return withServices(func(ctx context.Context, s *services) error {
+		added, err := s.user.GrantPermission(ctx, args[0], args[1], args[2], *scope)
+		if err != nil {
+			return fmt.Errorf("grant %s: %w", strings.Join(args, " "), err)
+		}
+		report("policy", strings.Join(args, " ")+"@"+*scope, added)
+		return nil
+	})
+}
+
+func roleRevoke(args []string) error {
+	args, err := parse(flag.NewFlagSet("role revoke", flag.ContinueOnError), args, "<role> <resource> <action>", 3)
+	if err != nil {
+		return err
+	}
+	return withServices(func(ctx context.Context, s *services) error {
+		removed, err := s.user.RevokePermission(ctx, args[0], args[1], args[2])
+		if err != nil {
+			return fmt.Errorf("revoke %s: %w", strings.Join(args, " "), err)
+		}
+		state := "absent"
+		if removed {
+			state = "revoked"
+		}
+		fmt.Printf("%-10s %-30s %s\n", "policy", strings.Join(args, " "), state)
+		return nil
+	})
+}
+
+// policyExport writes one `p, role, resource, action, scope` line per rule,
+// the format of a casbin file adapter.
+func policyExport(args []string) error {
+	flags := flag.NewFlagSet("policy export", flag.ContinueOnError)
+	out := flags.String("o", "", "output file, stdout when empty")
+	if _, err := parse(flags, args, "[-o file]", 0); err != nil {
+		return err
+	}
+	return withServices(func(ctx context.Context, s *services) error {
+		rules, err := s.user.ListPolicies(ctx)
+		if err != nil {
+			return err
+		}
+		var w io.Writer = os.Stdout
+		if *out != "" {
+			f, err := os.Create(*out)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		cw := csv.NewWriter(w)
+		for _, r := range rules {
+			if err := cw.Write(append([]string{"p"}, r...)); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+}
+
+// policyImport grants the rules of a file written by export, skipping the
+// ones already there. With -prune the rules missing from the file are
+// revoked, making the file the whole policy.
+func policyImport(args []string) error {
+	flags := flag.NewFlagSet("policy import", flag.ContinueOnError)
+	prune := flags.Bool("prune", false, "revoke the rules missing from the file")
+	args, err := parse(flags, args, "[-prune] <file>", 1)
+	if err != nil {
+		return err
+	}
+	rules, err := readPolicy(args[0])
+	if err != nil {
+		return err
+	}
+	return withServices(func(ctx context.Context, s *services) error {
+		keep := map[string]bool{}
+		for _, r := range rules {
+			keep[strings.Join(r, ",")] = true
+			added, err := s.user.GrantPermission(ctx, r[0], r[1], r[2], r[3])
+			if err != nil {
+				return fmt.Errorf("grant %s: %w", strings.Join(r, " "), err)
+			}
+			report("policy", strings.Join(r, " "), added)
+		}
+		if !*prune {
+			return nil
+		}
+		current, err := s.user.ListPolicies(ctx)
+		if err != nil {
+			return err
+		}
+		for _, r := range current {
+			if len(r) != 4 || keep[strings.Join(r, ",")] {
+				continue
+			}
+			if _, err := s.user.RevokePermission(ctx, r[0], r[1], r[2]); err != nil {
+				return fmt.Errorf("revoke %s: %w", strings.Join(r, " "), err)
+			}
+			fmt.Printf("%-10s %-30s %s\n", "policy", strings.Join(r, " "), "revoked")
+		}
+		return nil
+	})
+}
+
+func readPolicy(name string) ([][]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cr := csv.NewReader(f)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+	var rules [][]string
+	for {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return rules, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 4 || len(rec) > 5 || rec[0] != "p" {
+			line, _ := cr.FieldPos(0)
+			return nil, fmt.Errorf("%s:%d: expected p, <role>, <resource>, <action>[, <scope>]", name, line)
+		}
+		// the files exported before the scopes reach every record.
+		if len(rec) == 4 {
+			rec = append(rec, middleware.ScopeAll)
+		}
+		rules = append(rules, rec[1:])
+	}
+}
+
+func policyCheck(args []string) error {
+	args, err := parse(flag.NewFlagSet("policy check", flag.ContinueOnError), args, "<role> <resource> <action>", 3)
+	if err != nil {
+		return err
+	}
+	return withServices(func(ctx context.Context, s *services) error {
+		scope, err := s.user.CheckPermission(ctx, args[0], args[1], args[2])
+		if err != nil {
+			return err
+		}
+		if scope == "" {
+			return fmt.Errorf("deny %s", strings.Join(args, " "))
+		}
+		fmt.Printf("allow %s (%s)\n", strings.Join(args, " "), scope)
+		return nil
+	})
+}
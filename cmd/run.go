@@ -5,27 +5,45 @@ import (
 	"database/sql"
 	"embed"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/anousonefs/golang-htmx-template/internal/activity"
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
 	"github.com/anousonefs/golang-htmx-template/internal/auth"
 	"github.com/anousonefs/golang-htmx-template/internal/config"
 	home "github.com/anousonefs/golang-htmx-template/internal/dashboard"
+	"github.com/anousonefs/golang-htmx-template/internal/department"
+	"github.com/anousonefs/golang-htmx-template/internal/i18n"
+	"github.com/anousonefs/golang-htmx-template/internal/job"
+	"github.com/anousonefs/golang-htmx-template/internal/lifecycle"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/metrics"
 	mdw "github.com/anousonefs/golang-htmx-template/internal/middleware"
+	"github.com/anousonefs/golang-htmx-template/internal/notification"
+	"github.com/anousonefs/golang-htmx-template/internal/ratelimit"
+	"github.com/anousonefs/golang-htmx-template/internal/rbac"
+	"github.com/anousonefs/golang-htmx-template/internal/session"
+	"github.com/anousonefs/golang-htmx-template/internal/signature"
+	"github.com/anousonefs/golang-htmx-template/internal/tracing"
 	"github.com/anousonefs/golang-htmx-template/internal/user"
+	"github.com/anousonefs/golang-htmx-template/internal/webhook"
 	"github.com/gorilla/sessions"
-	"github.com/labstack/echo-contrib/session"
+	echosession "github.com/labstack/echo-contrib/session"
+	"go.opentelemetry.io/otel/attribute"
 
-	casbinPgAdapter "github.com/cychiuae/casbin-pg-adapter"
+	"github.com/XSAM/otelsql"
+	_ "github.com/go-sql-driver/mysql"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
-//go:embed policy.conf
+//go:embed policy.conf migrations
 var fs embed.FS
 
 func Run() error {
@@ -33,103 +51,242 @@ func Run() error {
 	if err != nil {
 		return err
 	}
-	ctx := context.Background()
+	log := newLogger(cfg)
 
-	errCh := make(chan error, 1)
-	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, os.Kill)
+	// SIGKILL cannot be caught, SIGTERM is what orchestrators send.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	db, err := sql.Open(cfg.DBDriver(), cfg.DSNInfo())
-	if err != nil {
+	app := lifecycle.New(log)
+
+	if err := addTracing(ctx, cfg, app); err != nil {
 		return err
 	}
-	if err := db.Ping(); err != nil {
+	db, err := openDB(ctx, cfg, app)
+	if err != nil {
 		return err
 	}
-	defer db.Close()
 
-	e := newEchoServer(cfg)
+	var sessionStore sessions.Store = session.NewCookieStore(cfg)
+	var sessionRegistry *session.DBStore
+	if cfg.SessionStore() == "db" {
+		sessionRegistry = session.NewDBStore(db, cfg.SQL(), cfg)
+		sessionStore = sessionRegistry
+		app.Add(lifecycle.Component{
+			Name: "session-cleanup",
+			Run: func(ctx context.Context) error {
+				sessionRegistry.Cleanup(ctx, time.Hour)
+				return nil
+			},
+		})
+	}
 
-	adapter, err := casbinPgAdapter.NewAdapter(db, "permissions")
+	e := newEchoServer(cfg, log, sessionStore)
+
+	authz, adapter, model, err := newAuthz(db, cfg)
 	if err != nil {
 		return err
 	}
 
-	mc, err := fs.ReadFile("policy.conf")
+	limiter, err := newLimiter(cfg)
 	if err != nil {
 		return err
 	}
-	model := string(mc)
-	authz := mdw.New(mdw.Config{
-		ModelFilePath: model,
-		PolicyAdapter: adapter,
-		Lookup: func(c echo.Context) string {
-			return mdw.UserClaimFromContext(c.Request().Context()).RoleID
-		},
-		Forbidden: func(c echo.Context) error {
-			return err
+
+	bus := newBus(cfg, log, db)
+	app.Add(lifecycle.Component{Name: "event-dispatcher", Run: bus.Run})
+
+	repo := user.NewRepo(db, cfg.SQL(), model, adapter, authz)
+	departmentService := department.NewService(department.NewRepo(db, cfg.SQL()))
+	userService := user.NewService(repo, bus, departmentService)
+	app.Add(lifecycle.Component{
+		Name: "policy-reloader",
+		Run: func(ctx context.Context) error {
+			return userService.WatchPolicy(ctx, cfg.PolicyReloadInterval())
 		},
 	})
+	notificationService := newNotification(cfg, log, db)
+	webhookService := newWebhook(cfg, db)
+	blobs, err := newBlobStore(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	user.NewHandler(e, userService, cfg, authz, user.NewImporter(&userService, blobs)).Install(e, cfg, limiter)
+	department.NewHandler(departmentService, authz).Install(e, cfg, limiter)
 
-	activityRepo := activity.NewRepo(db)
-	activityService := activity.NewService(activityRepo)
+	signatureService := signature.NewService(signature.NewRepo(db, cfg.SQL(), cfg.DBDriver()), blobs, bus)
+	signature.NewHandler(signatureService, authz).Install(e, cfg, limiter)
 
-	repo := user.NewRepo(db, model, adapter, authz)
-	userService := user.NewService(repo, activityService)
-	user.NewHandler(e, userService, cfg).Install(e, cfg)
+	authService := auth.NewService(userService, bus, sessionStore, cfg)
+	auth.NewHandler(e, authService, cfg).Install(e, limiter)
+	if sessionRegistry != nil {
+		session.NewHandler(sessionRegistry, cfg).Install(e, cfg, limiter)
+	}
 
-	sessionStore := auth.NewCookieStore(auth.SessionOptions{
-		CookiesKey: "mycookies7898",
-		MaxAge:     1000,
-		Secure:     false,
-		HttpOnly:   false,
-	})
+	broker := notification.NewBroker(notification.NewRepo(db, cfg.SQL()), 2*time.Second, log)
+	app.Add(lifecycle.Component{Name: "notification-broker", Run: broker.Run})
+	// the open streams end as soon as the shutdown starts, it would
+	// otherwise wait for them until its timeout.
+	e.Server.RegisterOnShutdown(broker.Close)
+	notification.NewHandler(notificationService, broker).Install(e, cfg, limiter)
 
-	authService := auth.NewService(userService, sessionStore, cfg)
-	auth.NewHandler(e, authService, cfg).Install(e)
+	webhook.NewHandler(webhookService, authz).Install(e, cfg, limiter)
 
 	homeService := home.NewService()
 	home.NewHandler(e, homeService).Install(e, cfg)
 
-	go func() {
-		errCh <- e.Start(":" + cfg.AppPort())
-	}()
-
-	select {
-	case <-ctx.Done():
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if err := e.Shutdown(ctx); err != nil {
-			return fmt.Errorf("shutdown server failure: %v", err)
+	jobService := job.NewService(job.NewRepo(db, cfg.SQL(), cfg.DBDriver()))
+	job.NewHandler(jobService, authz).Install(e, cfg, limiter)
+	if cfg.WorkerEnabled() {
+		w, err := newWorker(cfg, log, db)
+		if err != nil {
+			return err
 		}
-	case err := <-errCh:
-		return fmt.Errorf("start server error: %v", err)
+		app.Add(workerComponent(w))
+	}
+
+	if cfg.MetricsToken() != "" {
+		e.GET("/metrics", echo.WrapHandler(metrics.RequireToken(cfg.MetricsToken(), metrics.Handler())))
+	}
+	addMetricsServer(cfg, app)
+
+	// last in, first out: in-flight requests finish before anything they
+	// use is stopped.
+	server := lifecycle.HTTPServer("http", func() error {
+		return e.Start(":" + cfg.AppPort())
+	}, e.Shutdown)
+	server.StopTimeout = 15 * time.Second
+	app.Add(server)
+
+	return app.Run(ctx)
+}
+
+// addTracing installs the tracer provider, added first so spans of the
+// other stop hooks are still exported.
+func addTracing(ctx context.Context, cfg config.Config, app *lifecycle.Manager) error {
+	shutdownTracing, err := tracing.Setup(ctx, cfg.TracingExporter(), cfg.TracingFile(), cfg.ServiceName())
+	if err != nil {
+		return err
 	}
+	app.Add(lifecycle.Component{
+		Name:        "tracing",
+		Stop:        shutdownTracing,
+		StopTimeout: 5 * time.Second,
+	})
 	return nil
 }
 
-func newEchoServer(_ config.Config) *echo.Echo {
-	mws := []echo.MiddlewareFunc{
-		middleware.LoggerWithConfig(middleware.LoggerConfig{
-			Skipper: func(c echo.Context) bool {
-				return c.Path() == "/" || c.Path() == "/_healthz"
-			},
+// openDB connects to the database, closed by app once every component
+// added after it has stopped, and applies the migrations when AUTO_MIGRATE
+// is set.
+func openDB(ctx context.Context, cfg config.Config, app *lifecycle.Manager) (*sql.DB, error) {
+	// every statement gets a span with the SQL built by squirrel.
+	db, err := otelsql.Open(cfg.DBDriver(), cfg.DSNInfo(),
+		otelsql.WithAttributes(attribute.String("db.system", cfg.DBDriver())),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
 		}),
-		middleware.Recover(),
-		middleware.Secure(),
-		middleware.CORS(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	app.Add(lifecycle.Component{
+		Name: "database",
+		Stop: func(context.Context) error { return db.Close() },
+	})
+	if err := db.PingContext(ctx); err != nil {
+		return nil, err
+	}
+	if err := metrics.RegisterDB(db, cfg.DBDriver()); err != nil {
+		return nil, err
+	}
+	if cfg.AutoMigrate() {
+		if err := migrateUp(ctx, db, cfg.DBDriver()); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+func addMetricsServer(cfg config.Config, app *lifecycle.Manager) {
+	if cfg.MetricsAddr() == "" {
+		return
+	}
+	metricsServer := &http.Server{Addr: cfg.MetricsAddr(), Handler: metrics.Handler()}
+	c := lifecycle.HTTPServer("metrics", metricsServer.ListenAndServe, metricsServer.Shutdown)
+	c.StopTimeout = 5 * time.Second
+	app.Add(c)
+}
+
+func newAuthz(db *sql.DB, cfg config.Config) (*mdw.CasbinMiddleware, *rbac.Adapter, string, error) {
+	adapter := rbac.NewAdapter(db, cfg.SQL(), "permissions")
+
+	mc, err := fs.ReadFile("policy.conf")
+	if err != nil {
+		return nil, nil, "", err
 	}
+	model := string(mc)
+	departments := department.NewService(department.NewRepo(db, cfg.SQL()))
+	authz := mdw.New(mdw.Config{
+		ModelFilePath: model,
+		PolicyAdapter: adapter,
+		Lookup: func(c echo.Context) string {
+			return mdw.UserClaimFromContext(c.Request().Context()).RoleID
+		},
+		Departments: departments.Subtree,
+	})
+	return authz, adapter, model, nil
+}
+
+func newLimiter(cfg config.Config) (*ratelimit.Limiter, error) {
+	var policies []ratelimit.Policy
+	for name, s := range cfg.RateLimits() {
+		p, err := ratelimit.ParsePolicy(name, s)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	return ratelimit.New(ratelimit.NewMemoryStore(), policies...), nil
+}
+
+// newLogger builds the process logger from cfg and makes it the slog
+// default, so code running outside a request logs the same way.
+func newLogger(cfg config.Config) *slog.Logger {
+	log := logger.New(os.Stdout, cfg.LogFormat(), logger.ParseLevel(cfg.LogLevel())).
+		With("env", cfg.AppEnv())
+	slog.SetDefault(log)
+	return log
+}
+
+func newEchoServer(cfg config.Config, log *slog.Logger, store sessions.Store) *echo.Echo {
 	e := echo.New()
-	e.Use(mdw.CSPMiddleware)
+	e.IPExtractor = mdw.IPExtractor(cfg)
+	e.Use(logger.Middleware(log, func(c echo.Context) bool {
+		return c.Path() == "/" || c.Path() == "/_healthz"
+	}))
+	e.Use(tracing.Middleware(func(c echo.Context) bool {
+		return c.Path() == "/_healthz" || c.Path() == "/metrics"
+	}))
+	e.Use(metrics.Middleware(func(c echo.Context) bool {
+		return c.Path() == "/metrics"
+	}))
+	i18n.SetDefault(cfg.DefaultLocale())
+	e.Use(i18n.Middleware)
+	e.Use(mdw.Security(cfg)...)
 	e.Use(mdw.CacheControlMiddleware)
-	e.Use(session.Middleware(sessions.NewCookieStore([]byte("secret"))))
+	e.Use(echosession.Middleware(store))
+	e.Use(mdw.CSRF(cfg)...)
 
 	pwd, _ := os.Getwd()
 	e.Static("static", fmt.Sprintf("%v/static", pwd))
 
 	e.HideBanner = true
+	e.HTTPErrorHandler = apperror.HTTPErrorHandler
 	e.Pre(middleware.RemoveTrailingSlash())
-	e.Use(mws...)
+	e.Use(middleware.Recover())
+	e.POST(mdw.CSPReportPath, mdw.CSPReportHandler)
 	e.GET("/_healthz", func(c echo.Context) error {
 		return c.JSON(http.StatusOK, echo.Map{"serverStatus": "running"})
 	})
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/event"
+	usr "github.com/anousonefs/golang-htmx-template/internal/user"
+)
+
+func userCommand() *command {
+	return &command{
+		name:  "user",
+		usage: "create|disable|enable|reset-password|set-role",
+		short: "manage user accounts",
+		sub: []*command{
+			{name: "create", usage: "-email <email> -phone <phone> -role <role>", short: "create a user", run: userCreate},
+			{name: "disable", usage: "<email|id>", short: "stop a user from signing in", run: userStatus(usr.UserStatusInActive)},
+			{name: "enable", usage: "<email|id>", short: "allow a disabled user to sign in again", run: userStatus(usr.UserStatusActive)},
+			{name: "reset-password", usage: "<email|id>", short: "set a new password, read from stdin", run: userResetPassword},
+			{name: "set-role", usage: "<email|id> <role>", short: "move a user to another role", run: userSetRole},
+		},
+	}
+}
+
+func userCreate(args []string) error {
+	flags := flag.NewFlagSet("user create", flag.ContinueOnError)
+	email := flags.String("email", "", "email, used to sign in")
+	phone := flags.String("phone", "", "phone number")
+	role := flags.String("role", "", "role id")
+	firstName := flags.String("first-name", "", "first name")
+	lastName := flags.String("last-name", "", "last name")
+	gender := flags.String("gender", string(usr.GendersO), "M, F or O")
+	department := flags.String("department", "", "department id")
+	password := flags.String("password", "", "password, read from stdin when empty")
+	if _, err := parse(flags, args, "-email <email> -phone <phone> -role <role> [flags]", 0); err != nil {
+		return err
+	}
+	if *password == "" {
+		var err error
+		if *password, err = readPassword(); err != nil {
+			return err
+		}
+	}
+	req := usr.User{
+		RoleID:       *role,
+		FirstName:    *firstName,
+		LastName:     *lastName,
+		Gender:       *gender,
+		Email:        *email,
+		Phone:        *phone,
+		DepartmentID: *department,
+		Password:     *password,
+		CreatedBy:    actor(),
+	}
+	if err := req.Validate(); err != nil {
+		return err
+	}
+	return withServices(func(ctx context.Context, s *services) error {
+		if *role != "" {
+			if _, err := s.user.GetRole(ctx, usr.FilterRole{ID: *role}); err != nil {
+				return fmt.Errorf("role %s: %w", *role, err)
+			}
+		}
+		if err := s.user.CreateUser(ctx, req, event.Actor{ID: req.CreatedBy}); err != nil {
+			return err
+		}
+		report("user", *email, true)
+		return nil
+	})
+}
+
+func userStatus(status usr.UserStatus) func(args []string) error {
+	return func(args []string) error {
+		name := "user disable"
+		if status == usr.UserStatusActive {
+			name = "user enable"
+		}
+		args, err := parse(flag.NewFlagSet(name, flag.ContinueOnError), args, "<email|id>", 1)
+		if err != nil {
+			return err
+		}
+		return withServices(func(ctx context.Context, s *services) error {
+			u, err := findUser(ctx, s, args[0])
+			if err != nil {
+				return err
+			}
+			if err := s.user.SetStatus(ctx, u.ID, status, event.Actor{ID: actor()}); err != nil {
+				return err
+			}
+			fmt.Printf("%-10s %-30s %s\n", "user", u.Email, strings.ToLower(string(status)))
+			return nil
+		})
+	}
+}
+
+func userResetPassword(args []string) error {
+	args, err := parse(flag.NewFlagSet("user reset-password", flag.ContinueOnError), args, "<email|id>", 1)
+	if err != nil {
+		return err
+	}
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	return withServices(func(ctx context.Context, s *services) error {
+		u, err := findUser(ctx, s, args[0])
+		if err != nil {
+			return err
+		}
+		if err := s.user.ResetPassword(ctx, u.ID, password, event.Actor{ID: actor()}); err != nil {
+			return err
+		}
+		fmt.Printf("%-10s %-30s %s\n", "user", u.Email, "password reset")
+		return nil
+	})
+}
+
+func userSetRole(args []string) error {
+	args, err := parse(flag.NewFlagSet("user set-role", flag.ContinueOnError), args, "<email|id> <role>", 2)
+	if err != nil {
+		return err
+	}
+	return withServices(func(ctx context.Context, s *services) error {
+		u, err := findUser(ctx, s, args[0])
+		if err != nil {
+			return err
+		}
+		if err := s.user.SetRole(ctx, u.ID, args[1], event.Actor{ID: actor()}); err != nil {
+			if errors.Is(err, apperror.ErrStatusNotFound) {
+				return fmt.Errorf("role %s not found", args[1])
+			}
+			return err
+		}
+		fmt.Printf("%-10s %-30s %s\n", "user", u.Email, "role "+args[1])
+		return nil
+	})
+}
+
+// findUser looks the user up by email when ref has an @, by id otherwise.
+func findUser(ctx context.Context, s *services, ref string) (*usr.UserDetail, error) {
+	filter := usr.FilterUser{ID: ref}
+	if strings.Contains(ref, "@") {
+		filter = usr.FilterUser{Email: ref}
+	}
+	u, err := s.user.GetUser(ctx, filter)
+	if errors.Is(err, apperror.ErrStatusNotFound) {
+		return nil, fmt.Errorf("user %s not found", ref)
+	}
+	return u, err
+}
+
+// readPassword reads the first line of stdin, so the password stays out of
+// the shell history: `echo "$PASSWORD" | app user reset-password a@b.co`.
+func readPassword() (string, error) {
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "password: ")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("no password on stdin")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,19 @@
+package cmd
+
+import (
+	"database/sql"
+
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/job"
+	"github.com/anousonefs/golang-htmx-template/internal/webhook"
+)
+
+// newWebhook builds the webhook service, the deliveries are queued as jobs
+// and sent by the workers.
+func newWebhook(cfg config.Config, db *sql.DB) *webhook.Service {
+	return webhook.NewService(
+		webhook.NewRepo(db, cfg.SQL(), cfg.DBDriver()),
+		job.NewService(job.NewRepo(db, cfg.SQL(), cfg.DBDriver())),
+		webhook.NewSender(nil, cfg.WebhookAllowPrivate()),
+	)
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/job"
+	"github.com/anousonefs/golang-htmx-template/internal/lifecycle"
+)
+
+// newWorker builds the job worker of cfg, the handlers and schedules of
+// every feature are registered here so the server and the worker command
+// run the same jobs.
+func newWorker(cfg config.Config, log *slog.Logger, db *sql.DB) (*job.Worker, error) {
+	w := job.NewWorker(job.NewRepo(db, cfg.SQL(), cfg.DBDriver()), job.WorkerConfig{
+		Queues:       cfg.WorkerQueues(),
+		Concurrency:  cfg.WorkerConcurrency(),
+		PollInterval: cfg.WorkerPollInterval(),
+		Retention:    cfg.JobRetention(),
+	}, log)
+	newNotification(cfg, log, db).Install(w)
+	newWebhook(cfg, db).Install(w)
+	return w, nil
+}
+
+// workerComponent stops the worker after the http server, its running jobs
+// finish before the database is closed.
+func workerComponent(w *job.Worker) lifecycle.Component {
+	return lifecycle.Component{
+		Name:        "worker",
+		Run:         w.Run,
+		Stop:        w.Wait,
+		StopTimeout: 30 * time.Second,
+	}
+}
+
+// runWorker runs the job workers without the http server, for deployments
+// that scale them apart with WORKER_ENABLED=false on the servers.
+func runWorker(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("worker takes no arguments, configure it with the environment")
+	}
+	cfg, err := config.NewConfig()
+	if err != nil {
+		return err
+	}
+	log := newLogger(cfg)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	app := lifecycle.New(log)
+	if err := addTracing(ctx, cfg, app); err != nil {
+		return err
+	}
+	db, err := openDB(ctx, cfg, app)
+	if err != nil {
+		return err
+	}
+	w, err := newWorker(cfg, log, db)
+	if err != nil {
+		return err
+	}
+	app.Add(workerComponent(w))
+	addMetricsServer(cfg, app)
+	return app.Run(ctx)
+}
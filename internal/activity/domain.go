@@ -10,6 +10,7 @@ type Activity struct {
 	ReqData      []byte `json:"reqData"`
 	ResData      []byte `json:"resData"`
 	DepartmentID string `json:"departmentID"`
+	RequestID    string `json:"requestID"`
 	CreatedBy    string `json:"createdBy"`
 	CreatedAt    string `json:"createdAt"`
 }
@@ -17,9 +18,19 @@ type Activity struct {
 type ActivityList []Activity
 
 type FilterActivity struct {
+	Resource  string
+	CreatedBy string
+	// Limit keeps the newest rows only, 0 returns every row.
+	Limit uint64
 }
 
 func (r FilterActivity) ToSql() (string, []interface{}, error) {
 	eq := squirrel.Eq{}
+	if r.Resource != "" {
+		eq["resource"] = r.Resource
+	}
+	if r.CreatedBy != "" {
+		eq["created_by"] = r.CreatedBy
+	}
 	return eq.ToSql()
 }
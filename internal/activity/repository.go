@@ -4,19 +4,29 @@ import (
 	"context"
 	"database/sql"
 
-	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/database"
+	"github.com/anousonefs/golang-htmx-template/internal/metrics"
+
+	"github.com/Masterminds/squirrel"
 )
 
 type Repo struct {
-	db *sql.DB
+	db database.DBTX
+	sb squirrel.StatementBuilderType
+}
+
+func NewRepo(db *sql.DB, sb squirrel.StatementBuilderType) *Repo {
+	return &Repo{db: db, sb: sb}
 }
 
-func NewRepo(db *sql.DB) *Repo {
-	return &Repo{db: db}
+// WithTx returns a copy of the repo that runs its queries on tx.
+func (r Repo) WithTx(tx *sql.Tx) *Repo {
+	return &Repo{db: tx, sb: r.sb}
 }
 
 func (r Repo) createActivity(ctx context.Context, req Activity) error {
-	query, args, err := config.Psql().
+	defer metrics.ObserveQuery("activity", "createActivity")()
+	query, args, err := r.sb.
 		Insert("activities").
 		Columns(
 			"title",
@@ -24,6 +34,8 @@ func (r Repo) createActivity(ctx context.Context, req Activity) error {
 			"action",
 			"req_data",
 			"res_data",
+			"department_id",
+			"request_id",
 			"created_by",
 		).
 		Values(
@@ -32,6 +44,8 @@ func (r Repo) createActivity(ctx context.Context, req Activity) error {
 			req.Action,
 			req.ReqData,
 			req.ResData,
+			req.DepartmentID,
+			req.RequestID,
 			req.CreatedBy,
 		).
 		ToSql()
@@ -45,21 +59,55 @@ func (r Repo) createActivity(ctx context.Context, req Activity) error {
 	return nil
 }
 
+// listActivities returns the matching activities, newest first.
 func (r Repo) listActivities(ctx context.Context, req FilterActivity) (res ActivityList, err error) {
-	query, args := config.Psql().
+	defer metrics.ObserveQuery("activity", "listActivities")()
+	q := r.sb.
 		Select(
+			"id",
 			"title",
 			"resource",
 			"action",
 			"req_data",
 			"res_data",
 			"department_id",
+			"request_id",
 			"created_by",
 			"created_at",
-		).From("activities").Where(req).MustSql()
-	_, err = r.db.QueryContext(ctx, query, args...)
+		).
+		From("activities").
+		Where(req).
+		OrderBy("created_at DESC")
+	if req.Limit > 0 {
+		q = q.Limit(req.Limit)
+	}
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return res, err
+		return nil, err
+	}
+	defer rows.Close()
+	res = ActivityList{}
+	for rows.Next() {
+		var i Activity
+		if err := rows.Scan(
+			&i.ID,
+			&i.Title,
+			&i.Resource,
+			&i.Action,
+			&i.ReqData,
+			&i.ResData,
+			&i.DepartmentID,
+			&i.RequestID,
+			&i.CreatedBy,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		res = append(res, i)
 	}
-	return res, nil
+	return res, rows.Err()
 }
@@ -1,6 +1,13 @@
 package activity
 
-import "context"
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/anousonefs/golang-htmx-template/internal/event"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+)
 
 type Service struct {
 	repo *Repo
@@ -12,10 +19,60 @@ func NewService(repo *Repo) *Service {
 	}
 }
 
+// WithTx returns a copy of the service whose writes join tx, so the activity
+// commits or rolls back together with the operation it records.
+func (s Service) WithTx(tx *sql.Tx) *Service {
+	return &Service{repo: s.repo.WithTx(tx)}
+}
+
+// CreateActivity records req, tagged with the request ID of ctx unless the
+// caller set one.
 func (s Service) CreateActivity(ctx context.Context, req Activity) error {
+	if req.RequestID == "" {
+		req.RequestID = logger.RequestIDFromContext(ctx)
+	}
 	return s.repo.createActivity(ctx, req)
 }
 
 func (s Service) ListActivity(ctx context.Context, req FilterActivity) (res ActivityList, err error) {
 	return s.repo.listActivities(ctx, req)
 }
+
+// Subscribe records the changes of the user accounts published on bus.
+func (s *Service) Subscribe(bus *event.Bus) {
+	bus.Subscribe(s.record,
+		event.UserCreated{},
+		event.StatusChanged{},
+		event.PasswordReset{},
+		event.RoleChanged{},
+		event.SignerChanged{},
+		event.SignatureChanged{},
+	)
+}
+
+func (s Service) record(ctx context.Context, tx *sql.Tx, e event.Event) error {
+	act := Activity{Resource: "user", Action: "update"}
+	var actor event.Actor
+	switch e := e.(type) {
+	case event.UserCreated:
+		act.Title, act.Action, actor = "Create User", "create", e.Actor
+	case event.StatusChanged:
+		act.Title, actor = "Update User Status", e.Actor
+	case event.PasswordReset:
+		act.Title, actor = "Reset Password", e.Actor
+	case event.RoleChanged:
+		act.Title, actor = "Update User Role", e.Actor
+	case event.SignerChanged:
+		act.Title, actor = "Update User Signer", e.Actor
+	case event.SignatureChanged:
+		act.Title, actor = "Update User Signature", e.Actor
+	default:
+		return nil
+	}
+	var err error
+	if act.ResData, err = json.Marshal(e); err != nil {
+		return err
+	}
+	act.CreatedBy, act.DepartmentID = actor.ID, actor.DepartmentID
+	return s.WithTx(tx).CreateActivity(ctx, act)
+}
@@ -0,0 +1,147 @@
+package apperror
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/anousonefs/golang-htmx-template/internal/i18n"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/tracing"
+
+	"github.com/labstack/echo/v4"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// ErrorTarget is the element htmx requests render their errors into, see
+// templates.Layout.
+const ErrorTarget = "#errors"
+
+// HTTPErrorHandler renders every error returned from a handler or
+// middleware: an ErrorAlert fragment retargeted to ErrorTarget for htmx
+// requests, the protobuf Error JSON for everything else.
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+	ctx := c.Request().Context()
+	lang := i18n.Lang(ctx)
+	s := Localize(StatusFromErr(err, lang), lang)
+	s = withRequestInfo(s, logger.RequestIDFromContext(ctx), tracing.TraceID(ctx))
+	hs := HttpStatusPbFromRPC(s)
+	code := int(hs.Error.Code)
+	log := logger.FromContext(ctx)
+	if code >= http.StatusInternalServerError {
+		log.Error("request failed", "err", err)
+	} else {
+		log.Debug("request rejected", "err", err, "status", code)
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(code)
+	} else if IsHTMX(c) {
+		h := c.Response().Header()
+		h.Set("HX-Retarget", ErrorTarget)
+		h.Set("HX-Reswap", "innerHTML")
+		h.Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+		c.Response().WriteHeader(code)
+		if err = ErrorAlert(LocalizedMessage(s)).Render(ctx, c.Response()); err == nil {
+			for _, fv := range FieldViolations(s) {
+				if err = FieldError(fv.GetField(), fv.GetDescription(), true).Render(ctx, c.Response()); err != nil {
+					break
+				}
+			}
+		}
+	} else {
+		var b []byte
+		if b, err = protojson.Marshal(hs); err == nil {
+			err = c.JSONBlob(code, b)
+		}
+	}
+	if err != nil {
+		log.Error("write error response", "err", err)
+	}
+}
+
+// withRequestInfo adds a google.rpc.RequestInfo so clients can quote the
+// request ID found in the logs and activities, and the trace ID to find the
+// request in the tracing backend.
+func withRequestInfo(s *status.Status, requestID, traceID string) *status.Status {
+	if requestID == "" || s.Code() == codes.OK {
+		return s
+	}
+	info := &edpb.RequestInfo{RequestId: requestID}
+	if traceID != "" {
+		info.ServingData = "trace_id=" + traceID
+	}
+	rs, err := s.WithDetails(info)
+	if err != nil {
+		return s
+	}
+	return rs
+}
+
+// LocalizedStatus is implemented by errors whose details depend on the
+// locale, such as validation.Error.
+type LocalizedStatus interface {
+	LocalizedStatus(lang string) *status.Status
+}
+
+// StatusFromErr is GRPCStatusFromErr that lets a LocalizedStatus render
+// itself in lang.
+func StatusFromErr(err error, lang string) *status.Status {
+	var ls LocalizedStatus
+	if errors.As(err, &ls) {
+		return ls.LocalizedStatus(lang)
+	}
+	return GRPCStatusFromErr(err)
+}
+
+// Localize adds a google.rpc.LocalizedMessage translating the status message
+// to lang. Statuses that already carry one are returned unchanged.
+func Localize(s *status.Status, lang string) *status.Status {
+	if s.Code() == codes.OK {
+		return s
+	}
+	for _, d := range s.Details() {
+		if _, ok := d.(*edpb.LocalizedMessage); ok {
+			return s
+		}
+	}
+	ls, err := s.WithDetails(&edpb.LocalizedMessage{
+		Locale:  lang,
+		Message: i18n.Translate(lang, s.Message()),
+	})
+	if err != nil {
+		return s
+	}
+	return ls
+}
+
+// LocalizedMessage returns the user facing message of s.
+func LocalizedMessage(s *status.Status) string {
+	for _, d := range s.Details() {
+		if lm, ok := d.(*edpb.LocalizedMessage); ok {
+			return lm.GetMessage()
+		}
+	}
+	return s.Message()
+}
+
+// FieldViolations returns the google.rpc.BadRequest field violations
+// carried by s.
+func FieldViolations(s *status.Status) []*edpb.BadRequest_FieldViolation {
+	var res []*edpb.BadRequest_FieldViolation
+	for _, d := range s.Details() {
+		if br, ok := d.(*edpb.BadRequest); ok {
+			res = append(res, br.GetFieldViolations()...)
+		}
+	}
+	return res
+}
+
+func IsHTMX(c echo.Context) bool {
+	return c.Request().Header.Get("HX-Request") == "true"
+}
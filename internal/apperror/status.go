@@ -1,4 +1,4 @@
-package user
+package apperror
 
 import (
 	"errors"
@@ -6,6 +6,7 @@ import (
 
 	hspb "github.com/anousonefs/golang-htmx-template/internal/proto/http"
 
+	"github.com/labstack/echo/v4"
 	"google.golang.org/genproto/googleapis/rpc/code"
 	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
@@ -29,13 +30,17 @@ var (
 	ErrStatusNotFound       = errors.New("not found")
 	ErrBadRequest           = errors.New("bad request")
 	ErrDuplicateKey         = errors.New("duplicate key")
+	ErrTooManyRequests      = errors.New("too many requests")
+	ErrInUse                = errors.New("in use")
 )
 
+const domain = "htmx"
+
 var StatusInvalidENUM = func() *status.Status {
 	s, _ := status.New(codes.InvalidArgument, "invalid_enum").
 		WithDetails(&edpb.ErrorInfo{
 			Reason: "INVALID_ENUM",
-			Domain: "e-doc",
+			Domain: domain,
 		})
 	return s
 }()
@@ -44,7 +49,7 @@ var StatusInvalidUUID = func() *status.Status {
 	s, _ := status.New(codes.InvalidArgument, "invalid_uuid").
 		WithDetails(&edpb.ErrorInfo{
 			Reason: "INVALID_UUID",
-			Domain: "e-doc",
+			Domain: domain,
 		})
 	return s
 }()
@@ -53,7 +58,7 @@ var StatusBindingFailure = func() *status.Status {
 	s, _ := status.New(codes.InvalidArgument, "binding_json_body_failure_please_pass_a_valid_json_body").
 		WithDetails(&edpb.ErrorInfo{
 			Reason: "BINDING_FAILURE",
-			Domain: "e-doc",
+			Domain: domain,
 		})
 	return s
 }()
@@ -62,19 +67,32 @@ var StatusInvalidPassword = func() *status.Status {
 	s, _ := status.New(codes.InvalidArgument, "password_length_should_greater_than_6").
 		WithDetails(&edpb.ErrorInfo{
 			Reason: "INVALID_PASSWORD",
-			Domain: "e-doc",
+			Domain: domain,
 		})
 	return s
 }()
 
+var StatusPasetoMissingOrUnsupport = func() *status.Status {
+	s, _ := status.New(codes.Unauthenticated, "Invalid or missing authorization token").
+		WithDetails(
+			&edpb.ErrorInfo{
+				Reason: "TOKEN_INVALID",
+				Domain: "htmx.com.la",
+				Metadata: map[string]string{
+					"service": "htmx",
+				},
+			})
+	return s
+}()
+
 var StatusUnauthenticated = func() *status.Status {
 	s, _ := status.New(codes.Unauthenticated, "id_token_not_valid_please_pass_a_valid_id_token").
 		WithDetails(
 			&edpb.ErrorInfo{
 				Reason: "TOKEN_INVALID",
-				Domain: "e-doc.com.la",
+				Domain: "htmx.com.la",
 				Metadata: map[string]string{
-					"service": "e-doc",
+					"service": "htmx",
 				},
 			})
 	return s
@@ -85,7 +103,7 @@ var StatusSessionExpired = func() *status.Status {
 		WithDetails(
 			&edpb.ErrorInfo{
 				Reason: "SESSION_EXPIRED",
-				Domain: "e-doc",
+				Domain: domain,
 			})
 	return s
 }()
@@ -95,7 +113,7 @@ var StatusPermissionDenied = func() *status.Status {
 		WithDetails(
 			&edpb.ErrorInfo{
 				Reason: "INSUFFICIENT_PERMISSION",
-				Domain: "e-doc",
+				Domain: domain,
 			})
 	return s
 }()
@@ -105,7 +123,7 @@ var StatusNoInfo = func() *status.Status {
 		WithDetails(
 			&edpb.ErrorInfo{
 				Reason: "NOT_FOUND",
-				Domain: "e-doc",
+				Domain: domain,
 			})
 	return s
 }()
@@ -115,7 +133,7 @@ var StatusDuplicateKey = func() *status.Status {
 		WithDetails(
 			&edpb.ErrorInfo{
 				Reason: "DUPLICATE_KEY",
-				Domain: "e-doc",
+				Domain: domain,
 			})
 	return s
 }()
@@ -125,7 +143,7 @@ var StatusOutOfRange = func() *status.Status {
 		WithDetails(
 			&edpb.ErrorInfo{
 				Reason: "UNPROCESSABLE_ENTITY",
-				Domain: "e-doc",
+				Domain: domain,
 			})
 	return s
 }()
@@ -135,7 +153,7 @@ var StatusInternalServerError = func() *status.Status {
 		WithDetails(
 			&edpb.ErrorInfo{
 				Reason: "INTERNAL_SERVER_ERROR",
-				Domain: "e-doc",
+				Domain: domain,
 			})
 	return s
 }()
@@ -145,7 +163,7 @@ var StatusAlreadyExist = func() *status.Status {
 		WithDetails(
 			&edpb.ErrorInfo{
 				Reason: "ALREADY_EXISTS",
-				Domain: "e-doc",
+				Domain: domain,
 			})
 	return s
 }()
@@ -155,7 +173,7 @@ var StatusNameAlreadyExist = func() *status.Status {
 		WithDetails(
 			&edpb.ErrorInfo{
 				Reason: "name",
-				Domain: "e-doc",
+				Domain: domain,
 			})
 	return s
 }()
@@ -165,7 +183,7 @@ var StatusUsernameAlreadyExist = func() *status.Status {
 		WithDetails(
 			&edpb.ErrorInfo{
 				Reason: "username",
-				Domain: "e-doc",
+				Domain: domain,
 			})
 	return s
 }()
@@ -175,7 +193,7 @@ var StatusPhoneAlreadyExist = func() *status.Status {
 		WithDetails(
 			&edpb.ErrorInfo{
 				Reason: "phone",
-				Domain: "e-doc",
+				Domain: domain,
 			})
 	return s
 }()
@@ -185,7 +203,7 @@ var StatusEmailAlreadyExist = func() *status.Status {
 		WithDetails(
 			&edpb.ErrorInfo{
 				Reason: "email",
-				Domain: "e-doc",
+				Domain: domain,
 			})
 	return s
 }()
@@ -195,7 +213,7 @@ var StatusInvalidCursor = func() *status.Status {
 		WithDetails(
 			&edpb.ErrorInfo{
 				Reason: "INVALID_CURSOR",
-				Domain: "e-doc",
+				Domain: domain,
 			})
 	return s
 }()
@@ -205,7 +223,17 @@ var StatusOTPNumberNotEqual = func() *status.Status {
 		WithDetails(
 			&edpb.ErrorInfo{
 				Reason: "INVALID_OTP_NUMBER",
-				Domain: "e-doc",
+				Domain: domain,
+			})
+	return s
+}()
+
+var StatusInUse = func() *status.Status {
+	s, _ := status.New(codes.FailedPrecondition, "in_use").
+		WithDetails(
+			&edpb.ErrorInfo{
+				Reason: "IN_USE",
+				Domain: domain,
 			})
 	return s
 }()
@@ -215,7 +243,7 @@ var StatusNotAllow = func() *status.Status {
 		WithDetails(
 			&edpb.ErrorInfo{
 				Reason: "INVALID_STATUS",
-				Domain: "e-doc",
+				Domain: domain,
 			})
 	return s
 }()
@@ -225,12 +253,37 @@ var StatusBadRequest = func() *status.Status {
 		WithDetails(
 			&edpb.ErrorInfo{
 				Reason: "INVALID_INPUT",
-				Domain: "e-doc",
+				Domain: domain,
 			})
 	return s
 }()
 
+var StatusTooManyRequests = func() *status.Status {
+	s, _ := status.New(codes.ResourceExhausted, "too_many_requests").
+		WithDetails(
+			&edpb.ErrorInfo{
+				Reason: "RATE_LIMITED",
+				Domain: domain,
+			})
+	return s
+}()
+
+var StatusInvalidCSRFToken = func() *status.Status {
+	s, _ := status.New(codes.PermissionDenied, "invalid_csrf_token").
+		WithDetails(
+			&edpb.ErrorInfo{
+				Reason: "CSRF_TOKEN_INVALID",
+				Domain: domain,
+			})
+	return s
+}()
+
+// GRPCStatusFromErr is the one place domain errors are mapped to a status.
+// Errors that already carry a status (status.Status.Err()) keep it.
 func GRPCStatusFromErr(err error) *status.Status {
+	if s, ok := status.FromError(err); ok && err != nil {
+		return s
+	}
 	switch {
 	case err == nil:
 		return status.New(codes.OK, "OK")
@@ -240,8 +293,8 @@ func GRPCStatusFromErr(err error) *status.Status {
 		return StatusPermissionDenied
 	case errors.Is(err, ErrBadRequest):
 		return StatusBadRequest
-	case errors.Is(err, ErrUnauthorized):
-		return StatusUnauthenticated
+	case errors.Is(err, ErrNoInfo), errors.Is(err, ErrStatusNotFound):
+		return StatusNoInfo
 	case errors.Is(err, ErrDuplicateKey):
 		return StatusDuplicateKey
 	case errors.Is(err, ErrUnProcessAbleEntity):
@@ -264,6 +317,15 @@ func GRPCStatusFromErr(err error) *status.Status {
 		return StatusOTPNumberNotEqual
 	case errors.Is(err, ErrStatusNotAllow):
 		return StatusNotAllow
+	case errors.Is(err, ErrTooManyRequests):
+		return StatusTooManyRequests
+	case errors.Is(err, ErrInUse):
+		return StatusInUse
+	}
+
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return status.New(codeFromHTTPStatus(he.Code), http.StatusText(he.Code))
 	}
 
 	return StatusInternalServerError
@@ -323,3 +385,36 @@ func httpStatusFromCode(code codes.Code) int {
 
 	return http.StatusInternalServerError
 }
+
+// codeFromHTTPStatus is the reverse of httpStatusFromCode, used for errors
+// echo raises itself such as unknown routes.
+func codeFromHTTPStatus(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusRequestTimeout:
+		return codes.Canceled
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusRequestEntityTooLarge:
+		return codes.OutOfRange
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	if httpStatus < http.StatusInternalServerError {
+		return codes.InvalidArgument
+	}
+	return codes.Internal
+}
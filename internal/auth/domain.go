@@ -1,13 +1,35 @@
 package auth
 
+import (
+	"log/slog"
+
+	"github.com/anousonefs/golang-htmx-template/internal/validation"
+)
+
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
+// LogValue keeps the password out of the logs.
+func (r LoginRequest) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("email", r.Email))
+}
+
+func (r LoginRequest) Validate() error {
+	v := validation.New()
+	v.Required("email", r.Email)
+	v.Email("email", r.Email)
+	v.Required("password", r.Password)
+	return v.Err()
+}
+
 type LoginResponse struct {
 	AccessToken  string `json:"accessToken"`
 	RefreshToken string `json:"refreshToken"`
+	Locale       string `json:"locale,omitempty"`
+	// UserID ties the web session to the user, see Service.SetCookie.
+	UserID string `json:"-"`
 }
 
 type RefreshTokenRequest struct {
@@ -1,15 +1,17 @@
 package auth
 
 import (
-	"fmt"
 	"net/http"
 
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
 	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/metrics"
+	"github.com/anousonefs/golang-htmx-template/internal/ratelimit"
+	"github.com/anousonefs/golang-htmx-template/internal/tracing"
 	"github.com/markbates/goth/gothic"
 
 	"github.com/labstack/echo/v4"
-	"github.com/sirupsen/logrus"
-	"google.golang.org/protobuf/encoding/protojson"
 )
 
 type handler struct {
@@ -22,20 +24,22 @@ func NewHandler(app *echo.Echo, auth *Service, cfg config.Config) *handler {
 	}
 }
 
-func (h handler) Install(e *echo.Echo) {
+func (h handler) Install(e *echo.Echo, rl *ratelimit.Limiter) {
+	login := rl.Limit(ratelimit.PolicyLogin)
+
 	v1 := e.Group("/api/v1")
-	v1.POST("/login", h.login)
-	v1.POST("/refresh-token", h.refreshToken)
+	v1.POST("/login", h.login, login)
+	v1.POST("/refresh-token", h.refreshToken, login)
 
 	e.GET("/auth", h.providerLogin)
 	e.GET("/auth/callback", h.authCallback)
 
 	e.GET("/login", h.loginPage)
-	e.POST("/web/login", h.loginWeb)
+	e.POST("/web/login", h.loginWeb, login)
 }
 
 func (h handler) loginPage(c echo.Context) error {
-	if err := Login().Render(c.Request().Context(), c.Response().Writer); err != nil {
+	if err := tracing.Component("Login", Login()).Render(c.Request().Context(), c.Response().Writer); err != nil {
 		return err
 	}
 	return nil
@@ -46,22 +50,17 @@ func (h handler) loginWeb(c echo.Context) error {
 		Email:    c.FormValue("email"),
 		Password: c.FormValue("password"),
 	}
-	if req.Email == "" || req.Password == "" {
-		hs := HttpStatusPbFromRPC(StatusBadRequest)
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+	if err := req.Validate(); err != nil {
+		return err
 	}
 	ctx := c.Request().Context()
 	res, err := h.auth.Login(ctx, req)
 	if err != nil {
-		hs := HttpStatusPbFromRPC(GRPCStatusFromErr(err))
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		return err
 	}
 
 	if err := h.auth.SetCookie(c, res); err != nil {
-		logrus.Printf("loginWeb.StoreUserSession(): %v\n", err)
-		return c.String(http.StatusInternalServerError, "Error storing user session")
+		return err
 	}
 
 	return c.NoContent(200)
@@ -70,17 +69,16 @@ func (h handler) loginWeb(c echo.Context) error {
 func (h handler) login(c echo.Context) error {
 	var req LoginRequest
 	if err := c.Bind(&req); err != nil {
-		logrus.Errorf("bind: %v\n", err)
-		hs := HttpStatusPbFromRPC(StatusBindingFailure)
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		logger.FromContext(c.Request().Context()).Warn("bind request", "err", err)
+		return apperror.StatusBindingFailure.Err()
+	}
+	if err := req.Validate(); err != nil {
+		return err
 	}
 	ctx := c.Request().Context()
 	res, err := h.auth.Login(ctx, req)
 	if err != nil {
-		hs := HttpStatusPbFromRPC(GRPCStatusFromErr(err))
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		return err
 	}
 	return c.JSON(http.StatusOK, res)
 }
@@ -88,50 +86,47 @@ func (h handler) login(c echo.Context) error {
 func (h handler) refreshToken(c echo.Context) error {
 	var req RefreshTokenRequest
 	if err := c.Bind(&req); err != nil {
-		logrus.Errorf("bind: %v\n", err)
-		hs := HttpStatusPbFromRPC(StatusBindingFailure)
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		logger.FromContext(c.Request().Context()).Warn("bind request", "err", err)
+		return apperror.StatusBindingFailure.Err()
 	}
 	ctx := c.Request().Context()
 	res, err := h.auth.RefreshToken(ctx, req)
 	if err != nil {
-		hs := HttpStatusPbFromRPC(GRPCStatusFromErr(err))
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		return err
 	}
 	return c.JSON(http.StatusOK, res)
 }
 
 func (h handler) providerLogin(c echo.Context) error {
-	user, err := gothic.CompleteUserAuth(c.Response().Writer, c.Request())
-	fmt.Printf("user: %#v\n", user)
-	if err == nil {
-		if err := Login().Render(c.Request().Context(), c.Response().Writer); err != nil {
-			logrus.Errorf("call view(): %v\n", err)
+	ctx := c.Request().Context()
+	if _, err := gothic.CompleteUserAuth(c.Response().Writer, c.Request()); err == nil {
+		if err := tracing.Component("Login", Login()).Render(ctx, c.Response().Writer); err != nil {
 			return err
 		}
 	} else {
-		logrus.Errorf("CompleteUserAuth error: %v\n", err)
+		logger.FromContext(ctx).Debug("begin provider auth", "provider", c.QueryParam("provider"), "err", err)
 		gothic.BeginAuthHandler(c.Response().Writer, c.Request())
 	}
 	return nil
 }
 
 func (h handler) authCallback(c echo.Context) error {
+	provider := c.QueryParam("provider")
 	user, err := gothic.CompleteUserAuth(c.Response(), c.Request())
 	if err != nil {
-		logrus.Errorf("authCallback.CompleteUserAuth(): %v\n", err)
-		return c.String(http.StatusInternalServerError, err.Error())
+		metrics.Login(provider, metrics.ResultFailure)
+		logger.FromContext(c.Request().Context()).Warn("provider callback", "provider", provider, "err", err)
+		return apperror.StatusUnauthenticated.Err()
 	}
-	tokens, err := h.auth.genToken(c.Request().Context(), user.Email)
+	tokens, err := h.auth.genToken(c.Request().Context(), provider, user.Email)
 	if err != nil {
+		metrics.Login(provider, metrics.ResultFailure)
 		return err
 	}
+	metrics.Login(provider, metrics.ResultSuccess)
 
 	if err := h.auth.SetCookie(c, tokens); err != nil {
-		logrus.Printf("authCallback.StoreUserSession(): %v\n", err)
-		return c.String(http.StatusInternalServerError, "Error storing user session")
+		return err
 	}
 
 	return c.Redirect(http.StatusTemporaryRedirect, "/")
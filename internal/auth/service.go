@@ -7,8 +7,14 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
 	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/event"
+	"github.com/anousonefs/golang-htmx-template/internal/i18n"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/metrics"
 	"github.com/anousonefs/golang-htmx-template/internal/middleware"
+	"github.com/anousonefs/golang-htmx-template/internal/session"
 	"github.com/anousonefs/golang-htmx-template/internal/user"
 	"github.com/anousonefs/golang-htmx-template/internal/utils"
 	"github.com/gorilla/sessions"
@@ -19,15 +25,15 @@ import (
 	"github.com/markbates/goth/providers/facebook"
 
 	"github.com/o1egl/paseto/v2"
-	"github.com/sirupsen/logrus"
 )
 
 type Service struct {
-	user user.Service
-	cfg  config.Config
+	user   user.Service
+	events *event.Bus
+	cfg    config.Config
 }
 
-func NewService(user user.Service, store sessions.Store, cfg config.Config) *Service {
+func NewService(user user.Service, events *event.Bus, store sessions.Store, cfg config.Config) *Service {
 
 	gothic.Store = store
 
@@ -43,7 +49,7 @@ func NewService(user user.Service, store sessions.Store, cfg config.Config) *Ser
 			buildCallbackURL("discord", cfg),
 		),
 	)
-	return &Service{user, cfg}
+	return &Service{user, events, cfg}
 }
 
 func (s Service) GetSessionUser(c echo.Context) (goth.User, error) {
@@ -60,87 +66,134 @@ func (s Service) GetSessionUser(c echo.Context) (goth.User, error) {
 	return u.(goth.User), nil
 }
 
+// SetCookie starts the web session of the user: a fresh session bound to
+// the user, then the token cookies with the same lifetimes as the tokens.
 func (s *Service) SetCookie(c echo.Context, tokens LoginResponse) error {
-	// Get a session. We're ignoring the error resulted from decoding an
-	// existing session: Get() always returns a session, even if empty.
-	/* session, err := gothic.Store.Get(c.Request(), s.cfg.SessionName()) */
-	/* if err != nil { */
-	/* 	logrus.Errorf("StoreUserSession.Get(): %v\n", err) */
-	/* 	return err */
-	/* } */
-
-	/* session.Values["authentication"] = string(tokensJSON) */
-
-	accessTokenCookie := &http.Cookie{
-		Name:     "access_token",
-		Value:    tokens.AccessToken,
-		Expires:  time.Now().Add(25 * time.Minute),
-		Path:     "/",
-		HttpOnly: true,
+	sess, err := gothic.Store.Get(c.Request(), s.cfg.SessionName())
+	if err != nil {
+		logger.FromContext(c.Request().Context()).Debug("unreadable session replaced", "err", err)
 	}
-
-	refreshTokenCookie := &http.Cookie{
-		Name:     "refresh_token",
-		Value:    tokens.RefreshToken,
-		Expires:  time.Now().Add(7 * 24 * time.Hour),
-		Path:     "/",
-		HttpOnly: true,
+	// a new ID on login, so a session planted before it is worthless.
+	sess.ID = ""
+	sess.Values[session.UserIDKey] = tokens.UserID
+	if err := sess.Save(c.Request(), c.Response()); err != nil {
+		return err
 	}
 
-	c.SetCookie(accessTokenCookie)
-	c.SetCookie(refreshTokenCookie)
+	c.SetCookie(s.cookie("access_token", tokens.AccessToken, s.cfg.AccessTokenTTL()))
+	c.SetCookie(s.cookie("refresh_token", tokens.RefreshToken, s.cfg.RefreshTokenTTL()))
+	if i18n.IsSupported(tokens.Locale) {
+		i18n.SetCookie(c, tokens.Locale)
+	}
 
 	return nil
 }
 
+func (s *Service) cookie(name, value string, ttl time.Duration) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Expires:  time.Now().Add(ttl),
+		Path:     "/",
+		Domain:   s.cfg.CookieDomain(),
+		Secure:   s.cfg.CookieSecure(),
+		HttpOnly: true,
+		SameSite: s.cfg.CookieSameSite(),
+	}
+}
+
 func (s Service) Login(ctx context.Context, req LoginRequest) (res LoginResponse, err error) {
 	defer func() {
 		if err != nil {
-			logrus.Errorf("login(): %v\n", err)
+			metrics.Login(metrics.ProviderPassword, metrics.ResultFailure)
+			logger.FromContext(ctx).Warn("auth.Login", "email", req.Email, "err", err)
+			return
 		}
+		metrics.Login(metrics.ProviderPassword, metrics.ResultSuccess)
 	}()
 	user, err := s.user.GetUser(ctx, user.FilterUser{Email: req.Email})
 	if err != nil {
+		if errors.Is(err, apperror.ErrStatusNotFound) {
+			return LoginResponse{}, s.loginFailed(ctx, metrics.ProviderPassword, req.Email, "unknown_user")
+		}
 		return LoginResponse{}, err
 	}
 	if err := utils.ComparePassword(req.Password, user.Password); err != nil {
-		return LoginResponse{}, err
+		return LoginResponse{}, s.loginFailed(ctx, metrics.ProviderPassword, req.Email, "wrong_password")
+	}
+	if !user.Active() {
+		return LoginResponse{}, s.loginFailed(ctx, metrics.ProviderPassword, req.Email, "inactive")
 	}
-	return generateToken(s.cfg.PasetoSecret(), user)
+	return s.loggedIn(ctx, metrics.ProviderPassword, user)
 }
 
-func (s Service) genToken(ctx context.Context, email string) (res LoginResponse, err error) {
+// genToken signs in the user a provider vouched for.
+func (s Service) genToken(ctx context.Context, provider, email string) (res LoginResponse, err error) {
 	if email == "" {
 		return LoginResponse{}, errors.New("email is empty")
 	}
 	user, err := s.user.GetUser(ctx, user.FilterUser{Email: email})
+	if err != nil {
+		if errors.Is(err, apperror.ErrStatusNotFound) {
+			_ = s.loginFailed(ctx, provider, email, "unknown_user")
+		}
+		return LoginResponse{}, err
+	}
+	if !user.Active() {
+		return LoginResponse{}, s.loginFailed(ctx, provider, email, "inactive")
+	}
+	return s.loggedIn(ctx, provider, user)
+}
+
+func (s Service) loggedIn(ctx context.Context, provider string, u *user.UserDetail) (LoginResponse, error) {
+	res, err := s.generateToken(u)
 	if err != nil {
 		return LoginResponse{}, err
 	}
-	return generateToken(s.cfg.PasetoSecret(), user)
+	if err := s.events.Publish(ctx, nil, event.LoginSucceeded{UserID: u.ID, Email: u.Email, Provider: provider}); err != nil {
+		return LoginResponse{}, err
+	}
+	return res, nil
+}
+
+// loginFailed publishes the failed attempt and returns the error the
+// caller gets, which does not tell why.
+func (s Service) loginFailed(ctx context.Context, provider, email, reason string) error {
+	if err := s.events.Publish(ctx, nil, event.LoginFailed{Email: email, Provider: provider, Reason: reason}); err != nil {
+		logger.FromContext(ctx).Error("publish login failure", "err", err)
+	}
+	return apperror.ErrUnauthorized
 }
 
 func (s Service) RefreshToken(ctx context.Context, req RefreshTokenRequest) (res LoginResponse, err error) {
 	defer func() {
 		if err != nil {
-			logrus.Errorf("u.RefreshToken: %v\n", err)
+			metrics.Refresh(metrics.ResultFailure)
+			logger.FromContext(ctx).Warn("auth.RefreshToken", "err", err)
+			return
 		}
+		metrics.Refresh(metrics.ResultSuccess)
 	}()
 	claims, err := s.verifyIDToken(ctx, req.RefreshToken)
 	if err != nil {
-		return LoginResponse{}, ErrUnProcessAbleEntity
+		return LoginResponse{}, apperror.ErrUnProcessAbleEntity
 	}
 	var renewable bool
 	if err := claims.Get("renewable", &renewable); err != nil || !renewable {
-		return LoginResponse{}, ErrInternalServerError
+		return LoginResponse{}, apperror.ErrInternalServerError
 	}
-	user, err := s.user.GetUser(ctx, user.FilterUser{Username: claims.Subject})
+	// the subject is the email, see generateToken.
+	user, err := s.user.GetUser(ctx, user.FilterUser{Email: claims.Subject})
 	if err != nil {
 		return LoginResponse{}, err
 	}
-	res, err = generateToken(s.cfg.PasetoSecret(), user)
+	// a user disabled since signing in may not renew their tokens.
+	if !user.Active() {
+		return LoginResponse{}, apperror.ErrPermissionDenied
+	}
+	res, err = s.generateToken(user)
 	if err != nil {
-		return LoginResponse{}, ErrInternalServerError
+		return LoginResponse{}, apperror.ErrInternalServerError
 	}
 	return res, nil
 }
@@ -158,18 +211,20 @@ func (s *Service) verifyIDToken(_ context.Context, idToken string) (paseto.JSONT
 
 var now = time.Now
 
-func generateToken(secret []byte, u *user.UserDetail) (LoginResponse, error) {
+func (s Service) generateToken(u *user.UserDetail) (LoginResponse, error) {
+	secret := s.cfg.PasetoSecret()
 	issAt := now()
 	claims := paseto.JSONToken{
 		Subject:    u.Email,
 		IssuedAt:   issAt,
-		Expiration: issAt.Add(5 * time.Hour),
+		Expiration: issAt.Add(s.cfg.AccessTokenTTL()),
 		NotBefore:  issAt,
 	}
 	userClaims := middleware.UserClaim{
 		ID:           u.ID,
 		DepartmentID: u.DepartmentID,
 		RoleID:       u.Role.ID,
+		TenantID:     u.TenantID,
 	}
 	claims.Set("user", userClaims)
 	accessKey, err := paseto.Encrypt(secret, claims, nil)
@@ -177,7 +232,7 @@ func generateToken(secret []byte, u *user.UserDetail) (LoginResponse, error) {
 		return LoginResponse{}, err
 	}
 	claims.Set("renewable", true)
-	claims.Expiration = claims.Expiration.Add(48 * time.Hour)
+	claims.Expiration = issAt.Add(s.cfg.RefreshTokenTTL())
 	refreshKey, err := paseto.Encrypt(secret, claims, nil)
 	if err != nil {
 		return LoginResponse{}, err
@@ -185,6 +240,8 @@ func generateToken(secret []byte, u *user.UserDetail) (LoginResponse, error) {
 	return LoginResponse{
 		AccessToken:  accessKey,
 		RefreshToken: refreshKey,
+		Locale:       u.Locale,
+		UserID:       u.ID,
 	}, nil
 }
 
@@ -0,0 +1,79 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/anousonefs/golang-htmx-template/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Disk stores the blobs as files under a directory, for development and
+// single node deployments.
+type Disk struct {
+	dir string
+}
+
+func NewDisk(dir string) *Disk {
+	return &Disk{dir: dir}
+}
+
+func (d *Disk) Put(ctx context.Context, key string, r io.Reader, _ int64, _ string) (err error) {
+	_, span := tracing.Start(ctx, "blob.Put", attribute.String("blob.key", key))
+	defer func() { tracing.End(span, err) }()
+	key, err = cleanKey(key)
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(d.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(name), 0o750); err != nil {
+		return err
+	}
+	// written aside then renamed, a reader never sees half a file.
+	f, err := os.CreateTemp(filepath.Dir(name), ".blob-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+func (d *Disk) Get(ctx context.Context, key string) (_ io.ReadCloser, err error) {
+	_, span := tracing.Start(ctx, "blob.Get", attribute.String("blob.key", key))
+	defer func() { tracing.End(span, err) }()
+	key, err = cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(d.dir, filepath.FromSlash(key)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (d *Disk) Delete(ctx context.Context, key string) (err error) {
+	_, span := tracing.Start(ctx, "blob.Delete", attribute.String("blob.key", key))
+	defer func() { tracing.End(span, err) }()
+	key, err = cleanKey(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(d.dir, filepath.FromSlash(key)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
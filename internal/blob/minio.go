@@ -0,0 +1,89 @@
+package blob
+
+import (
+	"context"
+	"io"
+
+	"github.com/anousonefs/golang-htmx-template/internal/tracing"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Minio stores the blobs in a bucket of MinIO or any S3 compatible server.
+type Minio struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewMinio connects to endpoint and creates the bucket when it is missing.
+func NewMinio(ctx context.Context, endpoint, accessKey, secretKey, bucket string, useSSL bool) (*Minio, error) {
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: useSSL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+			return nil, err
+		}
+	}
+	return &Minio{client: client, bucket: bucket}, nil
+}
+
+func (m *Minio) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (err error) {
+	ctx, span := tracing.Start(ctx, "minio.PutObject",
+		attribute.String("minio.bucket", m.bucket),
+		attribute.String("minio.object", key),
+		attribute.Int64("minio.size", size),
+	)
+	defer func() { tracing.End(span, err) }()
+	if key, err = cleanKey(key); err != nil {
+		return err
+	}
+	_, err = m.client.PutObject(ctx, m.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (m *Minio) Get(ctx context.Context, key string) (_ io.ReadCloser, err error) {
+	ctx, span := tracing.Start(ctx, "minio.GetObject",
+		attribute.String("minio.bucket", m.bucket),
+		attribute.String("minio.object", key),
+	)
+	defer func() { tracing.End(span, err) }()
+	if key, err = cleanKey(key); err != nil {
+		return nil, err
+	}
+	obj, err := m.client.GetObject(ctx, m.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy, Stat tells whether the object is there.
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (m *Minio) Delete(ctx context.Context, key string) (err error) {
+	ctx, span := tracing.Start(ctx, "minio.RemoveObject",
+		attribute.String("minio.bucket", m.bucket),
+		attribute.String("minio.object", key),
+	)
+	defer func() { tracing.End(span, err) }()
+	if key, err = cleanKey(key); err != nil {
+		return err
+	}
+	return m.client.RemoveObject(ctx, m.bucket, key, minio.RemoveObjectOptions{})
+}
@@ -4,12 +4,23 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Masterminds/squirrel"
 )
 
+const (
+	DriverPostgres = "postgres"
+	DriverMysql    = "mysql"
+	DriverSqlite   = "sqlite"
+)
+
 type Config struct {
 	dbDriver    string
 	dbHost      string
@@ -23,7 +34,60 @@ type Config struct {
 
 	appPort      string
 	pasetoSecret []byte
+	autoMigrate  bool
+
+	defaultLocale string
+
+	appEnv    string
+	logFormat string
+	logLevel  string
+
+	metricsAddr  string
+	metricsToken string
+
+	policyReloadInterval time.Duration
+
+	rateLimits map[string]string
+
+	sessionKeys     [][]byte
+	sessionStore    string
+	sessionMaxAge   time.Duration
+	cookieDomain    string
+	cookieSecure    bool
+	cookieSameSite  http.SameSite
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+
+	trustedProxies    []*net.IPNet
+	corsAllowOrigins  []string
+	cspDirectives     string
+	cspStyleHashes    []string
+	cspReportOnly     bool
+	hstsMaxAge        int
+	frameOptions      string
+	permissionsPolicy string
+
+	tracingExporter string
+	tracingFile     string
+	serviceName     string
 
+	workerEnabled      bool
+	workerConcurrency  int
+	workerQueues       []string
+	workerPollInterval time.Duration
+	jobRetention       time.Duration
+
+	webhookAllowPrivate bool
+
+	blobStore      string
+	blobDir        string
+	minioEndpoint  string
+	minioAccessKey string
+	minioSecretKey string
+	minioBucket    string
+	minioUseSSL    bool
+
+	notifier        string
 	oneSignalApiKey string
 	oneSignalAppID  string
 
@@ -34,6 +98,20 @@ type Config struct {
 	discordClientSecret string
 }
 
+// Notifier is "onesignal" or "log", it defaults to onesignal when its keys
+// are set.
+func (c Config) Notifier() string {
+	return c.notifier
+}
+
+func (c Config) OneSignalApiKey() string {
+	return c.oneSignalApiKey
+}
+
+func (c Config) OneSignalAppID() string {
+	return c.oneSignalAppID
+}
+
 func (c Config) FacebookAppID() string {
 	return c.facebookAppID
 }
@@ -58,6 +136,217 @@ func (c Config) AppPort() string {
 	return c.appPort
 }
 
+func (c Config) AutoMigrate() bool {
+	return c.autoMigrate
+}
+
+func (c Config) AppEnv() string {
+	return c.appEnv
+}
+
+func (c Config) IsProduction() bool {
+	return c.appEnv == "production"
+}
+
+// LogFormat is "json" or "text", production defaults to json.
+func (c Config) LogFormat() string {
+	return c.logFormat
+}
+
+func (c Config) LogLevel() string {
+	return c.logLevel
+}
+
+// MetricsAddr is the private listener serving /metrics, empty disables it.
+func (c Config) MetricsAddr() string {
+	return c.metricsAddr
+}
+
+// MetricsToken, when set, also serves /metrics on the app port behind a
+// bearer token.
+func (c Config) MetricsToken() string {
+	return c.metricsToken
+}
+
+// PolicyReloadInterval is how often the server looks for policy changes
+// made by other processes.
+func (c Config) PolicyReloadInterval() time.Duration {
+	return c.policyReloadInterval
+}
+
+// SessionKeys are the securecookie key pairs (hash key, then encryption key
+// or nil) signing the session cookie, newest first. Older pairs only
+// decode, so a key is rotated by prepending the new one and removing the
+// old one once MaxAge has passed.
+func (c Config) SessionKeys() [][]byte {
+	return c.sessionKeys
+}
+
+// SessionStore is "cookie" or "db". With db the session data stays in the
+// sessions table and the cookie only holds its ID, so sessions can be
+// listed and revoked.
+func (c Config) SessionStore() string {
+	return c.sessionStore
+}
+
+func (c Config) SessionMaxAge() time.Duration {
+	return c.sessionMaxAge
+}
+
+func (c Config) CookieDomain() string {
+	return c.cookieDomain
+}
+
+// CookieSecure defaults to true in production.
+func (c Config) CookieSecure() bool {
+	return c.cookieSecure
+}
+
+func (c Config) CookieSameSite() http.SameSite {
+	return c.cookieSameSite
+}
+
+// AccessTokenTTL is the lifetime of the access token and of its cookie.
+func (c Config) AccessTokenTTL() time.Duration {
+	return c.accessTokenTTL
+}
+
+func (c Config) RefreshTokenTTL() time.Duration {
+	return c.refreshTokenTTL
+}
+
+// TrustedProxies are the networks of the reverse proxies whose
+// X-Forwarded-For header tells the client IP. Empty means the server faces
+// the clients and the IP is the remote address of the connection.
+func (c Config) TrustedProxies() []*net.IPNet {
+	return c.trustedProxies
+}
+
+// CORSAllowOrigins lists the origins allowed to call the API from a browser,
+// empty means same-origin only.
+func (c Config) CORSAllowOrigins() []string {
+	return c.corsAllowOrigins
+}
+
+// CSPDirectives overrides or adds directives of the default policy, in CSP
+// syntax ("img-src 'self' data:; connect-src 'self'"). The nonces are
+// always added to script-src and style-src.
+func (c Config) CSPDirectives() string {
+	return c.cspDirectives
+}
+
+// CSPStyleHashes are the hashes allowed in style-src, by default the style
+// htmx injects for its indicators.
+func (c Config) CSPStyleHashes() []string {
+	return c.cspStyleHashes
+}
+
+func (c Config) CSPReportOnly() bool {
+	return c.cspReportOnly
+}
+
+// HSTSMaxAge is in seconds, 0 disables Strict-Transport-Security.
+func (c Config) HSTSMaxAge() int {
+	return c.hstsMaxAge
+}
+
+func (c Config) FrameOptions() string {
+	return c.frameOptions
+}
+
+func (c Config) PermissionsPolicy() string {
+	return c.permissionsPolicy
+}
+
+// RateLimits maps the policy names of the ratelimit package to
+// "<limit>/<duration>", or "off".
+func (c Config) RateLimits() map[string]string {
+	return c.rateLimits
+}
+
+// TracingExporter is otlp, stdout, file or none. otlp is configured with
+// the standard OTEL_EXPORTER_OTLP_* variables.
+func (c Config) TracingExporter() string {
+	return c.tracingExporter
+}
+
+// TracingFile is where spans are written with the file exporter.
+func (c Config) TracingFile() string {
+	return c.tracingFile
+}
+
+func (c Config) ServiceName() string {
+	return c.serviceName
+}
+
+// WorkerEnabled runs the job workers inside the server process, turn it
+// off when they run apart with the worker command.
+func (c Config) WorkerEnabled() bool {
+	return c.workerEnabled
+}
+
+func (c Config) WorkerConcurrency() int {
+	return c.workerConcurrency
+}
+
+// WorkerQueues are the queues this process takes jobs from.
+func (c Config) WorkerQueues() []string {
+	return c.workerQueues
+}
+
+// WorkerPollInterval is how long an idle worker waits before looking for
+// jobs again.
+func (c Config) WorkerPollInterval() time.Duration {
+	return c.workerPollInterval
+}
+
+// JobRetention is how long finished jobs are kept, dead ones stay until
+// they are retried or deleted.
+func (c Config) JobRetention() time.Duration {
+	return c.jobRetention
+}
+
+// WebhookAllowPrivate lets the webhooks reach localhost and the private
+// networks, for development only.
+func (c Config) WebhookAllowPrivate() bool {
+	return c.webhookAllowPrivate
+}
+
+// BlobStore is "disk" or "minio", where the uploaded files are kept. It
+// defaults to minio when MINIO_ENDPOINT is set.
+func (c Config) BlobStore() string {
+	return c.blobStore
+}
+
+// BlobDir is the directory of the disk blob store.
+func (c Config) BlobDir() string {
+	return c.blobDir
+}
+
+func (c Config) MinioEndpoint() string {
+	return c.minioEndpoint
+}
+
+func (c Config) MinioAccessKey() string {
+	return c.minioAccessKey
+}
+
+func (c Config) MinioSecretKey() string {
+	return c.minioSecretKey
+}
+
+func (c Config) MinioBucket() string {
+	return c.minioBucket
+}
+
+func (c Config) MinioUseSSL() bool {
+	return c.minioUseSSL
+}
+
+func (c Config) DefaultLocale() string {
+	return c.defaultLocale
+}
+
 func (c Config) DBDriver() string {
 	return c.dbDriver
 }
@@ -75,10 +364,23 @@ func (c Config) SessionName() string {
 }
 
 func (c Config) DSNInfo() string {
+	switch c.dbDriver {
+	case DriverMysql:
+		return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true&multiStatements=true&timeout=10s", c.dbUser, c.dbPassword, c.dbHost, c.dbPort, c.dbName)
+	case DriverSqlite:
+		// dbName is the database file, or ":memory:".
+		return fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", c.dbName)
+	}
 	timeoutOption := fmt.Sprintf("-c statement_timeout=%d", 10*time.Minute/time.Millisecond)
 	return fmt.Sprintf("user='%s' password='%s' host='%s' port=%s dbname='%s' sslmode=disable options='%s'", c.dbUser, c.dbPassword, c.dbHost, c.dbPort, c.dbName, timeoutOption)
 }
 
+// SQL returns the squirrel builder matching the configured driver's
+// placeholder format.
+func (c Config) SQL() squirrel.StatementBuilderType {
+	return StatementBuilder(c.dbDriver)
+}
+
 func GetEnv(key, fallback string) string {
 	if value, ok := os.LookupEnv(key); ok {
 		return value
@@ -86,15 +388,40 @@ func GetEnv(key, fallback string) string {
 	return fallback
 }
 
-func NewConfig() (config Config, err error) {
+// NewDBConfig only reads the database and logging settings, for commands
+// such as migrate that must run without the web and oauth secrets.
+func NewDBConfig() (config Config) {
+	config.appEnv = GetEnv("APP_ENV", "development")
+	defaultFormat := "text"
+	if config.IsProduction() {
+		defaultFormat = "json"
+	}
+	config.logFormat = GetEnv("LOG_FORMAT", defaultFormat)
+	config.logLevel = GetEnv("LOG_LEVEL", "info")
+
 	config.dbDriver = GetEnv("DB_DRIVER", "postgres")
-	config.dbHost = GetEnv("PGHOST", "127.0.0.1")
-	config.dbPort = GetEnv("PGPORT", "5432")
-	config.dbUser = os.Getenv("PGUSER")
-	config.dbPassword = os.Getenv("PGSECRET")
-	config.dbName = os.Getenv("PGDATABASE")
+	defaultPort := "5432"
+	if config.dbDriver == DriverMysql {
+		defaultPort = "3306"
+	}
+	defaultName := ""
+	if config.dbDriver == DriverSqlite {
+		defaultName = "app.db"
+	}
+	// DB_* works for every driver, PG* is kept for existing deployments.
+	config.dbHost = GetEnv("DB_HOST", GetEnv("PGHOST", "127.0.0.1"))
+	config.dbPort = GetEnv("DB_PORT", GetEnv("PGPORT", defaultPort))
+	config.dbUser = GetEnv("DB_USER", os.Getenv("PGUSER"))
+	config.dbPassword = GetEnv("DB_PASSWORD", os.Getenv("PGSECRET"))
+	config.dbName = GetEnv("DB_NAME", GetEnv("PGDATABASE", defaultName))
+	config.autoMigrate = GetEnv("AUTO_MIGRATE", "false") == "true"
+	return
+}
+
+func NewConfig() (config Config, err error) {
+	config = NewDBConfig()
 	config.baseUrl = os.Getenv("BASE_URL")
-	config.sessionName = os.Getenv("SESSION_NAME")
+	config.sessionName = GetEnv("SESSION_NAME", "session")
 
 	if config.baseUrl == "" {
 		return config, errors.New("BASE_URL is empty")
@@ -105,6 +432,47 @@ func NewConfig() (config Config, err error) {
 	}
 	config.assetDir = GetEnv("ASSET_DIR", homeDir)
 	config.appPort = GetEnv("PORT", "8080")
+	config.defaultLocale = GetEnv("DEFAULT_LOCALE", "en")
+	config.metricsAddr = GetEnv("METRICS_ADDR", "127.0.0.1:9090")
+	config.metricsToken = os.Getenv("METRICS_TOKEN")
+	if config.policyReloadInterval, err = time.ParseDuration(GetEnv("POLICY_RELOAD_INTERVAL", "10s")); err != nil || config.policyReloadInterval <= 0 {
+		return config, errors.New("POLICY_RELOAD_INTERVAL: must be a positive duration")
+	}
+	if err = config.readSessionConfig(); err != nil {
+		return config, err
+	}
+	if err = config.readWorkerConfig(); err != nil {
+		return config, err
+	}
+	if err = config.readBlobConfig(); err != nil {
+		return config, err
+	}
+	if config.trustedProxies, err = parseNetworks(splitList(os.Getenv("TRUSTED_PROXIES"))); err != nil {
+		return config, fmt.Errorf("TRUSTED_PROXIES: %v", err)
+	}
+	config.corsAllowOrigins = splitList(os.Getenv("CORS_ALLOW_ORIGINS"))
+	config.cspDirectives = os.Getenv("CSP_DIRECTIVES")
+	config.cspStyleHashes = splitList(GetEnv("CSP_STYLE_HASHES", "sha256-pgn1TCGZX6O77zDvy0oTODMOxemn0oj0LeCnQTRj7Kg="))
+	config.cspReportOnly = GetEnv("CSP_REPORT_ONLY", "false") == "true"
+	defaultHSTS := "0"
+	if config.IsProduction() {
+		defaultHSTS = "31536000"
+	}
+	if config.hstsMaxAge, err = strconv.Atoi(GetEnv("HSTS_MAX_AGE", defaultHSTS)); err != nil {
+		return config, fmt.Errorf("HSTS_MAX_AGE: %v", err)
+	}
+	config.frameOptions = GetEnv("FRAME_OPTIONS", "DENY")
+	config.permissionsPolicy = GetEnv("PERMISSIONS_POLICY", "camera=(), microphone=(), geolocation=(), payment=()")
+
+	config.rateLimits = map[string]string{
+		"login":  GetEnv("RATE_LIMIT_LOGIN", "5/1m"),
+		"upload": GetEnv("RATE_LIMIT_UPLOAD", "10/1m"),
+		"read":   GetEnv("RATE_LIMIT_READ", "300/1m"),
+		"write":  GetEnv("RATE_LIMIT_WRITE", "60/1m"),
+	}
+	config.tracingExporter = GetEnv("TRACING_EXPORTER", "none")
+	config.tracingFile = GetEnv("TRACING_FILE", "traces.json")
+	config.serviceName = GetEnv("OTEL_SERVICE_NAME", "golang-htmx-template")
 
 	config.pasetoSecret, err = hex.DecodeString(os.Getenv("PASETO_SECRET"))
 	if len(config.pasetoSecret) != 32 {
@@ -113,6 +481,20 @@ func NewConfig() (config Config, err error) {
 
 	config.oneSignalApiKey = os.Getenv("ONESIGNAL_REST_API_KEY")
 	config.oneSignalAppID = os.Getenv("ONESIGNAL_APP_ID_KEY")
+	defaultNotifier := "log"
+	if config.oneSignalApiKey != "" && config.oneSignalAppID != "" {
+		defaultNotifier = "onesignal"
+	}
+	config.notifier = GetEnv("NOTIFIER", defaultNotifier)
+	switch config.notifier {
+	case "log":
+	case "onesignal":
+		if config.oneSignalApiKey == "" || config.oneSignalAppID == "" {
+			return config, errors.New("NOTIFIER=onesignal needs ONESIGNAL_REST_API_KEY and ONESIGNAL_APP_ID_KEY")
+		}
+	default:
+		return config, fmt.Errorf("NOTIFIER: unknown notifier %q", config.notifier)
+	}
 
 	config.facebookAppID = os.Getenv("FACEBOOK_APP_ID")
 	if config.facebookAppID == "" {
@@ -134,6 +516,127 @@ func NewConfig() (config Config, err error) {
 	return
 }
 
+func (c *Config) readSessionConfig() (err error) {
+	keys := splitList(os.Getenv("SESSION_KEYS"))
+	if len(keys) == 0 {
+		if c.IsProduction() {
+			return errors.New("SESSION_KEYS is empty")
+		}
+		keys = []string{"development-session-key-do-not-use"}
+	}
+	// each item is "<hash key>" or "<hash key>:<encryption key>".
+	for _, k := range keys {
+		hash, block, _ := strings.Cut(k, ":")
+		var blockKey []byte
+		if block != "" {
+			if n := len(block); n != 16 && n != 24 && n != 32 {
+				return fmt.Errorf("SESSION_KEYS: encryption key must be 16, 24 or 32 bytes, got %d", n)
+			}
+			blockKey = []byte(block)
+		}
+		c.sessionKeys = append(c.sessionKeys, []byte(hash), blockKey)
+	}
+
+	c.sessionStore = GetEnv("SESSION_STORE", "cookie")
+	if c.sessionStore != "cookie" && c.sessionStore != "db" {
+		return fmt.Errorf("SESSION_STORE: unknown store %q", c.sessionStore)
+	}
+	c.cookieDomain = os.Getenv("COOKIE_DOMAIN")
+	c.cookieSecure = GetEnv("COOKIE_SECURE", strconv.FormatBool(c.IsProduction())) == "true"
+	switch strings.ToLower(GetEnv("COOKIE_SAMESITE", "lax")) {
+	case "strict":
+		c.cookieSameSite = http.SameSiteStrictMode
+	case "none":
+		c.cookieSameSite = http.SameSiteNoneMode
+	case "lax":
+		c.cookieSameSite = http.SameSiteLaxMode
+	default:
+		return errors.New("COOKIE_SAMESITE must be lax, strict or none")
+	}
+	if c.sessionMaxAge, err = time.ParseDuration(GetEnv("SESSION_MAX_AGE", "168h")); err != nil {
+		return fmt.Errorf("SESSION_MAX_AGE: %v", err)
+	}
+	if c.accessTokenTTL, err = time.ParseDuration(GetEnv("ACCESS_TOKEN_TTL", "5h")); err != nil {
+		return fmt.Errorf("ACCESS_TOKEN_TTL: %v", err)
+	}
+	if c.refreshTokenTTL, err = time.ParseDuration(GetEnv("REFRESH_TOKEN_TTL", "53h")); err != nil {
+		return fmt.Errorf("REFRESH_TOKEN_TTL: %v", err)
+	}
+	return nil
+}
+
+func (c *Config) readBlobConfig() error {
+	c.minioEndpoint = os.Getenv("MINIO_ENDPOINT")
+	c.minioAccessKey = os.Getenv("MINIO_ACCESSKEY")
+	c.minioSecretKey = os.Getenv("MINIO_SECRETKEY")
+	c.minioBucket = os.Getenv("MINIO_BUCKET")
+	c.minioUseSSL = GetEnv("MINIO_USE_SSL", "false") == "true"
+	defaultStore := "disk"
+	if c.minioEndpoint != "" {
+		defaultStore = "minio"
+	}
+	c.blobStore = GetEnv("BLOB_STORE", defaultStore)
+	c.blobDir = GetEnv("BLOB_DIR", filepath.Join(c.assetDir, "blobs"))
+	switch c.blobStore {
+	case "disk":
+	case "minio":
+		if c.minioEndpoint == "" || c.minioBucket == "" {
+			return errors.New("BLOB_STORE=minio needs MINIO_ENDPOINT and MINIO_BUCKET")
+		}
+	default:
+		return fmt.Errorf("BLOB_STORE: unknown store %q", c.blobStore)
+	}
+	return nil
+}
+
+func (c *Config) readWorkerConfig() (err error) {
+	c.workerEnabled = GetEnv("WORKER_ENABLED", "true") == "true"
+	c.workerQueues = splitList(GetEnv("WORKER_QUEUES", "default"))
+	if c.workerConcurrency, err = strconv.Atoi(GetEnv("WORKER_CONCURRENCY", "4")); err != nil || c.workerConcurrency < 1 {
+		return errors.New("WORKER_CONCURRENCY: must be a positive number")
+	}
+	if c.workerPollInterval, err = time.ParseDuration(GetEnv("WORKER_POLL_INTERVAL", "1s")); err != nil {
+		return fmt.Errorf("WORKER_POLL_INTERVAL: %v", err)
+	}
+	if c.jobRetention, err = time.ParseDuration(GetEnv("JOB_RETENTION", "168h")); err != nil {
+		return fmt.Errorf("JOB_RETENTION: %v", err)
+	}
+	c.webhookAllowPrivate = GetEnv("WEBHOOK_ALLOW_PRIVATE", "false") == "true"
+	return nil
+}
+
+// splitList reads a comma separated env value, ignoring empty items.
+func splitList(s string) []string {
+	var res []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// parseNetworks reads CIDRs, a bare IP being a network of its own.
+func parseNetworks(items []string) ([]*net.IPNet, error) {
+	var res []*net.IPNet
+	for _, item := range items {
+		if ip := net.ParseIP(item); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP or a CIDR", item)
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
+
 func Psql() squirrel.StatementBuilderType {
 	return squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
 }
@@ -141,3 +644,11 @@ func Psql() squirrel.StatementBuilderType {
 func Mysql() squirrel.StatementBuilderType {
 	return squirrel.StatementBuilder.PlaceholderFormat(squirrel.Question)
 }
+
+func StatementBuilder(driver string) squirrel.StatementBuilderType {
+	if driver == DriverPostgres {
+		return Psql()
+	}
+	// mysql and sqlite both use `?`.
+	return Mysql()
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/anousonefs/golang-htmx-template/internal/config"
 	"github.com/anousonefs/golang-htmx-template/internal/middleware"
 	"github.com/anousonefs/golang-htmx-template/internal/templates"
+	"github.com/anousonefs/golang-htmx-template/internal/tracing"
 
 	"github.com/labstack/echo/v4"
 )
@@ -25,14 +26,14 @@ func (h *handler) Install(e *echo.Echo, cfg config.Config) {
 
 func (h *handler) homePage(c echo.Context) error {
 	comp := DashboardPage()
-	if err := templates.Layout(comp, "hello").Render(c.Request().Context(), c.Response().Writer); err != nil {
+	if err := tracing.Component("Layout", templates.Layout(comp, "hello")).Render(c.Request().Context(), c.Response().Writer); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (h *handler) dashboardPage(c echo.Context) error {
-	if err := DashboardPage().Render(c.Request().Context(), c.Response().Writer); err != nil {
+	if err := tracing.Component("DashboardPage", DashboardPage()).Render(c.Request().Context(), c.Response().Writer); err != nil {
 		return err
 	}
 	return nil
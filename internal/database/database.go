@@ -0,0 +1,53 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// DBTX is satisfied by both *sql.DB and *sql.Tx so repositories can run the
+// same queries inside or outside a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+var ErrNoTxSupport = errors.New("database handle can not begin a transaction")
+
+// WithTx runs fn inside a transaction started on db and commits it when fn
+// returns nil. Any error or panic rolls the transaction back. If db is
+// already a *sql.Tx, fn joins it and the outer caller owns commit/rollback.
+func WithTx(ctx context.Context, db DBTX, fn func(tx *sql.Tx) error) (err error) {
+	if tx, ok := db.(*sql.Tx); ok {
+		return fn(tx)
+	}
+	b, ok := db.(txBeginner)
+	if !ok {
+		return ErrNoTxSupport
+	}
+	tx, err := b.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			if rerr := tx.Rollback(); rerr != nil && !errors.Is(rerr, sql.ErrTxDone) {
+				err = fmt.Errorf("%w (rollback: %v)", err, rerr)
+			}
+			return
+		}
+		err = tx.Commit()
+	}()
+	return fn(tx)
+}
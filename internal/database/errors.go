@@ -0,0 +1,113 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var (
+	ErrUniqueViolation     = errors.New("unique violation")
+	ErrForeignKeyViolation = errors.New("foreign key violation")
+	ErrNotNullViolation    = errors.New("not null violation")
+	ErrCheckViolation      = errors.New("check violation")
+)
+
+// ConstraintError is a driver independent constraint failure. Constraint is
+// the best name the driver gives us: the index name on postgres and mysql,
+// "table.column" on sqlite.
+type ConstraintError struct {
+	Kind       error
+	Constraint string
+	Err        error
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("%v on %q: %v", e.Kind, e.Constraint, e.Err)
+}
+
+func (e *ConstraintError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+var (
+	mysqlKeyRegex    = regexp.MustCompile(`for key '([^']+)'`)
+	sqliteFieldRegex = regexp.MustCompile(`constraint failed: ([\w.]+(?:, [\w.]+)*)`)
+)
+
+// NormalizeError turns postgres, mysql and sqlite constraint errors into a
+// *ConstraintError so callers can use errors.Is(err, ErrUniqueViolation)
+// whatever the driver. Other errors are returned unchanged.
+func NormalizeError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var pgErr *pq.Error
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505":
+			return &ConstraintError{ErrUniqueViolation, pgErr.Constraint, err}
+		case "23503":
+			return &ConstraintError{ErrForeignKeyViolation, pgErr.Constraint, err}
+		case "23502":
+			return &ConstraintError{ErrNotNullViolation, pgErr.Column, err}
+		case "23514":
+			return &ConstraintError{ErrCheckViolation, pgErr.Constraint, err}
+		}
+		return err
+	}
+
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		var name string
+		if m := mysqlKeyRegex.FindStringSubmatch(myErr.Message); m != nil {
+			name = m[1]
+		}
+		switch myErr.Number {
+		case 1062:
+			return &ConstraintError{ErrUniqueViolation, name, err}
+		case 1451, 1452:
+			return &ConstraintError{ErrForeignKeyViolation, name, err}
+		case 1048:
+			return &ConstraintError{ErrNotNullViolation, name, err}
+		case 3819:
+			return &ConstraintError{ErrCheckViolation, name, err}
+		}
+		return err
+	}
+
+	var liteErr *sqlite.Error
+	if errors.As(err, &liteErr) {
+		var name string
+		// the message repeats "constraint failed: ", the columns follow the last one.
+		if m := sqliteFieldRegex.FindAllStringSubmatch(liteErr.Error(), -1); m != nil {
+			name = m[len(m)-1][1]
+		}
+		switch liteErr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			return &ConstraintError{ErrUniqueViolation, name, err}
+		case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+			return &ConstraintError{ErrForeignKeyViolation, name, err}
+		case sqlite3.SQLITE_CONSTRAINT_NOTNULL:
+			return &ConstraintError{ErrNotNullViolation, name, err}
+		case sqlite3.SQLITE_CONSTRAINT_CHECK:
+			return &ConstraintError{ErrCheckViolation, name, err}
+		}
+	}
+	return err
+}
+
+// ConstraintName returns the constraint of a normalized error, or "".
+func ConstraintName(err error) string {
+	var cErr *ConstraintError
+	if errors.As(err, &cErr) {
+		return cErr.Constraint
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package department
+
+import (
+	"sort"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/validation"
+)
+
+// Department is a unit of the organization, the ones without ParentID are
+// at the top of the tree.
+type Department struct {
+	ID        string    `json:"id"`
+	ParentID  string    `json:"parentID"`
+	Code      string    `json:"code"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	CreatedBy string    `json:"createdBy"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	UpdatedBy string    `json:"updatedBy"`
+	// Children is only filled by Service.Tree.
+	Children []Department `json:"children,omitempty"`
+}
+
+func (d Department) Validate() error {
+	v := validation.New()
+	v.Required("code", d.Code)
+	v.MaxLength("code", d.Code, 64)
+	v.Required("name", d.Name)
+	v.MaxLength("name", d.Name, 255)
+	v.UUID("parentID", d.ParentID)
+	return v.Err()
+}
+
+// Position is a job title, any department may have it.
+type Position struct {
+	ID        string    `json:"id"`
+	Code      string    `json:"code"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	CreatedBy string    `json:"createdBy"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	UpdatedBy string    `json:"updatedBy"`
+}
+
+func (p Position) Validate() error {
+	v := validation.New()
+	v.Required("code", p.Code)
+	v.MaxLength("code", p.Code, 64)
+	v.Required("name", p.Name)
+	v.MaxLength("name", p.Name, 255)
+	return v.Err()
+}
+
+// tree nests the departments under their parent, sorted by name. A
+// department whose parent is missing is kept at the top.
+func tree(all []Department) []Department {
+	ids := map[string]bool{}
+	children := map[string][]Department{}
+	for _, d := range all {
+		ids[d.ID] = true
+	}
+	for _, d := range all {
+		parent := d.ParentID
+		if !ids[parent] {
+			parent = ""
+		}
+		children[parent] = append(children[parent], d)
+	}
+	var build func(parent string) []Department
+	build = func(parent string) []Department {
+		res := children[parent]
+		sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+		for i := range res {
+			res[i].Children = build(res[i].ID)
+		}
+		return res
+	}
+	return build("")
+}
+
+// descendants returns id and the ids of every department under it.
+func descendants(all []Department, id string) []string {
+	children := map[string][]string{}
+	for _, d := range all {
+		children[d.ParentID] = append(children[d.ParentID], d.ID)
+	}
+	res := []string{id}
+	for i := 0; i < len(res); i++ {
+		res = append(res, children[res[i]]...)
+	}
+	return res
+}
+
+// Row is a department of the tree with its depth, for the flat listings of
+// the pages.
+type Row struct {
+	Department
+	Depth int
+}
+
+func flatten(nodes []Department, depth int, res []Row) []Row {
+	for _, d := range nodes {
+		res = append(res, Row{Department: d, Depth: depth})
+		res = flatten(d.Children, depth+1, res)
+	}
+	return res
+}
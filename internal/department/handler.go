@@ -0,0 +1,308 @@
+package department
+
+import (
+	"net/http"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/middleware"
+	"github.com/anousonefs/golang-htmx-template/internal/ratelimit"
+	"github.com/anousonefs/golang-htmx-template/internal/tracing"
+
+	"github.com/labstack/echo/v4"
+)
+
+type handler struct {
+	department *Service
+	authz      *middleware.CasbinMiddleware
+}
+
+func NewHandler(department *Service, authz *middleware.CasbinMiddleware) *handler {
+	return &handler{department, authz}
+}
+
+// Install adds the pages and API managing the departments and positions.
+func (h *handler) Install(e *echo.Echo, cfg config.Config, rl *ratelimit.Limiter) {
+	mws := append(middleware.Auth(cfg), rl.ReadWrite())
+
+	api := e.Group("/api/v1/departments", mws...)
+	api.GET("", h.listDepartments, h.authz.Authorize("department", "list"))
+	api.POST("", h.createDepartment, h.authz.Authorize("department", "create"))
+	api.GET("/:id", h.getDepartment, h.authz.Authorize("department", "get"))
+	api.PUT("/:id", h.updateDepartment, h.authz.Authorize("department", "update"))
+	api.DELETE("/:id", h.deleteDepartment, h.authz.Authorize("department", "delete"))
+
+	pos := e.Group("/api/v1/positions", mws...)
+	pos.GET("", h.listPositions, h.authz.Authorize("position", "list"))
+	pos.POST("", h.createPosition, h.authz.Authorize("position", "create"))
+	pos.GET("/:id", h.getPosition, h.authz.Authorize("position", "get"))
+	pos.PUT("/:id", h.updatePosition, h.authz.Authorize("position", "update"))
+	pos.DELETE("/:id", h.deletePosition, h.authz.Authorize("position", "delete"))
+
+	page := e.Group("", mws...)
+	page.GET("/departments", h.departmentsPage, h.authz.Authorize("department", "list"))
+	page.GET("/departments/options", h.departmentOptions, h.authz.Authorize("department", "list"))
+	page.POST("/departments", h.createDepartmentPage, h.authz.Authorize("department", "create"))
+	page.GET("/departments/:id/edit", h.editDepartmentPage, h.authz.Authorize("department", "update"))
+	page.PUT("/departments/:id", h.updateDepartmentPage, h.authz.Authorize("department", "update"))
+	page.DELETE("/departments/:id", h.deleteDepartmentPage, h.authz.Authorize("department", "delete"))
+	page.GET("/positions", h.positionsPage, h.authz.Authorize("position", "list"))
+	page.GET("/positions/options", h.positionOptions, h.authz.Authorize("position", "list"))
+	page.POST("/positions", h.createPositionPage, h.authz.Authorize("position", "create"))
+	page.GET("/positions/:id/edit", h.editPositionPage, h.authz.Authorize("position", "update"))
+	page.PUT("/positions/:id", h.updatePositionPage, h.authz.Authorize("position", "update"))
+	page.DELETE("/positions/:id", h.deletePositionPage, h.authz.Authorize("position", "delete"))
+}
+
+func (h *handler) listDepartments(c echo.Context) error {
+	ctx := c.Request().Context()
+	var (
+		res []Department
+		err error
+	)
+	if c.QueryParam("tree") == "true" {
+		res, err = h.department.Tree(ctx)
+	} else {
+		res, err = h.department.ListDepartments(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *handler) getDepartment(c echo.Context) error {
+	res, err := h.department.GetDepartment(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *handler) createDepartment(c echo.Context) error {
+	var req Department
+	if err := c.Bind(&req); err != nil {
+		logger.FromContext(c.Request().Context()).Warn("bind request", "err", err)
+		return apperror.StatusBindingFailure.Err()
+	}
+	ctx := c.Request().Context()
+	req.CreatedBy = middleware.UserClaimFromContext(ctx).ID
+	res, err := h.department.CreateDepartment(ctx, req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, res)
+}
+
+func (h *handler) updateDepartment(c echo.Context) error {
+	var req Department
+	if err := c.Bind(&req); err != nil {
+		logger.FromContext(c.Request().Context()).Warn("bind request", "err", err)
+		return apperror.StatusBindingFailure.Err()
+	}
+	ctx := c.Request().Context()
+	req.ID = c.Param("id")
+	req.UpdatedBy = middleware.UserClaimFromContext(ctx).ID
+	res, err := h.department.UpdateDepartment(ctx, req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *handler) deleteDepartment(c echo.Context) error {
+	if err := h.department.DeleteDepartment(c.Request().Context(), c.Param("id")); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *handler) listPositions(c echo.Context) error {
+	res, err := h.department.ListPositions(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *handler) getPosition(c echo.Context) error {
+	res, err := h.department.GetPosition(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *handler) createPosition(c echo.Context) error {
+	var req Position
+	if err := c.Bind(&req); err != nil {
+		logger.FromContext(c.Request().Context()).Warn("bind request", "err", err)
+		return apperror.StatusBindingFailure.Err()
+	}
+	ctx := c.Request().Context()
+	req.CreatedBy = middleware.UserClaimFromContext(ctx).ID
+	res, err := h.department.CreatePosition(ctx, req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, res)
+}
+
+func (h *handler) updatePosition(c echo.Context) error {
+	var req Position
+	if err := c.Bind(&req); err != nil {
+		logger.FromContext(c.Request().Context()).Warn("bind request", "err", err)
+		return apperror.StatusBindingFailure.Err()
+	}
+	ctx := c.Request().Context()
+	req.ID = c.Param("id")
+	req.UpdatedBy = middleware.UserClaimFromContext(ctx).ID
+	res, err := h.department.UpdatePosition(ctx, req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *handler) deletePosition(c echo.Context) error {
+	if err := h.department.DeletePosition(c.Request().Context(), c.Param("id")); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// renderDepartments renders the department tree with form in its editor.
+func (h *handler) renderDepartments(c echo.Context, form Department) error {
+	ctx := c.Request().Context()
+	rows, err := h.department.Rows(ctx)
+	if err != nil {
+		return err
+	}
+	return tracing.Component("DepartmentsPage", DepartmentsPage(rows, form)).Render(ctx, c.Response().Writer)
+}
+
+func (h *handler) departmentsPage(c echo.Context) error {
+	return h.renderDepartments(c, Department{})
+}
+
+// departmentOptions renders the <option>s of the department selects.
+func (h *handler) departmentOptions(c echo.Context) error {
+	ctx := c.Request().Context()
+	rows, err := h.department.Rows(ctx)
+	if err != nil {
+		return err
+	}
+	return tracing.Component("DepartmentOptions", DepartmentOptions(rows, c.QueryParam("selected"))).Render(ctx, c.Response().Writer)
+}
+
+func departmentFromForm(c echo.Context) Department {
+	return Department{
+		ParentID: c.FormValue("parentID"),
+		Code:     c.FormValue("code"),
+		Name:     c.FormValue("name"),
+	}
+}
+
+func (h *handler) createDepartmentPage(c echo.Context) error {
+	ctx := c.Request().Context()
+	req := departmentFromForm(c)
+	req.CreatedBy = middleware.UserClaimFromContext(ctx).ID
+	if _, err := h.department.CreateDepartment(ctx, req); err != nil {
+		return err
+	}
+	return h.renderDepartments(c, Department{})
+}
+
+func (h *handler) editDepartmentPage(c echo.Context) error {
+	d, err := h.department.GetDepartment(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return h.renderDepartments(c, d)
+}
+
+func (h *handler) updateDepartmentPage(c echo.Context) error {
+	ctx := c.Request().Context()
+	req := departmentFromForm(c)
+	req.ID = c.Param("id")
+	req.UpdatedBy = middleware.UserClaimFromContext(ctx).ID
+	if _, err := h.department.UpdateDepartment(ctx, req); err != nil {
+		return err
+	}
+	return h.renderDepartments(c, Department{})
+}
+
+func (h *handler) deleteDepartmentPage(c echo.Context) error {
+	if err := h.department.DeleteDepartment(c.Request().Context(), c.Param("id")); err != nil {
+		return err
+	}
+	return h.renderDepartments(c, Department{})
+}
+
+// renderPositions renders the positions with form in their editor.
+func (h *handler) renderPositions(c echo.Context, form Position) error {
+	ctx := c.Request().Context()
+	positions, err := h.department.ListPositions(ctx)
+	if err != nil {
+		return err
+	}
+	return tracing.Component("PositionsPage", PositionsPage(positions, form)).Render(ctx, c.Response().Writer)
+}
+
+func (h *handler) positionsPage(c echo.Context) error {
+	return h.renderPositions(c, Position{})
+}
+
+// positionOptions renders the <option>s of the position selects.
+func (h *handler) positionOptions(c echo.Context) error {
+	ctx := c.Request().Context()
+	positions, err := h.department.ListPositions(ctx)
+	if err != nil {
+		return err
+	}
+	return tracing.Component("PositionOptions", PositionOptions(positions, c.QueryParam("selected"))).Render(ctx, c.Response().Writer)
+}
+
+func positionFromForm(c echo.Context) Position {
+	return Position{
+		Code: c.FormValue("code"),
+		Name: c.FormValue("name"),
+	}
+}
+
+func (h *handler) createPositionPage(c echo.Context) error {
+	ctx := c.Request().Context()
+	req := positionFromForm(c)
+	req.CreatedBy = middleware.UserClaimFromContext(ctx).ID
+	if _, err := h.department.CreatePosition(ctx, req); err != nil {
+		return err
+	}
+	return h.renderPositions(c, Position{})
+}
+
+func (h *handler) editPositionPage(c echo.Context) error {
+	p, err := h.department.GetPosition(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return h.renderPositions(c, p)
+}
+
+func (h *handler) updatePositionPage(c echo.Context) error {
+	ctx := c.Request().Context()
+	req := positionFromForm(c)
+	req.ID = c.Param("id")
+	req.UpdatedBy = middleware.UserClaimFromContext(ctx).ID
+	if _, err := h.department.UpdatePosition(ctx, req); err != nil {
+		return err
+	}
+	return h.renderPositions(c, Position{})
+}
+
+func (h *handler) deletePositionPage(c echo.Context) error {
+	if err := h.department.DeletePosition(c.Request().Context(), c.Param("id")); err != nil {
+		return err
+	}
+	return h.renderPositions(c, Position{})
+}
@@ -0,0 +1,169 @@
+package department
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/database"
+	"github.com/anousonefs/golang-htmx-template/internal/metrics"
+
+	"github.com/Masterminds/squirrel"
+)
+
+type Repo struct {
+	db database.DBTX
+	sb squirrel.StatementBuilderType
+}
+
+func NewRepo(db *sql.DB, sb squirrel.StatementBuilderType) *Repo {
+	return &Repo{db: db, sb: sb}
+}
+
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+func (r Repo) exec(ctx context.Context, q squirrel.Sqlizer) (int64, error) {
+	query, args, err := q.ToSql()
+	if err != nil {
+		return 0, err
+	}
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// mustAffect turns an update or delete of no row into sql.ErrNoRows.
+func mustAffect(n int64, err error) error {
+	if err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+func (r Repo) createDepartment(ctx context.Context, d Department) error {
+	defer metrics.ObserveQuery("department", "createDepartment")()
+	t := now()
+	_, err := r.exec(ctx, r.sb.
+		Insert("departments").
+		Columns("id", "parent_id", "code", "name", "created_at", "created_by", "updated_at", "updated_by").
+		Values(d.ID, d.ParentID, d.Code, d.Name, t, d.CreatedBy, t, d.CreatedBy))
+	return err
+}
+
+func (r Repo) updateDepartment(ctx context.Context, d Department) error {
+	defer metrics.ObserveQuery("department", "updateDepartment")()
+	return mustAffect(r.exec(ctx, r.sb.
+		Update("departments").
+		SetMap(squirrel.Eq{
+			"parent_id":  d.ParentID,
+			"code":       d.Code,
+			"name":       d.Name,
+			"updated_at": now(),
+			"updated_by": d.UpdatedBy,
+		}).
+		Where(squirrel.Eq{"id": d.ID})))
+}
+
+func (r Repo) deleteDepartment(ctx context.Context, id string) error {
+	defer metrics.ObserveQuery("department", "deleteDepartment")()
+	return mustAffect(r.exec(ctx, r.sb.Delete("departments").Where(squirrel.Eq{"id": id})))
+}
+
+func (r Repo) listDepartments(ctx context.Context, where squirrel.Sqlizer) ([]Department, error) {
+	defer metrics.ObserveQuery("department", "listDepartments")()
+	query, args, err := r.sb.
+		Select("id", "parent_id", "code", "name", "created_at", "created_by", "updated_at", "updated_by").
+		From("departments").
+		Where(where).
+		OrderBy("name").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []Department{}
+	for rows.Next() {
+		var d Department
+		if err := rows.Scan(&d.ID, &d.ParentID, &d.Code, &d.Name, &d.CreatedAt, &d.CreatedBy, &d.UpdatedAt, &d.UpdatedBy); err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+	return res, rows.Err()
+}
+
+func (r Repo) createPosition(ctx context.Context, p Position) error {
+	defer metrics.ObserveQuery("department", "createPosition")()
+	t := now()
+	_, err := r.exec(ctx, r.sb.
+		Insert("positions").
+		Columns("id", "code", "name", "created_at", "created_by", "updated_at", "updated_by").
+		Values(p.ID, p.Code, p.Name, t, p.CreatedBy, t, p.CreatedBy))
+	return err
+}
+
+func (r Repo) updatePosition(ctx context.Context, p Position) error {
+	defer metrics.ObserveQuery("department", "updatePosition")()
+	return mustAffect(r.exec(ctx, r.sb.
+		Update("positions").
+		SetMap(squirrel.Eq{
+			"code":       p.Code,
+			"name":       p.Name,
+			"updated_at": now(),
+			"updated_by": p.UpdatedBy,
+		}).
+		Where(squirrel.Eq{"id": p.ID})))
+}
+
+func (r Repo) deletePosition(ctx context.Context, id string) error {
+	defer metrics.ObserveQuery("department", "deletePosition")()
+	return mustAffect(r.exec(ctx, r.sb.Delete("positions").Where(squirrel.Eq{"id": id})))
+}
+
+func (r Repo) listPositions(ctx context.Context, where squirrel.Sqlizer) ([]Position, error) {
+	defer metrics.ObserveQuery("department", "listPositions")()
+	query, args, err := r.sb.
+		Select("id", "code", "name", "created_at", "created_by", "updated_at", "updated_by").
+		From("positions").
+		Where(where).
+		OrderBy("name").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []Position{}
+	for rows.Next() {
+		var p Position
+		if err := rows.Scan(&p.ID, &p.Code, &p.Name, &p.CreatedAt, &p.CreatedBy, &p.UpdatedAt, &p.UpdatedBy); err != nil {
+			return nil, err
+		}
+		res = append(res, p)
+	}
+	return res, rows.Err()
+}
+
+// countUsers counts the users matching where, e.g. the members of a
+// department.
+func (r Repo) countUsers(ctx context.Context, where squirrel.Sqlizer) (int, error) {
+	defer metrics.ObserveQuery("department", "countUsers")()
+	query, args, err := r.sb.Select("COUNT(*)").From("users").Where(where).ToSql()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	err = r.db.QueryRowContext(ctx, query, args...).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,267 @@
+package department
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/database"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/utils"
+	"github.com/anousonefs/golang-htmx-template/internal/validation"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+)
+
+type Service struct {
+	repo *Repo
+}
+
+func NewService(repo *Repo) *Service {
+	return &Service{repo: repo}
+}
+
+// storeErr maps the errors of the repo to the ones of the API.
+func storeErr(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperror.ErrStatusNotFound
+	}
+	if errors.Is(database.NormalizeError(err), database.ErrUniqueViolation) {
+		return apperror.ErrAlreadyExist
+	}
+	return err
+}
+
+// logErr logs the unexpected errors of op.
+func logErr(ctx context.Context, op string, err error) {
+	var verr *validation.Error
+	if err == nil ||
+		errors.As(err, &verr) ||
+		errors.Is(err, apperror.ErrStatusNotFound) ||
+		errors.Is(err, apperror.ErrAlreadyExist) ||
+		errors.Is(err, apperror.ErrInUse) {
+		return
+	}
+	logger.FromContext(ctx).Error(op, "err", err)
+}
+
+func (s *Service) ListDepartments(ctx context.Context) (res []Department, err error) {
+	res, err = s.repo.listDepartments(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Error("department.ListDepartments", "err", err)
+		return []Department{}, err
+	}
+	return res, nil
+}
+
+// Tree returns the departments nested under their parent.
+func (s *Service) Tree(ctx context.Context) ([]Department, error) {
+	all, err := s.ListDepartments(ctx)
+	if err != nil {
+		return []Department{}, err
+	}
+	return tree(all), nil
+}
+
+// Rows returns the tree flattened in display order.
+func (s *Service) Rows(ctx context.Context) ([]Row, error) {
+	nodes, err := s.Tree(ctx)
+	if err != nil {
+		return []Row{}, err
+	}
+	return flatten(nodes, 0, []Row{}), nil
+}
+
+// Subtree returns id and the ids of the departments under it, the reach of
+// a department scoped permission.
+func (s *Service) Subtree(ctx context.Context, id string) ([]string, error) {
+	all, err := s.ListDepartments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return descendants(all, id), nil
+}
+
+func (s *Service) GetDepartment(ctx context.Context, id string) (res Department, err error) {
+	defer func() { logErr(ctx, "department.GetDepartment", err) }()
+	all, err := s.repo.listDepartments(ctx, squirrel.Eq{"id": id})
+	if err != nil {
+		return Department{}, err
+	}
+	if len(all) == 0 {
+		return Department{}, apperror.ErrStatusNotFound
+	}
+	return all[0], nil
+}
+
+// checkParent rejects a parent that does not exist, or that would put the
+// department d under itself.
+func (s *Service) checkParent(ctx context.Context, d Department) error {
+	if d.ParentID == "" {
+		return nil
+	}
+	all, err := s.repo.listDepartments(ctx, nil)
+	if err != nil {
+		return err
+	}
+	v := validation.New()
+	exists := false
+	for _, o := range all {
+		exists = exists || o.ID == d.ParentID
+	}
+	v.Exists("parentID", d.ParentID, exists)
+	v.Cycle("parentID", d.ParentID, d.ID != "" && utils.ContainsString(descendants(all, d.ID), d.ParentID))
+	return v.Err()
+}
+
+func (s *Service) CreateDepartment(ctx context.Context, d Department) (res Department, err error) {
+	defer func() { logErr(ctx, "department.CreateDepartment", err) }()
+	if err := d.Validate(); err != nil {
+		return Department{}, err
+	}
+	d.ID = ""
+	if err := s.checkParent(ctx, d); err != nil {
+		return Department{}, err
+	}
+	d.ID = uuid.NewString()
+	if err := s.repo.createDepartment(ctx, d); err != nil {
+		return Department{}, storeErr(err)
+	}
+	return s.GetDepartment(ctx, d.ID)
+}
+
+func (s *Service) UpdateDepartment(ctx context.Context, d Department) (res Department, err error) {
+	defer func() { logErr(ctx, "department.UpdateDepartment", err) }()
+	if err := d.Validate(); err != nil {
+		return Department{}, err
+	}
+	if err := s.checkParent(ctx, d); err != nil {
+		return Department{}, err
+	}
+	if err := s.repo.updateDepartment(ctx, d); err != nil {
+		return Department{}, storeErr(err)
+	}
+	return s.GetDepartment(ctx, d.ID)
+}
+
+// DeleteDepartment removes a department without sub departments nor
+// members.
+func (s *Service) DeleteDepartment(ctx context.Context, id string) (err error) {
+	defer func() { logErr(ctx, "department.DeleteDepartment", err) }()
+	children, err := s.repo.listDepartments(ctx, squirrel.Eq{"parent_id": id})
+	if err != nil {
+		return err
+	}
+	members, err := s.repo.countUsers(ctx, squirrel.Eq{"department_id": id})
+	if err != nil {
+		return err
+	}
+	if len(children) > 0 || members > 0 {
+		return apperror.ErrInUse
+	}
+	return storeErr(s.repo.deleteDepartment(ctx, id))
+}
+
+func (s *Service) ListPositions(ctx context.Context) (res []Position, err error) {
+	res, err = s.repo.listPositions(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Error("department.ListPositions", "err", err)
+		return []Position{}, err
+	}
+	return res, nil
+}
+
+func (s *Service) GetPosition(ctx context.Context, id string) (res Position, err error) {
+	defer func() { logErr(ctx, "department.GetPosition", err) }()
+	all, err := s.repo.listPositions(ctx, squirrel.Eq{"id": id})
+	if err != nil {
+		return Position{}, err
+	}
+	if len(all) == 0 {
+		return Position{}, apperror.ErrStatusNotFound
+	}
+	return all[0], nil
+}
+
+func (s *Service) CreatePosition(ctx context.Context, p Position) (res Position, err error) {
+	defer func() { logErr(ctx, "department.CreatePosition", err) }()
+	if err := p.Validate(); err != nil {
+		return Position{}, err
+	}
+	p.ID = uuid.NewString()
+	if err := s.repo.createPosition(ctx, p); err != nil {
+		return Position{}, storeErr(err)
+	}
+	return s.GetPosition(ctx, p.ID)
+}
+
+func (s *Service) UpdatePosition(ctx context.Context, p Position) (res Position, err error) {
+	defer func() { logErr(ctx, "department.UpdatePosition", err) }()
+	if err := p.Validate(); err != nil {
+		return Position{}, err
+	}
+	if err := s.repo.updatePosition(ctx, p); err != nil {
+		return Position{}, storeErr(err)
+	}
+	return s.GetPosition(ctx, p.ID)
+}
+
+// DeletePosition removes a position no user holds.
+func (s *Service) DeletePosition(ctx context.Context, id string) (err error) {
+	defer func() { logErr(ctx, "department.DeletePosition", err) }()
+	holders, err := s.repo.countUsers(ctx, squirrel.Eq{"position_id": id})
+	if err != nil {
+		return err
+	}
+	if holders > 0 {
+		return apperror.ErrInUse
+	}
+	return storeErr(s.repo.deletePosition(ctx, id))
+}
+
+// CheckAssignment rejects the department and position ids that do not
+// exist, empty ids pass. user.Service calls it before saving a user.
+func (s *Service) CheckAssignment(ctx context.Context, departmentID, positionID string) (err error) {
+	defer func() { logErr(ctx, "department.CheckAssignment", err) }()
+	v := validation.New()
+	if departmentID != "" {
+		res, err := s.repo.listDepartments(ctx, squirrel.Eq{"id": departmentID})
+		if err != nil {
+			return err
+		}
+		v.Exists("departmentID", departmentID, len(res) > 0)
+	}
+	if positionID != "" {
+		res, err := s.repo.listPositions(ctx, squirrel.Eq{"id": positionID})
+		if err != nil {
+			return err
+		}
+		v.Exists("positionID", positionID, len(res) > 0)
+	}
+	return v.Err()
+}
+
+// Codes maps the codes of the departments and of the positions to their
+// ids, the imports refer to them by code.
+func (s *Service) Codes(ctx context.Context) (departments, positions map[string]string, err error) {
+	defer func() { logErr(ctx, "department.Codes", err) }()
+	ds, err := s.repo.listDepartments(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	ps, err := s.repo.listPositions(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	departments = make(map[string]string, len(ds))
+	for _, d := range ds {
+		departments[d.Code] = d.ID
+	}
+	positions = make(map[string]string, len(ps))
+	for _, p := range ps {
+		positions[p.Code] = p.ID
+	}
+	return departments, positions, nil
+}
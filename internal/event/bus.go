@@ -0,0 +1,200 @@
+package event
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+)
+
+const (
+	// maxAttempts is the number of tries of an event before it is left
+	// failed in the outbox.
+	maxAttempts = 10
+	// retention is how long the published events are kept.
+	retention = 7 * 24 * time.Hour
+)
+
+// Handler runs in the transaction marking the event published, its writes
+// commit with it and an error rolls both back so the event is retried.
+// Handlers therefore see an event at least once and must not call out of
+// the database, queue a job for that.
+type Handler func(ctx context.Context, tx *sql.Tx, e Event) error
+
+type subscription struct {
+	names   []string
+	handler Handler
+}
+
+func (s subscription) wants(name string) bool {
+	if len(s.names) == 0 {
+		return true
+	}
+	for _, n := range s.names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Bus delivers the published events to their subscribers once the
+// transaction publishing them commits. The events go through the outbox
+// table, the publishers neither know nor wait for the subscribers.
+type Bus struct {
+	repo     *Repo
+	interval time.Duration
+	log      *slog.Logger
+	subs     []subscription
+}
+
+// NewBus returns a bus looking for new events every interval.
+func NewBus(repo *Repo, interval time.Duration, log *slog.Logger) *Bus {
+	return &Bus{repo: repo, interval: interval, log: log}
+}
+
+// Subscribe registers h for the events of the given types, every event
+// when there is none. Subscribe before Run.
+func (b *Bus) Subscribe(h Handler, events ...Event) {
+	names := make([]string, len(events))
+	for i, e := range events {
+		names[i] = e.EventName()
+	}
+	b.subs = append(b.subs, subscription{names: names, handler: h})
+}
+
+// Publish stores the events in the outbox within tx, they are only
+// delivered if it commits. A nil tx publishes right away.
+func (b *Bus) Publish(ctx context.Context, tx *sql.Tx, events ...Event) error {
+	repo := b.repo
+	if tx != nil {
+		repo = repo.WithTx(tx)
+	}
+	for _, e := range events {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := repo.insert(ctx, message{
+			Name:      e.EventName(),
+			Payload:   payload,
+			RequestID: logger.RequestIDFromContext(ctx),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run delivers the events until ctx is done.
+func (b *Bus) Run(ctx context.Context) error {
+	t := time.NewTicker(b.interval)
+	defer t.Stop()
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+	for {
+		if _, err := b.Dispatch(ctx); err != nil && ctx.Err() == nil {
+			b.log.Error("dispatch events", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-cleanup.C:
+			b.cleanup(ctx)
+		case <-t.C:
+		}
+	}
+}
+
+// Dispatch delivers the due events and returns how many were published.
+func (b *Bus) Dispatch(ctx context.Context) (n int, err error) {
+	for ctx.Err() == nil {
+		ok, err := b.dispatchOne(ctx)
+		if err != nil {
+			return n, err
+		}
+		if !ok {
+			return n, nil
+		}
+		n++
+	}
+	return n, nil
+}
+
+// dispatchOne delivers the next event, false when there is none due. A
+// failed subscriber is not an error of the dispatch.
+func (b *Bus) dispatchOne(ctx context.Context) (bool, error) {
+	var m *message
+	var handlerErr error
+	err := b.repo.inTx(ctx, func(tx *sql.Tx) error {
+		repo := b.repo.WithTx(tx)
+		var err error
+		if m, err = repo.claim(ctx); err != nil {
+			return err
+		}
+		hctx := logger.WithRequestID(ctx, m.RequestID)
+		if handlerErr = b.deliver(hctx, tx, m); handlerErr != nil {
+			return handlerErr
+		}
+		return repo.published(ctx, m.ID)
+	})
+	if handlerErr == nil {
+		// only claim finding nothing due, a subscriber may wrap it too.
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	log := b.log.With("event_id", m.ID, "event", m.Name, "attempt", m.Attempts+1, "err", handlerErr)
+	var retryAt time.Time
+	if m.Attempts+1 < maxAttempts {
+		retryAt = time.Now().Add(backoff(m.Attempts + 1))
+		log.Warn("event handler failed, retrying", "retry_at", retryAt)
+	} else {
+		log.Error("event handler failed, giving up")
+	}
+	return true, b.repo.fail(ctx, m.ID, handlerErr.Error(), retryAt)
+}
+
+func (b *Bus) deliver(ctx context.Context, tx *sql.Tx, m *message) error {
+	e, err := decode(m.Name, m.Payload)
+	if err != nil {
+		return err
+	}
+	for _, s := range b.subs {
+		if !s.wants(m.Name) {
+			continue
+		}
+		if err := s.handler(ctx, tx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bus) cleanup(ctx context.Context) {
+	n, err := b.repo.delete(ctx, FilterMessage{Status: StatusPublished, Before: time.Now().Add(-retention)})
+	if err != nil {
+		b.log.Error("delete published events", "err", err)
+		return
+	}
+	if n > 0 {
+		b.log.Info("published events deleted", "count", n)
+	}
+}
+
+// backoff is 5s doubled at each attempt up to 30 minutes, with up to 20%
+// jitter.
+func backoff(attempt int) time.Duration {
+	d := 30 * time.Minute
+	if attempt < 10 {
+		d = min(5*time.Second<<max(attempt-1, 0), d)
+	}
+	return d + time.Duration(rand.Int63n(int64(d/5)+1))
+}
@@ -0,0 +1,185 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// Event is a fact about the domain, published by the service that made the
+// change and handled by whoever subscribed to it.
+type Event interface {
+	EventName() string
+}
+
+// Actor is who made a change: a user id, or "cli:<name>" and "bootstrap"
+// outside of a request.
+type Actor struct {
+	ID           string `json:"id,omitempty"`
+	DepartmentID string `json:"departmentID,omitempty"`
+}
+
+type UserCreated struct {
+	UserID       string `json:"userID"`
+	Email        string `json:"email"`
+	FirstName    string `json:"firstName"`
+	LastName     string `json:"lastName"`
+	RoleID       string `json:"roleID"`
+	DepartmentID string `json:"departmentID,omitempty"`
+	PositionID   string `json:"positionID,omitempty"`
+	Actor        Actor  `json:"actor"`
+}
+
+func (UserCreated) EventName() string { return "user.created" }
+
+type PasswordReset struct {
+	UserID string `json:"userID"`
+	Actor  Actor  `json:"actor"`
+}
+
+func (PasswordReset) EventName() string { return "user.password_reset" }
+
+type StatusChanged struct {
+	UserID string `json:"userID"`
+	Status string `json:"status"`
+	Actor  Actor  `json:"actor"`
+}
+
+func (StatusChanged) EventName() string { return "user.status_changed" }
+
+type RoleChanged struct {
+	UserID string `json:"userID"`
+	RoleID string `json:"roleID"`
+	Actor  Actor  `json:"actor"`
+}
+
+func (RoleChanged) EventName() string { return "user.role_changed" }
+
+// SignerChanged is published when a user is flagged as a signer or no
+// longer is.
+type SignerChanged struct {
+	UserID   string `json:"userID"`
+	IsSigner bool   `json:"isSigner"`
+	Actor    Actor  `json:"actor"`
+}
+
+func (SignerChanged) EventName() string { return "user.signer_changed" }
+
+// SignatureChanged is published when a signer records a new signature.
+type SignatureChanged struct {
+	UserID      string `json:"userID"`
+	SignatureID int64  `json:"signatureID"`
+	Key         string `json:"key"`
+	SHA256      string `json:"sha256"`
+	Actor       Actor  `json:"actor"`
+}
+
+func (SignatureChanged) EventName() string { return "user.signature_changed" }
+
+// PermissionsChanged is published when a policy of the role is added or
+// removed.
+type PermissionsChanged struct {
+	RoleID string `json:"roleID"`
+	Actor  Actor  `json:"actor"`
+}
+
+func (PermissionsChanged) EventName() string { return "role.permissions_changed" }
+
+type LoginSucceeded struct {
+	UserID   string `json:"userID"`
+	Email    string `json:"email"`
+	Provider string `json:"provider"`
+}
+
+func (LoginSucceeded) EventName() string { return "auth.login_succeeded" }
+
+type LoginFailed struct {
+	Email    string `json:"email"`
+	Provider string `json:"provider"`
+	Reason   string `json:"reason"`
+}
+
+func (LoginFailed) EventName() string { return "auth.login_failed" }
+
+type decoder struct {
+	name   string
+	decode func(payload []byte) (Event, error)
+}
+
+func register[T Event]() decoder {
+	var zero T
+	return decoder{zero.EventName(), func(payload []byte) (Event, error) {
+		var e T
+		err := json.Unmarshal(payload, &e)
+		return e, err
+	}}
+}
+
+// decoders lists every event, an event type must be added here to be
+// published.
+var decoders = []decoder{
+	register[UserCreated](),
+	register[PasswordReset](),
+	register[StatusChanged](),
+	register[RoleChanged](),
+	register[SignerChanged](),
+	register[SignatureChanged](),
+	register[PermissionsChanged](),
+	register[LoginSucceeded](),
+	register[LoginFailed](),
+}
+
+// Names returns the names of the events in a stable order.
+func Names() []string {
+	names := make([]string, len(decoders))
+	for i, d := range decoders {
+		names[i] = d.name
+	}
+	return names
+}
+
+func decode(name string, payload []byte) (Event, error) {
+	for _, d := range decoders {
+		if d.name == name {
+			return d.decode(payload)
+		}
+	}
+	return nil, fmt.Errorf("unknown event %q", name)
+}
+
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusPublished Status = "published"
+	// StatusFailed events had a subscriber fail on every attempt.
+	StatusFailed Status = "failed"
+)
+
+// message is an event stored in the outbox.
+type message struct {
+	ID        int64
+	Name      string
+	Payload   []byte
+	RequestID string
+	Attempts  int
+	CreatedAt time.Time
+}
+
+type FilterMessage struct {
+	Status Status
+	Before time.Time
+}
+
+func (f FilterMessage) ToSql() (string, []interface{}, error) {
+	and := squirrel.And{}
+	if f.Status != "" {
+		and = append(and, squirrel.Eq{"status": f.Status})
+	}
+	if !f.Before.IsZero() {
+		and = append(and, squirrel.Lt{"updated_at": f.Before.UTC()})
+	}
+	return and.ToSql()
+}
@@ -0,0 +1,157 @@
+package event
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/database"
+	"github.com/anousonefs/golang-htmx-template/internal/metrics"
+
+	"github.com/Masterminds/squirrel"
+)
+
+type Repo struct {
+	db     database.DBTX
+	sb     squirrel.StatementBuilderType
+	driver string
+}
+
+func NewRepo(db *sql.DB, sb squirrel.StatementBuilderType, driver string) *Repo {
+	return &Repo{db: db, sb: sb, driver: driver}
+}
+
+// WithTx returns a copy of the repo that runs its queries on tx.
+func (r Repo) WithTx(tx *sql.Tx) *Repo {
+	return &Repo{db: tx, sb: r.sb, driver: r.driver}
+}
+
+func (r *Repo) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return database.WithTx(ctx, r.db, fn)
+}
+
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+func (r Repo) insert(ctx context.Context, m message) error {
+	defer metrics.ObserveQuery("event", "insert")()
+	t := now()
+	query, args, err := r.sb.
+		Insert("outbox").
+		Columns("name", "payload", "request_id", "status", "last_error", "available_at", "created_at", "updated_at").
+		Values(m.Name, string(m.Payload), m.RequestID, StatusPending, "", t, t, t).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+var messageColumns = []string{"id", "name", "payload", "request_id", "attempts", "created_at"}
+
+func scanMessage(row interface{ Scan(...any) error }) (*message, error) {
+	var m message
+	var payload string
+	if err := row.Scan(&m.ID, &m.Name, &payload, &m.RequestID, &m.Attempts, &m.CreatedAt); err != nil {
+		return nil, err
+	}
+	m.Payload = []byte(payload)
+	return &m, nil
+}
+
+// claim locks the oldest due message for the rest of the transaction of
+// the repo, sql.ErrNoRows when there is none. Other dispatchers skip it.
+func (r *Repo) claim(ctx context.Context) (*message, error) {
+	defer metrics.ObserveQuery("event", "claim")()
+	t := now()
+	next := r.sb.
+		Select("id").
+		From("outbox").
+		Where(squirrel.Eq{"status": StatusPending}).
+		Where(squirrel.LtOrEq{"available_at": t}).
+		OrderBy("id").
+		Limit(1)
+	touch := r.sb.Update("outbox").Set("updated_at", t)
+	if r.driver == config.DriverMysql {
+		// MySQL has no RETURNING, the locking read is enough.
+		query, args, err := next.RemoveColumns().Columns(messageColumns...).Suffix("FOR UPDATE SKIP LOCKED").ToSql()
+		if err != nil {
+			return nil, err
+		}
+		return scanMessage(r.db.QueryRowContext(ctx, query, args...))
+	}
+	if r.driver == config.DriverPostgres {
+		next = next.Suffix("FOR UPDATE SKIP LOCKED")
+	}
+	sub, args, err := next.PlaceholderFormat(squirrel.Question).ToSql()
+	if err != nil {
+		return nil, err
+	}
+	// writing first takes the SQLite write lock at once, the handlers
+	// writing later could otherwise fail to upgrade a read lock.
+	query, args, err := touch.
+		Where(squirrel.Expr("id = ("+sub+")", args...)).
+		Suffix("RETURNING " + strings.Join(messageColumns, ", ")).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	return scanMessage(r.db.QueryRowContext(ctx, query, args...))
+}
+
+func (r Repo) update(ctx context.Context, id int64, set map[string]interface{}) error {
+	set["updated_at"] = now()
+	query, args, err := r.sb.
+		Update("outbox").
+		SetMap(set).
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (r Repo) published(ctx context.Context, id int64) error {
+	defer metrics.ObserveQuery("event", "published")()
+	return r.update(ctx, id, map[string]interface{}{
+		"status":       StatusPublished,
+		"attempts":     squirrel.Expr("attempts + 1"),
+		"last_error":   "",
+		"published_at": now(),
+	})
+}
+
+// fail records a failed attempt, the message is tried again at retryAt or
+// given up when retryAt is zero.
+func (r Repo) fail(ctx context.Context, id int64, msg string, retryAt time.Time) error {
+	defer metrics.ObserveQuery("event", "fail")()
+	set := map[string]interface{}{
+		"attempts":   squirrel.Expr("attempts + 1"),
+		"last_error": msg,
+	}
+	if retryAt.IsZero() {
+		set["status"] = StatusFailed
+	} else {
+		set["available_at"] = retryAt.UTC()
+	}
+	return r.update(ctx, id, set)
+}
+
+func (r Repo) delete(ctx context.Context, where squirrel.Sqlizer) (int64, error) {
+	defer metrics.ObserveQuery("event", "delete")()
+	query, args, err := r.sb.Delete("outbox").Where(where).ToSql()
+	if err != nil {
+		return 0, err
+	}
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,148 @@
+package i18n
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/text/language"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	English = "en"
+	Lao     = "lo"
+
+	// CookieName holds the locale the user picked, it wins over
+	// Accept-Language.
+	CookieName = "lang"
+)
+
+//go:embed locales/*.yaml
+var locales embed.FS
+
+// Supported lists the shipped locales, the first one is the fallback when
+// a key is missing from the requested catalog.
+var Supported = []string{English, Lao}
+
+var (
+	catalogs      = map[string]map[string]string{}
+	defaultLocale = English
+	matcher       language.Matcher
+)
+
+func init() {
+	tags := make([]language.Tag, len(Supported))
+	for i, lang := range Supported {
+		b, err := locales.ReadFile(path.Join("locales", lang+".yaml"))
+		if err != nil {
+			panic(err)
+		}
+		messages := map[string]string{}
+		if err := yaml.Unmarshal(b, &messages); err != nil {
+			panic(fmt.Sprintf("i18n: %s.yaml: %v", lang, err))
+		}
+		catalogs[lang] = messages
+		tags[i] = language.MustParse(lang)
+	}
+	matcher = language.NewMatcher(tags)
+}
+
+// SetDefault changes the locale used when a request does not ask for one.
+func SetDefault(lang string) {
+	if IsSupported(lang) {
+		defaultLocale = lang
+	}
+}
+
+func Default() string {
+	return defaultLocale
+}
+
+func IsSupported(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// Translate returns the message of key in lang, falling back to English
+// and then to the key itself, so status messages without an entry are
+// still readable. args are applied with fmt.Sprintf.
+func Translate(lang, key string, args ...interface{}) string {
+	msg, ok := catalogs[lang][key]
+	if !ok {
+		if msg, ok = catalogs[Supported[0]][key]; !ok {
+			msg = key
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+type ctxKey int
+
+const langKey ctxKey = iota
+
+func WithLang(ctx context.Context, lang string) context.Context {
+	return context.WithValue(ctx, langKey, lang)
+}
+
+// Lang returns the locale negotiated for the request, see Middleware.
+func Lang(ctx context.Context) string {
+	if lang, ok := ctx.Value(langKey).(string); ok {
+		return lang
+	}
+	return defaultLocale
+}
+
+// T translates key for the locale of ctx, it is meant for templ
+// components the same way middleware.GetHtmxNonce is.
+func T(ctx context.Context, key string, args ...interface{}) string {
+	return Translate(Lang(ctx), key, args...)
+}
+
+// Negotiate picks the locale of r: the lang cookie first, then
+// Accept-Language, then the default.
+func Negotiate(r *http.Request) string {
+	if c, err := r.Cookie(CookieName); err == nil && IsSupported(c.Value) {
+		return c.Value
+	}
+	if accept := r.Header.Get("Accept-Language"); accept != "" {
+		tags, _, err := language.ParseAcceptLanguage(accept)
+		if err == nil && len(tags) > 0 {
+			_, idx, confidence := matcher.Match(tags...)
+			if confidence != language.No {
+				return Supported[idx]
+			}
+		}
+	}
+	return defaultLocale
+}
+
+func Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		lang := Negotiate(c.Request())
+		c.SetRequest(c.Request().WithContext(WithLang(c.Request().Context(), lang)))
+		c.Response().Header().Set("Content-Language", lang)
+		c.Response().Header().Add(echo.HeaderVary, "Accept-Language")
+		return next(c)
+	}
+}
+
+// SetCookie remembers lang for the following requests of the browser.
+func SetCookie(c echo.Context, lang string) {
+	c.SetCookie(&http.Cookie{
+		Name:     CookieName,
+		Value:    strings.ToLower(lang),
+		Expires:  time.Now().Add(365 * 24 * time.Hour),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
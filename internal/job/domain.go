@@ -0,0 +1,109 @@
+package job
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+)
+
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	// StatusDead jobs used all their attempts or failed permanently, they
+	// wait in the admin UI to be retried or deleted.
+	StatusDead Status = "dead"
+)
+
+var Statuses = []Status{StatusPending, StatusRunning, StatusDone, StatusDead}
+
+const (
+	DefaultQueue       = "default"
+	DefaultMaxAttempts = 5
+)
+
+type Job struct {
+	ID          int64           `json:"id"`
+	Queue       string          `json:"queue"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      Status          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"maxAttempts"`
+	LastError   string          `json:"lastError"`
+	LockedBy    string          `json:"lockedBy"`
+	RunAt       time.Time       `json:"runAt"`
+	FinishedAt  *time.Time      `json:"finishedAt"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+}
+
+// Option changes a job before it is enqueued.
+type Option func(*Job)
+
+func Queue(name string) Option {
+	return func(j *Job) { j.Queue = name }
+}
+
+// RunAt delays the job until t.
+func RunAt(t time.Time) Option {
+	return func(j *Job) { j.RunAt = t }
+}
+
+func Delay(d time.Duration) Option {
+	return func(j *Job) { j.RunAt = time.Now().Add(d) }
+}
+
+func MaxAttempts(n int) Option {
+	return func(j *Job) { j.MaxAttempts = n }
+}
+
+type FilterJob struct {
+	Status Status
+	Queue  string
+	Kind   string
+	Limit  uint64
+}
+
+func (f FilterJob) ToSql() (string, []interface{}, error) {
+	eq := squirrel.Eq{}
+	if f.Status != "" {
+		eq["status"] = f.Status
+	}
+	if f.Queue != "" {
+		eq["queue"] = f.Queue
+	}
+	if f.Kind != "" {
+		eq["kind"] = f.Kind
+	}
+	return eq.ToSql()
+}
+
+// Stats counts the jobs by status.
+type Stats map[Status]int
+
+// permanentError stops the retries of a job.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying, the job goes straight to the
+// dead jobs.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+func isPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
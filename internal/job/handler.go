@@ -0,0 +1,148 @@
+package job
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/middleware"
+	"github.com/anousonefs/golang-htmx-template/internal/ratelimit"
+	"github.com/anousonefs/golang-htmx-template/internal/tracing"
+	"github.com/anousonefs/golang-htmx-template/internal/validation"
+
+	"github.com/labstack/echo/v4"
+)
+
+// pageSize is the number of jobs listed at once.
+const pageSize = 100
+
+type handler struct {
+	job   *Service
+	authz *middleware.CasbinMiddleware
+}
+
+func NewHandler(job *Service, authz *middleware.CasbinMiddleware) *handler {
+	return &handler{job, authz}
+}
+
+// Install adds the admin pages and API of the job queue.
+func (h *handler) Install(e *echo.Echo, cfg config.Config, rl *ratelimit.Limiter) {
+	mws := append(middleware.Auth(cfg), rl.ReadWrite())
+
+	api := e.Group("/api/v1/jobs", mws...)
+	api.GET("", h.listJobs, h.authz.Authorize("job", "list"))
+	api.GET("/:id", h.getJob, h.authz.Authorize("job", "get"))
+	api.POST("/:id/retry", h.retryJob, h.authz.Authorize("job", "update"))
+	api.DELETE("/:id", h.deleteJob, h.authz.Authorize("job", "delete"))
+
+	page := e.Group("", mws...)
+	page.GET("/jobs", h.jobsPage, h.authz.Authorize("job", "list"))
+	page.POST("/jobs/:id/retry", h.retryJobPage, h.authz.Authorize("job", "update"))
+	page.DELETE("/jobs/:id", h.deleteJobPage, h.authz.Authorize("job", "delete"))
+}
+
+func filterFromQuery(c echo.Context) (FilterJob, error) {
+	f := FilterJob{
+		Status: Status(c.QueryParam("status")),
+		Queue:  c.QueryParam("queue"),
+		Kind:   c.QueryParam("kind"),
+		Limit:  pageSize,
+	}
+	v := validation.New()
+	validation.Enum(v, "status", f.Status, Statuses...)
+	return f, v.Err()
+}
+
+func paramID(c echo.Context) (int64, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, apperror.ErrBadRequest
+	}
+	return id, nil
+}
+
+func (h *handler) listJobs(c echo.Context) error {
+	filter, err := filterFromQuery(c)
+	if err != nil {
+		return err
+	}
+	res, err := h.job.ListJobs(c.Request().Context(), filter)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *handler) getJob(c echo.Context) error {
+	id, err := paramID(c)
+	if err != nil {
+		return err
+	}
+	res, err := h.job.GetJob(c.Request().Context(), id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *handler) retryJob(c echo.Context) error {
+	id, err := paramID(c)
+	if err != nil {
+		return err
+	}
+	if err := h.job.RetryJob(c.Request().Context(), id); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *handler) deleteJob(c echo.Context) error {
+	id, err := paramID(c)
+	if err != nil {
+		return err
+	}
+	if err := h.job.DeleteJob(c.Request().Context(), id); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *handler) jobsPage(c echo.Context) error {
+	ctx := c.Request().Context()
+	filter, err := filterFromQuery(c)
+	if err != nil {
+		return err
+	}
+	stats, err := h.job.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	jobs, err := h.job.ListJobs(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return tracing.Component("JobsPage", JobsPage(stats, jobs, filter.Status)).Render(ctx, c.Response().Writer)
+}
+
+func (h *handler) retryJobPage(c echo.Context) error {
+	id, err := paramID(c)
+	if err != nil {
+		return err
+	}
+	if err := h.job.RetryJob(c.Request().Context(), id); err != nil {
+		return err
+	}
+	return h.jobsPage(c)
+}
+
+func (h *handler) deleteJobPage(c echo.Context) error {
+	id, err := paramID(c)
+	if err != nil {
+		return err
+	}
+	if err := h.job.DeleteJob(c.Request().Context(), id); err != nil {
+		return err
+	}
+	return h.jobsPage(c)
+}
@@ -0,0 +1,504 @@
+package job
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/database"
+	"github.com/anousonefs/golang-htmx-template/internal/metrics"
+
+	"github.com/Masterminds/squirrel"
+)
+
+type Repo struct {
+	db     database.DBTX
+	sb     squirrel.StatementBuilderType
+	driver string
+}
+
+func NewRepo(db *sql.DB, sb squirrel.StatementBuilderType, driver string) *Repo {
+	return &Repo{db: db, sb: sb, driver: driver}
+}
+
+// WithTx returns a copy of the repo that runs its queries on tx.
+func (r Repo) WithTx(tx *sql.Tx) *Repo {
+	return &Repo{db: tx, sb: r.sb, driver: r.driver}
+}
+
+func (r *Repo) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return database.WithTx(ctx, r.db, fn)
+}
+
+// lockSuffix lets concurrent workers skip the rows another one is taking.
+// SQLite has a single writer, its transaction is enough.
+func (r Repo) lockSuffix() string {
+	if r.driver == config.DriverSqlite {
+		return ""
+	}
+	return "FOR UPDATE SKIP LOCKED"
+}
+
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+var jobColumns = []string{
+	"id",
+	"queue",
+	"kind",
+	"payload",
+	"status",
+	"attempts",
+	"max_attempts",
+	"last_error",
+	"locked_by",
+	"run_at",
+	"finished_at",
+	"created_at",
+	"updated_at",
+}
+
+func scanJob(row interface{ Scan(...any) error }) (*Job, error) {
+	var (
+		j          Job
+		payload    string
+		finishedAt sql.NullTime
+	)
+	if err := row.Scan(
+		&j.ID,
+		&j.Queue,
+		&j.Kind,
+		&payload,
+		&j.Status,
+		&j.Attempts,
+		&j.MaxAttempts,
+		&j.LastError,
+		&j.LockedBy,
+		&j.RunAt,
+		&finishedAt,
+		&j.CreatedAt,
+		&j.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	j.Payload = []byte(payload)
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+	return &j, nil
+}
+
+func (r Repo) insert(ctx context.Context, j *Job) (int64, error) {
+	defer metrics.ObserveQuery("job", "insert")()
+	t := now()
+	q := r.sb.
+		Insert("jobs").
+		Columns("queue", "kind", "payload", "status", "max_attempts", "last_error", "run_at", "created_at", "updated_at").
+		Values(j.Queue, j.Kind, string(j.Payload), StatusPending, j.MaxAttempts, "", j.RunAt.UTC(), t, t)
+	if r.driver == config.DriverPostgres {
+		query, args, err := q.Suffix("RETURNING id").ToSql()
+		if err != nil {
+			return 0, err
+		}
+		var id int64
+		err = r.db.QueryRowContext(ctx, query, args...).Scan(&id)
+		return id, err
+	}
+	query, args, err := q.ToSql()
+	if err != nil {
+		return 0, err
+	}
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// claim takes the oldest due job of queues with a kind in kinds and marks
+// it running for worker, sql.ErrNoRows when there is none.
+func (r *Repo) claim(ctx context.Context, queues, kinds []string, worker string) (*Job, error) {
+	defer metrics.ObserveQuery("job", "claim")()
+	t := now()
+	// the sub query is embedded in the update, its placeholders are
+	// numbered with the outer ones.
+	next := r.sb.
+		Select("id").
+		From("jobs").
+		Where(squirrel.Eq{"status": StatusPending, "queue": queues, "kind": kinds}).
+		Where(squirrel.LtOrEq{"run_at": t}).
+		OrderBy("run_at", "id").
+		Limit(1).
+		Suffix(r.lockSuffix()).
+		PlaceholderFormat(squirrel.Question)
+	if r.driver == config.DriverMysql {
+		return r.claimTx(ctx, next, worker, t)
+	}
+	sub, args, err := next.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	// a single statement takes the write lock at once, SQLite would fail
+	// to upgrade a read transaction under concurrent workers.
+	query, args, err := r.claimUpdate(worker, t).
+		Where(squirrel.Expr("id = ("+sub+")", args...)).
+		Suffix("RETURNING " + strings.Join(jobColumns, ", ")).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	return scanJob(r.db.QueryRowContext(ctx, query, args...))
+}
+
+func (r Repo) claimUpdate(worker string, t time.Time) squirrel.UpdateBuilder {
+	return r.sb.
+		Update("jobs").
+		Set("status", StatusRunning).
+		Set("attempts", squirrel.Expr("attempts + 1")).
+		Set("locked_by", worker).
+		Set("locked_at", t).
+		Set("updated_at", t).
+		Where(squirrel.Eq{"status": StatusPending})
+}
+
+// claimTx is claim for MySQL, which has no RETURNING.
+func (r *Repo) claimTx(ctx context.Context, next squirrel.SelectBuilder, worker string, t time.Time) (j *Job, err error) {
+	err = r.inTx(ctx, func(tx *sql.Tx) error {
+		query, args, err := next.RemoveColumns().Columns(jobColumns...).ToSql()
+		if err != nil {
+			return err
+		}
+		if j, err = scanJob(tx.QueryRowContext(ctx, query, args...)); err != nil {
+			return err
+		}
+		query, args, err = r.claimUpdate(worker, t).Where(squirrel.Eq{"id": j.ID}).ToSql()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+		j.Status = StatusRunning
+		j.Attempts++
+		j.LockedBy = worker
+		j.UpdatedAt = t
+		return nil
+	})
+	return j, err
+}
+
+func (r Repo) update(ctx context.Context, id int64, where squirrel.Sqlizer, set map[string]interface{}) (int64, error) {
+	set["updated_at"] = now()
+	query, args, err := r.sb.
+		Update("jobs").
+		SetMap(set).
+		Where(squirrel.Eq{"id": id}).
+		Where(where).
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r Repo) complete(ctx context.Context, id int64) error {
+	defer metrics.ObserveQuery("job", "complete")()
+	_, err := r.update(ctx, id, squirrel.Eq{"status": StatusRunning}, map[string]interface{}{
+		"status":      StatusDone,
+		"last_error":  "",
+		"locked_by":   "",
+		"locked_at":   nil,
+		"finished_at": now(),
+	})
+	return err
+}
+
+// fail puts the job back in the queue to run again at runAt.
+func (r Repo) fail(ctx context.Context, id int64, msg string, runAt time.Time) error {
+	defer metrics.ObserveQuery("job", "fail")()
+	_, err := r.update(ctx, id, squirrel.Eq{"status": StatusRunning}, map[string]interface{}{
+		"status":     StatusPending,
+		"last_error": msg,
+		"locked_by":  "",
+		"locked_at":  nil,
+		"run_at":     runAt.UTC(),
+	})
+	return err
+}
+
+func (r Repo) bury(ctx context.Context, id int64, msg string) error {
+	defer metrics.ObserveQuery("job", "bury")()
+	_, err := r.update(ctx, id, squirrel.Eq{"status": StatusRunning}, map[string]interface{}{
+		"status":      StatusDead,
+		"last_error":  msg,
+		"locked_by":   "",
+		"locked_at":   nil,
+		"finished_at": now(),
+	})
+	return err
+}
+
+// retry gives a dead job its attempts back.
+func (r Repo) retry(ctx context.Context, id int64) error {
+	defer metrics.ObserveQuery("job", "retry")()
+	n, err := r.update(ctx, id, squirrel.Eq{"status": StatusDead}, map[string]interface{}{
+		"status":      StatusPending,
+		"attempts":    0,
+		"finished_at": nil,
+		"run_at":      now(),
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// reclaim returns the jobs locked before t to the queue, their worker died
+// or lost the database while running them. A job that used all its
+// attempts this way is buried instead, it may be what kills the worker.
+func (r Repo) reclaim(ctx context.Context, t time.Time) (int64, error) {
+	defer metrics.ObserveQuery("job", "reclaim")()
+	var total int64
+	for _, status := range []Status{StatusDead, StatusPending} {
+		q := r.sb.
+			Update("jobs").
+			Set("status", status).
+			Set("last_error", "lock expired").
+			Set("locked_by", "").
+			Set("locked_at", nil).
+			Set("updated_at", now()).
+			Where(squirrel.Eq{"status": StatusRunning}).
+			Where(squirrel.Lt{"locked_at": t.UTC()})
+		if status == StatusDead {
+			q = q.Set("finished_at", now()).Where("attempts >= max_attempts")
+		}
+		query, args, err := q.ToSql()
+		if err != nil {
+			return total, err
+		}
+		res, err := r.db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return total, err
+		}
+		n, _ := res.RowsAffected()
+		total += n
+	}
+	return total, nil
+}
+
+func (r Repo) delete(ctx context.Context, where squirrel.Sqlizer) (int64, error) {
+	defer metrics.ObserveQuery("job", "delete")()
+	query, args, err := r.sb.Delete("jobs").Where(where).ToSql()
+	if err != nil {
+		return 0, err
+	}
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r Repo) get(ctx context.Context, id int64) (*Job, error) {
+	defer metrics.ObserveQuery("job", "get")()
+	query, args, err := r.sb.Select(jobColumns...).From("jobs").Where(squirrel.Eq{"id": id}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+	return scanJob(r.db.QueryRowContext(ctx, query, args...))
+}
+
+// list returns the matching jobs, the most recently updated first.
+func (r Repo) list(ctx context.Context, filter FilterJob) ([]Job, error) {
+	defer metrics.ObserveQuery("job", "list")()
+	q := r.sb.Select(jobColumns...).From("jobs").Where(filter).OrderBy("updated_at DESC", "id DESC")
+	if filter.Limit > 0 {
+		q = q.Limit(filter.Limit)
+	}
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []Job{}
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *j)
+	}
+	return res, rows.Err()
+}
+
+func (r Repo) stats(ctx context.Context) (Stats, error) {
+	defer metrics.ObserveQuery("job", "stats")()
+	query, args, err := r.sb.Select("status", "COUNT(*)").From("jobs").GroupBy("status").ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := Stats{}
+	for rows.Next() {
+		var (
+			status Status
+			n      int
+		)
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		res[status] = n
+	}
+	return res, rows.Err()
+}
+
+// saveSchedule creates the schedule, or updates it when its definition
+// changed, keeping next_run_at when the spec is the same.
+func (r *Repo) saveSchedule(ctx context.Context, s Schedule, next time.Time) error {
+	defer metrics.ObserveQuery("job", "saveSchedule")()
+	t := now()
+	query, args, err := r.sb.
+		Insert("job_schedules").
+		Columns("name", "spec", "queue", "kind", "payload", "next_run_at", "updated_at").
+		Values(s.Name, s.Spec, s.Queue, s.Kind, string(s.Payload), next.UTC(), t).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, query, args...)
+	if err == nil || !errors.Is(database.NormalizeError(err), database.ErrUniqueViolation) {
+		return err
+	}
+	set := squirrel.Eq{
+		"queue":      s.Queue,
+		"kind":       s.Kind,
+		"payload":    string(s.Payload),
+		"updated_at": t,
+	}
+	var spec string
+	query, args, err = r.sb.Select("spec").From("job_schedules").Where(squirrel.Eq{"name": s.Name}).ToSql()
+	if err != nil {
+		return err
+	}
+	if err := r.db.QueryRowContext(ctx, query, args...).Scan(&spec); err != nil {
+		return err
+	}
+	if spec != s.Spec {
+		set["spec"] = s.Spec
+		set["next_run_at"] = next.UTC()
+	}
+	query, args, err = r.sb.Update("job_schedules").SetMap(set).Where(squirrel.Eq{"name": s.Name}).ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// fireSchedules enqueues a job for every due schedule and moves it to its
+// next run. Moving it is the guard: a worker that finds the schedule
+// already moved by another one skips it, so each run fires once.
+func (r *Repo) fireSchedules(ctx context.Context, next func(Schedule, time.Time) time.Time) (n int, err error) {
+	defer metrics.ObserveQuery("job", "fireSchedules")()
+	t := now()
+	query, args, err := r.sb.
+		Select("name", "spec", "queue", "kind", "payload").
+		From("job_schedules").
+		Where(squirrel.LtOrEq{"next_run_at": t}).
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	var due []Schedule
+	for rows.Next() {
+		var (
+			s       Schedule
+			payload string
+		)
+		if err := rows.Scan(&s.Name, &s.Spec, &s.Queue, &s.Kind, &payload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		s.Payload = []byte(payload)
+		due = append(due, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, s := range due {
+		err := r.inTx(ctx, func(tx *sql.Tx) error {
+			query, args, err := r.sb.
+				Update("job_schedules").
+				Set("next_run_at", next(s, t).UTC()).
+				Set("last_run_at", t).
+				Set("updated_at", t).
+				Where(squirrel.Eq{"name": s.Name}).
+				Where(squirrel.LtOrEq{"next_run_at": t}).
+				ToSql()
+			if err != nil {
+				return err
+			}
+			res, err := tx.ExecContext(ctx, query, args...)
+			if err != nil {
+				return err
+			}
+			if moved, err := res.RowsAffected(); err != nil || moved == 0 {
+				return err
+			}
+			j := &Job{Queue: s.Queue, Kind: s.Kind, Payload: s.Payload, MaxAttempts: DefaultMaxAttempts, RunAt: t}
+			if _, err := r.WithTx(tx).insert(ctx, j); err != nil {
+				return err
+			}
+			n++
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// deleteSchedules removes the schedules no longer registered, keep holds
+// the names of the current ones.
+func (r Repo) deleteSchedules(ctx context.Context, keep []string) (int64, error) {
+	defer metrics.ObserveQuery("job", "deleteSchedules")()
+	q := r.sb.Delete("job_schedules")
+	if len(keep) > 0 {
+		q = q.Where(squirrel.NotEq{"name": keep})
+	}
+	query, args, err := q.ToSql()
+	if err != nil {
+		return 0, err
+	}
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,92 @@
+package job
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/migration"
+
+	_ "modernc.org/sqlite"
+)
+
+// TestClaimSqlite claims jobs from concurrent workers on sqlite, which has
+// no SKIP LOCKED: the single statement claim must still hand every job to
+// exactly one of them.
+func TestClaimSqlite(t *testing.T) {
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", filepath.Join(t.TempDir(), "test.db"))
+	db, err := sql.Open(config.DriverSqlite, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	m, err := migration.New(db, config.DriverSqlite, os.DirFS("../../cmd/migrations"), config.DriverSqlite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepo(db, config.StatementBuilder(config.DriverSqlite), config.DriverSqlite)
+	jobs := NewService(repo)
+
+	const n = 20
+	for i := 0; i < n; i++ {
+		if _, err := jobs.Enqueue(ctx, "test", map[string]int{"i": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		mu      sync.Mutex
+		claimed = map[int64]string{}
+		wg      sync.WaitGroup
+	)
+	for w := 0; w < 4; w++ {
+		worker := fmt.Sprintf("worker-%d", w)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				j, err := repo.claim(ctx, []string{DefaultQueue}, []string{"test"}, worker)
+				if errors.Is(err, sql.ErrNoRows) {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				if other, ok := claimed[j.ID]; ok {
+					t.Errorf("job %d claimed by %s and %s", j.ID, other, worker)
+				}
+				claimed[j.ID] = worker
+				mu.Unlock()
+				if j.Status != StatusRunning || j.LockedBy != worker || j.Attempts != 1 {
+					t.Errorf("claimed job: got %+v", j)
+				}
+				if err := repo.complete(ctx, j.ID); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if len(claimed) != n {
+		t.Fatalf("claimed %d of %d jobs", len(claimed), n)
+	}
+	stats, err := repo.stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats[StatusDone] != n {
+		t.Fatalf("stats: got %v, want %d done", stats, n)
+	}
+}
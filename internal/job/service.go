@@ -0,0 +1,121 @@
+package job
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+
+	"github.com/Masterminds/squirrel"
+)
+
+type Service struct {
+	repo *Repo
+}
+
+func NewService(repo *Repo) *Service {
+	return &Service{repo: repo}
+}
+
+// WithTx returns a copy of the service whose jobs are enqueued in tx, so
+// they only exist if the work that asked for them commits.
+func (s Service) WithTx(tx *sql.Tx) *Service {
+	return &Service{repo: s.repo.WithTx(tx)}
+}
+
+// Enqueue stores a job of kind with payload encoded as JSON, it runs as
+// soon as a worker of its queue is free unless an option delays it.
+func (s Service) Enqueue(ctx context.Context, kind string, payload any, opts ...Option) (id int64, err error) {
+	defer func() {
+		if err != nil {
+			logger.FromContext(ctx).Error("job.Enqueue", "kind", kind, "err", err)
+		}
+	}()
+	if kind == "" {
+		return 0, apperror.ErrBadRequest
+	}
+	j := &Job{
+		Queue:       DefaultQueue,
+		Kind:        kind,
+		MaxAttempts: DefaultMaxAttempts,
+		RunAt:       time.Now(),
+	}
+	if j.Payload, err = json.Marshal(payload); err != nil {
+		return 0, err
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	if j.MaxAttempts < 1 {
+		j.MaxAttempts = 1
+	}
+	if id, err = s.repo.insert(ctx, j); err != nil {
+		return 0, err
+	}
+	logger.FromContext(ctx).Debug("job enqueued", "job_id", id, "kind", kind, "queue", j.Queue)
+	return id, nil
+}
+
+func (s Service) ListJobs(ctx context.Context, filter FilterJob) (res []Job, err error) {
+	defer func() {
+		if err != nil {
+			logger.FromContext(ctx).Error("job.ListJobs", "err", err)
+		}
+	}()
+	return s.repo.list(ctx, filter)
+}
+
+func (s Service) GetJob(ctx context.Context, id int64) (res *Job, err error) {
+	res, err = s.repo.get(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperror.ErrStatusNotFound
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("job.GetJob", "err", err)
+	}
+	return res, err
+}
+
+func (s Service) Stats(ctx context.Context) (res Stats, err error) {
+	defer func() {
+		if err != nil {
+			logger.FromContext(ctx).Error("job.Stats", "err", err)
+		}
+	}()
+	return s.repo.stats(ctx)
+}
+
+// RetryJob puts a dead job back in its queue with all its attempts.
+func (s Service) RetryJob(ctx context.Context, id int64) error {
+	err := s.repo.retry(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperror.ErrStatusNotAllow
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("job.RetryJob", "err", err)
+		return err
+	}
+	logger.FromContext(ctx).Info("job retried", "job_id", id)
+	return nil
+}
+
+// DeleteJob removes a job that is not running.
+func (s Service) DeleteJob(ctx context.Context, id int64) error {
+	n, err := s.repo.delete(ctx, squirrel.And{
+		squirrel.Eq{"id": id},
+		squirrel.NotEq{"status": StatusRunning},
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("job.DeleteJob", "err", err)
+		return err
+	}
+	if n == 0 {
+		return apperror.ErrStatusNotAllow
+	}
+	logger.FromContext(ctx).Info("job deleted", "job_id", id)
+	return nil
+}
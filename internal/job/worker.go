@@ -0,0 +1,318 @@
+package job
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/metrics"
+	"github.com/anousonefs/golang-htmx-template/internal/tracing"
+
+	"github.com/Masterminds/squirrel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Handler runs one job. Returning an error retries it later with backoff,
+// wrap the error with Permanent to give up at once.
+type Handler func(ctx context.Context, j *Job) error
+
+type WorkerConfig struct {
+	Queues       []string
+	Concurrency  int
+	PollInterval time.Duration
+	// LockTimeout bounds a run, a job locked for longer is considered
+	// abandoned and given to another worker.
+	LockTimeout time.Duration
+	// Retention is how long done jobs are kept, 0 keeps them.
+	Retention time.Duration
+}
+
+// maintenanceInterval is how often schedules are fired and stuck jobs
+// reclaimed, cron specs have a minute precision.
+const maintenanceInterval = 10 * time.Second
+
+// saveTimeout bounds the saving of a job result, which runs on its own
+// context so a job that hit its deadline is still recorded.
+const saveTimeout = 10 * time.Second
+
+type Worker struct {
+	repo      *Repo
+	cfg       WorkerConfig
+	id        string
+	log       *slog.Logger
+	handlers  map[string]Handler
+	schedules []Schedule
+	crons     map[string]Cron
+	done      chan struct{}
+}
+
+func NewWorker(repo *Repo, cfg WorkerConfig, log *slog.Logger) *Worker {
+	if len(cfg.Queues) == 0 {
+		cfg.Queues = []string{DefaultQueue}
+	}
+	if cfg.Concurrency < 1 {
+		cfg.Concurrency = 1
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.LockTimeout <= 0 {
+		cfg.LockTimeout = 15 * time.Minute
+	}
+	host, _ := os.Hostname()
+	id := fmt.Sprintf("%s:%d", host, os.Getpid())
+	return &Worker{
+		repo:     repo,
+		cfg:      cfg,
+		id:       id,
+		log:      log.With("worker", id),
+		handlers: map[string]Handler{},
+		crons:    map[string]Cron{},
+		done:     make(chan struct{}),
+	}
+}
+
+// Handle registers h for the jobs of kind. Kinds without a handler stay
+// queued for a process that has one.
+func (w *Worker) Handle(kind string, h Handler) {
+	w.handlers[kind] = h
+}
+
+// Register is Handle with the payload decoded into T, a payload that does
+// not decode fails the job permanently.
+func Register[T any](w *Worker, kind string, fn func(ctx context.Context, payload T) error) {
+	w.Handle(kind, func(ctx context.Context, j *Job) error {
+		var payload T
+		if err := json.Unmarshal(j.Payload, &payload); err != nil {
+			return Permanent(fmt.Errorf("decode payload: %w", err))
+		}
+		return fn(ctx, payload)
+	})
+}
+
+// Schedule enqueues a job of kind each time spec comes due, see Schedule.
+// The schedules live in the database so only one of the workers fires each
+// run.
+func (w *Worker) Schedule(name, spec, kind string, payload any) error {
+	c, err := ParseCron(spec)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	w.crons[name] = c
+	w.schedules = append(w.schedules, Schedule{Name: name, Spec: spec, Queue: w.cfg.Queues[0], Kind: kind, Payload: b})
+	return nil
+}
+
+// Kinds lists the registered job kinds.
+func (w *Worker) Kinds() []string {
+	kinds := make([]string, 0, len(w.handlers))
+	for k := range w.handlers {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Run works the queues until ctx is done, then waits for the running jobs.
+// They keep their own deadline rather than being cancelled with ctx.
+func (w *Worker) Run(ctx context.Context) error {
+	defer close(w.done)
+	if err := w.saveSchedules(ctx); err != nil {
+		return err
+	}
+	w.log.Info("worker started", "queues", w.cfg.Queues, "concurrency", w.cfg.Concurrency, "kinds", w.Kinds())
+
+	var wg sync.WaitGroup
+	for i := 0; i < w.cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.maintain(ctx)
+	}()
+	wg.Wait()
+	return nil
+}
+
+// Wait blocks until Run has returned or ctx is done, it is the stop hook of
+// the worker.
+func (w *Worker) Wait(ctx context.Context) error {
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *Worker) saveSchedules(ctx context.Context) error {
+	names := make([]string, 0, len(w.schedules))
+	for _, s := range w.schedules {
+		if err := w.repo.saveSchedule(ctx, s, w.crons[s.Name].Next(time.Now())); err != nil {
+			return fmt.Errorf("schedule %s: %w", s.Name, err)
+		}
+		names = append(names, s.Name)
+	}
+	if n, err := w.repo.deleteSchedules(ctx, names); err != nil {
+		return err
+	} else if n > 0 {
+		w.log.Info("stale job schedules deleted", "count", n)
+	}
+	return nil
+}
+
+func (w *Worker) loop(ctx context.Context) {
+	kinds := w.Kinds()
+	if len(kinds) == 0 {
+		<-ctx.Done()
+		return
+	}
+	for ctx.Err() == nil {
+		j, err := w.repo.claim(ctx, w.cfg.Queues, kinds, w.id)
+		if err == nil {
+			w.run(j)
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) && ctx.Err() == nil {
+			w.log.Error("claim job", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(w.cfg.PollInterval):
+		}
+	}
+}
+
+func (w *Worker) run(j *Job) {
+	// the job outlives a shutdown, its own deadline stops it.
+	ctx, cancel := context.WithTimeout(context.Background(), w.cfg.LockTimeout)
+	defer cancel()
+	log := w.log.With("job_id", j.ID, "kind", j.Kind, "attempt", j.Attempts)
+	ctx = logger.WithContext(ctx, log)
+	ctx, span := tracing.Start(ctx, "job "+j.Kind,
+		attribute.Int64("job.id", j.ID),
+		attribute.String("job.queue", j.Queue),
+		attribute.Int("job.attempt", j.Attempts),
+	)
+
+	start := time.Now()
+	err := w.call(ctx, j)
+	tracing.End(span, err)
+
+	save, cancelSave := context.WithTimeout(logger.WithContext(context.Background(), log), saveTimeout)
+	defer cancelSave()
+	var result string
+	switch {
+	case err == nil:
+		result = "done"
+		err = w.repo.complete(save, j.ID)
+		log.Debug("job done", "took_ms", time.Since(start).Milliseconds())
+	case isPermanent(err) || j.Attempts >= j.MaxAttempts:
+		result = "dead"
+		log.Error("job failed, giving up", "err", err)
+		err = w.repo.bury(save, j.ID, err.Error())
+	default:
+		result = "retry"
+		next := time.Now().Add(backoff(j.Attempts))
+		log.Warn("job failed, retrying", "err", err, "retry_at", next)
+		err = w.repo.fail(save, j.ID, err.Error(), next)
+	}
+	metrics.Job(j.Kind, result, time.Since(start))
+	if err != nil {
+		log.Error("save job result", "err", err)
+	}
+}
+
+// call runs the handler, turning a panic into a permanent failure.
+func (w *Worker) call(ctx context.Context, j *Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.FromContext(ctx).Error("job panicked", "panic", p, "stack", string(debug.Stack()))
+			err = Permanent(fmt.Errorf("panic: %v", p))
+		}
+	}()
+	return w.handlers[j.Kind](ctx, j)
+}
+
+// backoff is 10s doubled at each attempt up to an hour, with up to 20%
+// jitter so failed jobs do not retry in lockstep.
+func backoff(attempt int) time.Duration {
+	d := time.Hour
+	if attempt < 10 {
+		d = min(10*time.Second<<max(attempt-1, 0), time.Hour)
+	}
+	return d + time.Duration(rand.Int63n(int64(d/5)+1))
+}
+
+func (w *Worker) maintain(ctx context.Context) {
+	t := time.NewTicker(maintenanceInterval)
+	defer t.Stop()
+	for {
+		w.maintenance(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (w *Worker) maintenance(ctx context.Context) {
+	if n, err := w.repo.fireSchedules(ctx, func(s Schedule, t time.Time) time.Time {
+		c, ok := w.crons[s.Name]
+		if !ok {
+			// registered by another version of the app.
+			var err error
+			if c, err = ParseCron(s.Spec); err != nil {
+				return t.Add(24 * time.Hour)
+			}
+		}
+		return c.Next(t)
+	}); err != nil {
+		if ctx.Err() == nil {
+			w.log.Error("fire job schedules", "err", err)
+		}
+	} else if n > 0 {
+		w.log.Debug("job schedules fired", "count", n)
+	}
+
+	if n, err := w.repo.reclaim(ctx, time.Now().Add(-w.cfg.LockTimeout-time.Minute)); err != nil {
+		if ctx.Err() == nil {
+			w.log.Error("reclaim jobs", "err", err)
+		}
+	} else if n > 0 {
+		w.log.Warn("abandoned jobs reclaimed", "count", n)
+	}
+
+	if w.cfg.Retention > 0 {
+		if n, err := w.repo.delete(ctx, squirrel.And{
+			squirrel.Eq{"status": StatusDone},
+			squirrel.Lt{"finished_at": time.Now().UTC().Add(-w.cfg.Retention)},
+		}); err != nil {
+			if ctx.Err() == nil {
+				w.log.Error("delete finished jobs", "err", err)
+			}
+		} else if n > 0 {
+			w.log.Debug("finished jobs deleted", "count", n)
+		}
+	}
+}
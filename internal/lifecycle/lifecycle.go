@@ -0,0 +1,145 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultStopTimeout is the deadline of a Stop hook without its own.
+const DefaultStopTimeout = 10 * time.Second
+
+// Component is one part of the process. Every hook is optional:
+//
+//   - Start prepares the component and must return once it is ready,
+//     components are started in the order they were added.
+//   - Run is the long running part (a server, a worker loop). It runs in its
+//     own goroutine until its context is cancelled, an error other than
+//     context.Canceled stops the whole process.
+//   - Stop releases the component within StopTimeout, components are
+//     stopped in reverse order so a dependency outlives its users.
+type Component struct {
+	Name        string
+	Start       func(ctx context.Context) error
+	Run         func(ctx context.Context) error
+	Stop        func(ctx context.Context) error
+	StopTimeout time.Duration
+}
+
+type Manager struct {
+	components []Component
+	log        *slog.Logger
+}
+
+func New(log *slog.Logger) *Manager {
+	return &Manager{log: log}
+}
+
+func (m *Manager) Add(c Component) {
+	m.components = append(m.components, c)
+}
+
+// Run starts every component and blocks until ctx is done or a component
+// fails, then stops the started ones. It returns the first fatal error
+// joined with the errors of the stop hooks.
+func (m *Manager) Run(ctx context.Context) error {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errCh := make(chan error, len(m.components))
+	var wg sync.WaitGroup
+	started := 0
+	var err error
+	for _, c := range m.components {
+		if c.Start != nil {
+			if err = c.Start(runCtx); err != nil {
+				err = fmt.Errorf("start %s: %w", c.Name, err)
+				break
+			}
+		}
+		started++
+		if c.Run != nil {
+			wg.Add(1)
+			go func(c Component) {
+				defer wg.Done()
+				if err := c.Run(runCtx); err != nil && !errors.Is(err, context.Canceled) {
+					errCh <- fmt.Errorf("%s: %w", c.Name, err)
+				}
+			}(c)
+		}
+		m.log.Debug("component started", "component", c.Name)
+	}
+
+	if err == nil {
+		select {
+		case <-ctx.Done():
+			m.log.Info("shutting down")
+		case err = <-errCh:
+			m.log.Error("component failed, shutting down", "err", err)
+		}
+	}
+	cancel()
+
+	stopErr := m.stop(m.components[:started])
+	wait(&wg, DefaultStopTimeout)
+	return errors.Join(err, stopErr)
+}
+
+func (m *Manager) stop(components []Component) error {
+	var errs []error
+	for i := len(components) - 1; i >= 0; i-- {
+		c := components[i]
+		if c.Stop == nil {
+			continue
+		}
+		timeout := c.StopTimeout
+		if timeout == 0 {
+			timeout = DefaultStopTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		start := time.Now()
+		err := c.Stop(ctx)
+		cancel()
+		if err != nil {
+			m.log.Error("component stop failed", "component", c.Name, "err", err)
+			errs = append(errs, fmt.Errorf("stop %s: %w", c.Name, err))
+			continue
+		}
+		m.log.Debug("component stopped", "component", c.Name, "took_ms", time.Since(start).Milliseconds())
+	}
+	return errors.Join(errs...)
+}
+
+// wait gives the Run goroutines a last chance to return after their stop
+// hooks, a hung one is left behind rather than blocking the exit.
+func wait(wg *sync.WaitGroup, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+// HTTPServer adapts a server with the ListenAndServe/Shutdown pair, such
+// as http.Server or echo.Echo, the closed error of a normal shutdown is
+// not a failure.
+func HTTPServer(name string, serve func() error, shutdown func(context.Context) error) Component {
+	return Component{
+		Name: name,
+		Run: func(context.Context) error {
+			if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+		Stop: shutdown,
+	}
+}
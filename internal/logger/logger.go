@@ -0,0 +1,169 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+
+	// HeaderRequestID is read from the request when a proxy already set it
+	// and always written back to the response.
+	HeaderRequestID = echo.HeaderXRequestID
+
+	redacted = "[REDACTED]"
+)
+
+// sensitiveKeys are attribute keys whose value never reaches the output,
+// matched case-insensitively after removing "_" and "-".
+var sensitiveKeys = map[string]bool{
+	"password":        true,
+	"secret":          true,
+	"secretkey":       true,
+	"secretaccesskey": true,
+	"token":           true,
+	"accesstoken":     true,
+	"refreshtoken":    true,
+	"authorization":   true,
+	"cookie":          true,
+	"apikey":          true,
+}
+
+func IsSensitive(key string) bool {
+	k := strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+	return sensitiveKeys[k]
+}
+
+// New returns a JSON logger for production and a text one for development,
+// both with sensitive attributes redacted.
+func New(w io.Writer, format string, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	}
+	if format == FormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+func redact(_ []string, a slog.Attr) slog.Attr {
+	if IsSensitive(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
+
+// ParseLevel accepts debug, info, warn and error, anything else is info.
+func ParseLevel(s string) slog.Level {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo
+	}
+	return l
+}
+
+type ctxKey int
+
+const (
+	loggerKey ctxKey = iota
+	requestIDKey
+)
+
+func WithContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// FromContext returns the request logger, or slog.Default outside a request.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// With adds attributes to the logger of ctx for the rest of the request.
+func With(ctx context.Context, args ...any) context.Context {
+	return WithContext(ctx, FromContext(ctx).With(args...))
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithRequestID carries the id of the request that caused some background
+// work, so its logs and records can be traced back to it.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return With(context.WithValue(ctx, requestIDKey, id), "request_id", id)
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// Middleware assigns the request ID, stores a logger enriched with it in the
+// request context and writes one access line when the request is done.
+// Middlewares further down add their own fields with With, e.g. the user
+// once the token has been checked.
+func Middleware(base *slog.Logger, skipper func(echo.Context) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			id := req.Header.Get(HeaderRequestID)
+			if id == "" || len(id) > 128 {
+				id = newRequestID()
+			}
+			c.Response().Header().Set(HeaderRequestID, id)
+
+			l := base.With(
+				"request_id", id,
+				"method", req.Method,
+				"route", c.Path(),
+			)
+			ctx := context.WithValue(req.Context(), requestIDKey, id)
+			c.SetRequest(req.WithContext(WithContext(ctx, l)))
+
+			start := time.Now()
+			err := next(c)
+			if err != nil {
+				// let the error handler write the response so the status is known.
+				c.Error(err)
+			}
+			if skipper != nil && skipper(c) {
+				return nil
+			}
+			status := c.Response().Status
+			level := slog.LevelInfo
+			switch {
+			case status >= 500:
+				level = slog.LevelError
+			case status >= 400:
+				level = slog.LevelWarn
+			}
+			FromContext(c.Request().Context()).Log(c.Request().Context(), level, "request",
+				"status", status,
+				"latency_ms", time.Since(start).Milliseconds(),
+				"remote_ip", c.RealIP(),
+				"bytes_out", c.Response().Size,
+			)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "htmx"
+
+// Registry holds every metric of the app, it is served by Handler. Labels
+// only take values from fixed sets (route patterns, status codes, known
+// providers) so cardinality stays bounded.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route pattern, method and status code.",
+	}, []string{"method", "route", "code"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route pattern and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Duration of repository methods.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"repo", "method"})
+
+	authAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_login_total",
+		Help:      "Login attempts by provider and result.",
+	}, []string{"provider", "result"})
+
+	authRefresh = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_refresh_total",
+		Help:      "Token refreshes by result.",
+	}, []string{"result"})
+
+	authzDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "authz_decisions_total",
+		Help:      "Casbin enforce decisions by resource, action and result.",
+	}, []string{"resource", "action", "result"})
+
+	uploadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_bytes_total",
+		Help:      "Bytes uploaded to the blob store by kind.",
+	}, []string{"kind"})
+
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ratelimit_rejections_total",
+		Help:      "Requests rejected by the rate limiter by policy.",
+	}, []string{"policy"})
+
+	jobsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_processed_total",
+		Help:      "Background jobs run by kind and result (done, retry, dead).",
+	}, []string{"kind", "result"})
+
+	jobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_duration_seconds",
+		Help:      "Background job run time by kind.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"kind"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		queryDuration,
+		authAttempts,
+		authRefresh,
+		authzDecisions,
+		uploadBytes,
+		rateLimited,
+		jobsProcessed,
+		jobDuration,
+	)
+}
+
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+	ResultAllow   = "allow"
+	ResultDeny    = "deny"
+
+	ProviderPassword = "password"
+)
+
+// providers bounds the provider label, the value comes from the query
+// string on the oauth routes.
+var providers = map[string]bool{
+	ProviderPassword: true,
+	"facebook":       true,
+	"discord":        true,
+	"google":         true,
+}
+
+// RegisterDB exports the pool stats of db (open, in use, idle, waits).
+func RegisterDB(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// ObserveQuery times a repository method:
+//
+//	defer metrics.ObserveQuery("user", "getUser")()
+func ObserveQuery(repo, method string) func() {
+	start := time.Now()
+	return func() {
+		queryDuration.WithLabelValues(repo, method).Observe(time.Since(start).Seconds())
+	}
+}
+
+func Login(provider, result string) {
+	if !providers[provider] {
+		provider = "other"
+	}
+	authAttempts.WithLabelValues(provider, result).Inc()
+}
+
+func Refresh(result string) {
+	authRefresh.WithLabelValues(result).Inc()
+}
+
+func Authz(resource, action string, allowed bool) {
+	result := ResultDeny
+	if allowed {
+		result = ResultAllow
+	}
+	if resource == "" {
+		resource = "unknown"
+	}
+	authzDecisions.WithLabelValues(resource, action, result).Inc()
+}
+
+func Upload(kind string, bytes int64) {
+	uploadBytes.WithLabelValues(kind).Add(float64(bytes))
+}
+
+func RateLimited(policy string) {
+	rateLimited.WithLabelValues(policy).Inc()
+}
+
+// Job records one run of a job, kind is a registered handler so the label
+// stays bounded.
+func Job(kind, result string, d time.Duration) {
+	jobsProcessed.WithLabelValues(kind, result).Inc()
+	jobDuration.WithLabelValues(kind).Observe(d.Seconds())
+}
+
+// Middleware records request count and latency by route pattern, requests
+// that did not match a route share one label.
+func Middleware(skipper func(echo.Context) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skipper != nil && skipper(c) {
+				return next(c)
+			}
+			start := time.Now()
+			err := next(c)
+			if err != nil {
+				c.Error(err)
+			}
+			route := c.Path()
+			if route == "" || route == "/*" {
+				route = "unmatched"
+			}
+			method := c.Request().Method
+			httpRequests.WithLabelValues(method, route, strconv.Itoa(c.Response().Status)).Inc()
+			httpDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+			return nil
+		}
+	}
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// RequireToken protects the metrics handler with a bearer token when it is
+// served on the public listener.
+func RequireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(got), []byte("Bearer "+token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
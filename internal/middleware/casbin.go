@@ -1,31 +1,43 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"net/http"
+	"log/slog"
 	"strings"
+	"sync/atomic"
 
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/metrics"
+	"github.com/anousonefs/golang-htmx-template/internal/tracing"
 	"github.com/anousonefs/golang-htmx-template/internal/utils"
 
 	"github.com/casbin/casbin/v2"
 	"github.com/casbin/casbin/v2/model"
-	casbinpgadapter "github.com/cychiuae/casbin-pg-adapter"
+	"github.com/casbin/casbin/v2/persist"
 	"github.com/labstack/echo/v4"
-	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Config struct {
 	ModelFilePath string
-	PolicyAdapter *casbinpgadapter.Adapter
+	PolicyAdapter persist.Adapter
 	Enforcer      *casbin.Enforcer
 	Lookup        func(echo.Context) string
-	Unauthorized  echo.HandlerFunc
-	Forbidden     echo.HandlerFunc
+	// Departments returns the department and the ids of the departments
+	// under it, the subtree a ScopeDepartment rule reaches.
+	Departments  func(ctx context.Context, departmentID string) ([]string, error)
+	Unauthorized echo.HandlerFunc
+	Forbidden    echo.HandlerFunc
 }
 
 type CasbinMiddleware struct {
 	config Config
+	// enforcer starts as config.Enforcer, ReloadEnforcer swaps it while the
+	// requests read it.
+	enforcer atomic.Pointer[casbin.Enforcer]
 }
 
 func New(config ...Config) *CasbinMiddleware {
@@ -38,9 +50,7 @@ func New(config ...Config) *CasbinMiddleware {
 		if cfg.ModelFilePath == "" {
 			cfg.ModelFilePath = "./policy.conf"
 		}
-		m, _ := model.NewModelFromString(cfg.ModelFilePath)
-
-		enforcer, err := casbin.NewEnforcer(m, cfg.PolicyAdapter)
+		enforcer, err := NewEnforcer(cfg.ModelFilePath, cfg.PolicyAdapter)
 		if err != nil {
 			log.Fatalf("echo: Casbin middleware error -> %v", err)
 		}
@@ -52,21 +62,100 @@ func New(config ...Config) *CasbinMiddleware {
 		cfg.Lookup = func(c echo.Context) string { return "" }
 	}
 
+	if cfg.Departments == nil {
+		cfg.Departments = func(_ context.Context, id string) ([]string, error) { return []string{id}, nil }
+	}
+
 	if cfg.Unauthorized == nil {
 		cfg.Unauthorized = func(c echo.Context) error {
-			return c.JSON(http.StatusUnauthorized, echo.Map{})
+			return apperror.StatusUnauthenticated.Err()
 		}
 	}
 
 	if cfg.Forbidden == nil {
 		cfg.Forbidden = func(c echo.Context) error {
-			return c.JSON(http.StatusForbidden, echo.Map{})
+			return apperror.StatusPermissionDenied.Err()
 		}
 	}
 
-	return &CasbinMiddleware{
+	cm := &CasbinMiddleware{
 		config: cfg,
 	}
+	cm.enforcer.Store(cfg.Enforcer)
+	return cm
+}
+
+// NewEnforcer loads the policy of adapter with the model text, registering
+// the functions its matcher uses.
+func NewEnforcer(modelText string, adapter persist.Adapter) (*casbin.Enforcer, error) {
+	m, err := model.NewModelFromString(modelText)
+	if err != nil {
+		return nil, err
+	}
+	e, err := casbin.NewEnforcer(m, adapter)
+	if err != nil {
+		return nil, err
+	}
+	e.AddFunction("within", within)
+	return e, nil
+}
+
+// within(r.owner, r.departments) is true when the department owning the
+// record is one of the subject's departments.
+func within(args ...interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return false, fmt.Errorf("within: expected 2 arguments, got %d", len(args))
+	}
+	owner, _ := args[0].(string)
+	departments, _ := args[1].([]string)
+	return owner != "" && utils.ContainsString(departments, owner), nil
+}
+
+// Authorize lets the request through when the role of the user may do
+// action on resource. A ScopeDepartment rule limits it to the records of the
+// user's department subtree, the Scope put in the context tells the
+// repositories which ones.
+func (cm *CasbinMiddleware) Authorize(resource, action string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			sub := cm.config.Lookup(c)
+			if len(sub) == 0 {
+				return cm.config.Unauthorized(c)
+			}
+			ctx := c.Request().Context()
+			scope, ok, err := cm.Scope(ctx, sub, UserClaimFromContext(ctx).DepartmentID, resource, action)
+			if err != nil {
+				return err
+			}
+			metrics.Authz(resource, action, ok)
+			if !ok {
+				logger.FromContext(ctx).Debug("policy not matched", "sub", sub, "resource", resource, "action", action)
+				return cm.config.Forbidden(c)
+			}
+			c.SetRequest(c.Request().WithContext(WithScope(ctx, scope)))
+			return next(c)
+		}
+	}
+}
+
+// Scope tells what sub, a member of departmentID, may reach doing action on
+// resource, ok is false when it may not do it at all.
+func (cm *CasbinMiddleware) Scope(ctx context.Context, sub, departmentID, resource, action string) (s Scope, ok bool, err error) {
+	// no owner only matches the ScopeAll rules.
+	if ok, err := cm.enforce(ctx, sub, resource, action, "", nil); err != nil || ok {
+		return Scope{All: ok}, ok, err
+	}
+	if departmentID == "" {
+		return Scope{}, false, nil
+	}
+	departments, err := cm.config.Departments(ctx, departmentID)
+	if err != nil {
+		return Scope{}, false, err
+	}
+	if ok, err = cm.enforce(ctx, sub, resource, action, departmentID, departments); err != nil || !ok {
+		return Scope{}, false, err
+	}
+	return Scope{Departments: departments}, true, nil
 }
 
 type validationRule int
@@ -106,7 +195,6 @@ type Options struct {
 func (cm *CasbinMiddleware) RequiresPermissions(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) (err error) {
 		url := c.Request().URL.Path
-		fmt.Printf("=> url: %+v\n", url)
 		switch {
 		case c.Param("name") != "":
 			url = replaceParam(url, c.Param("name"))
@@ -130,42 +218,52 @@ func (cm *CasbinMiddleware) RequiresPermissions(next echo.HandlerFunc) echo.Hand
 		if len(sub) == 0 {
 			return cm.config.Unauthorized(c)
 		}
-		vals := append([]string{sub}, resource, action)
-		fmt.Printf("sub: %v\n", sub)
-		fmt.Printf("=> vals: %v\n", vals)
-		if ok, err := cm.config.Enforcer.Enforce(utils.StringSliceToInterfaceSlice(vals)...); err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{})
-		} else if !ok {
-			println("start")
+		log := logger.FromContext(c.Request().Context())
+		ok, err := cm.enforce(c.Request().Context(), sub, resource, action, "", nil)
+		if err != nil {
+			return err
+		}
+		metrics.Authz(resource, action, ok)
+		if !ok {
 			// todo: create another endpoint for branch sorting
-			fmt.Printf("u: %v, query: %v\n", url, c.QueryParam(("isSorting")))
 			if url == "/api/v1/branches" && c.QueryParam("isSorting") == "true" {
-				println("resource branch sorting")
-				vals3 := append([]string{sub}, "branchSorting", "list")
-				if ok, err := cm.config.Enforcer.Enforce(utils.StringSliceToInterfaceSlice(vals3)...); err != nil {
-					return c.JSON(http.StatusInternalServerError, echo.Map{})
+				if ok, err := cm.enforce(c.Request().Context(), sub, "branchSorting", "list", "", nil); err != nil {
+					return err
 				} else if !ok {
 					return cm.config.Forbidden(c)
 				}
 				return next(c)
 			}
 			if (resource == "vendor" && action == "list") || (resource == "branch" && action == "list") || (resource == "boxType" && action == "list") || (resource == "boxSize" && action == "list") {
-				println("=> check permission register box!")
-				vals2 := append([]string{sub}, "registerBox", "create")
-				if ok, err := cm.config.Enforcer.Enforce(utils.StringSliceToInterfaceSlice(vals2)...); err != nil {
-					return c.JSON(http.StatusInternalServerError, echo.Map{})
+				if ok, err := cm.enforce(c.Request().Context(), sub, "registerBox", "create", "", nil); err != nil {
+					return err
 				} else if !ok {
 					return cm.config.Forbidden(c)
 				}
 				return next(c)
 			}
-			println("=> policy not match\n")
+			log.Debug("policy not matched", "sub", sub, "resource", resource, "action", action)
 			return cm.config.Forbidden(c)
 		}
 		return next(c)
 	}
 }
 
+// enforce runs Enforce inside a span carrying the request and the decision.
+// owner is the department of the record and departments the subtree of
+// sub, both empty when no particular record is involved.
+func (cm *CasbinMiddleware) enforce(ctx context.Context, sub, obj, act, owner string, departments []string) (ok bool, err error) {
+	_, span := tracing.Start(ctx, "casbin.Enforce",
+		attribute.StringSlice("casbin.request", []string{sub, obj, act, owner}),
+		attribute.Int("casbin.departments", len(departments)),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Bool("casbin.allowed", ok))
+		tracing.End(span, err)
+	}()
+	return cm.enforcer.Load().Enforce(sub, obj, act, owner, departments)
+}
+
 // RoutePermission tries to find the current subject and determine if the
 // subject has the required permissions according to predefined Casbin policies.
 // This method uses http Path and Method as object and action.
@@ -177,9 +275,13 @@ func (cm *CasbinMiddleware) RoutePermission(next echo.HandlerFunc) echo.HandlerF
 			return cm.config.Unauthorized(c)
 		}
 
-		if ok, err := cm.config.Enforcer.Enforce(sub, c.Request().URL.Path, c.Request().Method); err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{})
-		} else if !ok {
+		ok, err := cm.enforce(c.Request().Context(), sub, c.Request().URL.Path, c.Request().Method, "", nil)
+		if err != nil {
+			return err
+		}
+		// the route pattern keeps the label bounded, the path has ids in it.
+		metrics.Authz(c.Path(), c.Request().Method, ok)
+		if !ok {
 			return cm.config.Forbidden(c)
 		}
 
@@ -208,9 +310,9 @@ func (cm *CasbinMiddleware) RequiresRoles(next echo.HandlerFunc) echo.HandlerFun
 			return cm.config.Unauthorized(c)
 		}
 
-		userRoles, err := cm.config.Enforcer.GetRolesForUser(sub)
+		userRoles, err := cm.enforcer.Load().GetRolesForUser(sub)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{})
+			return err
 		}
 
 		if options.ValidationRule == matchAll {
@@ -233,17 +335,20 @@ func (cm *CasbinMiddleware) RequiresRoles(next echo.HandlerFunc) echo.HandlerFun
 	}
 }
 
-// ReloadEnforcer ...
-func (cm *CasbinMiddleware) ReloadEnforcer(modelFilePath string, adapter *casbinpgadapter.Adapter) {
-	mc, _ := model.NewModelFromString(modelFilePath)
-	enforcer, err := casbin.NewEnforcer(mc, adapter)
+// ReloadEnforcer swaps in an enforcer loading the current policy, the
+// requests running meanwhile use either one. On error the previous one
+// stays.
+func (cm *CasbinMiddleware) ReloadEnforcer(modelFilePath string, adapter persist.Adapter) {
+	enforcer, err := NewEnforcer(modelFilePath, adapter)
 	if err != nil {
-		logrus.Errorf("ReloadEnforcer.NewEnforcer():%+v\n", err)
+		slog.Error("reload casbin enforcer", "err", err)
+		return
 	}
 	if err := enforcer.LoadPolicy(); err != nil {
-		logrus.Errorf("ReloadEnforcer.LoadPolicy():%+v\n", err)
+		slog.Error("reload casbin policy", "err", err)
+		return
 	}
-	cm.config.Enforcer = enforcer
+	cm.enforcer.Store(enforcer)
 }
 
 func replaceParam(url string, param string) string {
@@ -2,7 +2,8 @@ package middleware
 
 import (
 	"context"
-	"fmt"
+
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
 
 	"github.com/labstack/echo/v4"
 	"github.com/o1egl/paseto/v2"
@@ -15,6 +16,7 @@ type UserClaim struct {
 	CountryCode  string `json:"countryCode"`
 	RoleID       string `json:"roleID"`
 	DepartmentID string `json:"departmentID"`
+	TenantID     string `json:"tenantID"`
 }
 
 type claimCtxKey int
@@ -43,15 +45,19 @@ func SetClaimsMiddleware() echo.MiddlewareFunc {
 			if !ok {
 				return next(c)
 			}
+			ctx := c.Request().Context()
 			var user UserClaim
 			if err := claims.Get("user", &user); err != nil {
-				fmt.Printf("claims.Get():%v\n", err)
+				logger.FromContext(ctx).Warn("token without user claim", "err", err)
 				return next(c)
 			}
-			ctx := c.Request().Context()
 			ctx = context.WithValue(ctx, userClaimKey, user)
+			ctx = logger.With(ctx,
+				"user_id", user.ID,
+				"role_id", user.RoleID,
+				"tenant_id", user.TenantID,
+			)
 			c.SetRequest(c.Request().WithContext(ctx))
-			fmt.Print("c.SetRequest()")
 			return next(c)
 		}
 	}
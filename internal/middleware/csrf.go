@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+const (
+	// CSRFHeader carries the token on htmx requests, see CSRFHeaders.
+	CSRFHeader = "X-CSRF-Token"
+	// CSRFField is the form field read when the header is missing.
+	CSRFField = "_csrf"
+
+	csrfCookie = "_csrf"
+	csrfKey    = "csrf"
+)
+
+var CSRFTokenKey key = "csrf"
+
+// CSRF checks a double-submit token on every unsafe request made with the
+// browser cookies. /api calls without the access_token cookie are exempt
+// since nothing is sent on the user's behalf there. An Authorization header
+// exempts nothing: Auth reads the cookie only, a request carrying both would
+// still act as the cookie's user.
+func CSRF(cfg config.Config) []echo.MiddlewareFunc {
+	return []echo.MiddlewareFunc{
+		middleware.CSRFWithConfig(middleware.CSRFConfig{
+			Skipper:        csrfSkipper,
+			TokenLookup:    "header:" + CSRFHeader + ",form:" + CSRFField,
+			ContextKey:     csrfKey,
+			CookieName:     csrfCookie,
+			CookiePath:     "/",
+			CookieDomain:   cfg.CookieDomain(),
+			CookieHTTPOnly: true,
+			CookieSecure:   cfg.CookieSecure(),
+			CookieSameSite: http.SameSiteStrictMode,
+			ErrorHandler: func(err error, c echo.Context) error {
+				return apperror.StatusInvalidCSRFToken.Err()
+			},
+		}),
+		func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(c echo.Context) error {
+				if token, ok := c.Get(csrfKey).(string); ok {
+					ctx := context.WithValue(c.Request().Context(), CSRFTokenKey, token)
+					c.SetRequest(c.Request().WithContext(ctx))
+				}
+				return next(c)
+			}
+		},
+	}
+}
+
+func csrfSkipper(c echo.Context) bool {
+	req := c.Request()
+	if c.Path() == CSPReportPath {
+		return true
+	}
+	if strings.HasPrefix(req.URL.Path, "/api/") {
+		if _, err := req.Cookie("access_token"); err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// GetCSRFToken returns the token of the request for templ components, ""
+// when CSRF is not installed.
+func GetCSRFToken(ctx context.Context) string {
+	token, _ := ctx.Value(CSRFTokenKey).(string)
+	return token
+}
+
+// CSRFHeaders is the hx-headers value sending the token with every htmx
+// request of the page.
+func CSRFHeaders(ctx context.Context) string {
+	b, _ := json.Marshal(map[string]string{CSRFHeader: GetCSRFToken(ctx)})
+	return string(b)
+}
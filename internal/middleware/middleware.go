@@ -4,10 +4,10 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
-	"fmt"
-	"log"
 	"net/http"
 
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+
 	"github.com/labstack/echo/v4"
 )
 
@@ -20,7 +20,6 @@ type Nonces struct {
 	ResponseTargets string
 	Tw              string
 	InlineStyle     string
-	HtmxCSSHash     string
 }
 
 func generateRandomString(length int) string {
@@ -32,33 +31,6 @@ func generateRandomString(length int) string {
 	return hex.EncodeToString(bytes)
 }
 
-func CSPMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		// Create a new Nonces struct for every request
-		nonceSet := Nonces{
-			Htmx:            generateRandomString(16),
-			ResponseTargets: generateRandomString(16),
-			Tw:              generateRandomString(16),
-			InlineStyle:     generateRandomString(16),
-			HtmxCSSHash:     "sha256-pgn1TCGZX6O77zDvy0oTODMOxemn0oj0LeCnQTRj7Kg=",
-		}
-
-		// Set nonces in context
-		ctx := context.WithValue(c.Request().Context(), NonceKey, nonceSet)
-		c.SetRequest(c.Request().WithContext(ctx))
-
-		// Insert the nonces into the content security policy header
-		cspHeader := fmt.Sprintf("default-src 'self'; script-src 'nonce-%s' 'nonce-%s'; style-src 'nonce-%s' '%s';",
-			nonceSet.Htmx,
-			nonceSet.ResponseTargets,
-			nonceSet.Tw,
-			nonceSet.HtmxCSSHash)
-		c.Response().Header().Set("Content-Security-Policy", cspHeader)
-
-		return next(c)
-	}
-}
-
 func TextHTMLMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -66,20 +38,14 @@ func TextHTMLMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// get the Nonce from the context, it is a struct called Nonces,
-// so we can get the nonce we need by the key, i.e. HtmxNonce
+// GetNonces returns the nonces CSP stored for the request. A render outside
+// the CSP middleware gets empty nonces, the browser then blocks the inline
+// scripts and styles instead of the server going down.
 func GetNonces(ctx context.Context) Nonces {
-	nonceSet := ctx.Value(NonceKey)
-	if nonceSet == nil {
-		log.Fatal("error getting nonce set - is nil")
-	}
-
-	nonces, ok := nonceSet.(Nonces)
-
+	nonces, ok := ctx.Value(NonceKey).(Nonces)
 	if !ok {
-		log.Fatal("error getting nonce set - not ok")
+		logger.FromContext(ctx).Warn("nonces missing from context, is CSP installed?")
 	}
-
 	return nonces
 }
 
@@ -99,6 +65,10 @@ func GetTwNonce(ctx context.Context) string {
 	return nonceSet.Tw
 }
 
+func GetInlineStyleNonce(ctx context.Context) string {
+	return GetNonces(ctx).InlineStyle
+}
+
 func CacheControlMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		c.Response().Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
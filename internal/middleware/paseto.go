@@ -9,14 +9,13 @@ import (
 	"time"
 
 	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
 	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
-	"github.com/sirupsen/logrus"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/o1egl/paseto/v2"
-	"google.golang.org/protobuf/encoding/protojson"
 )
 
 const (
@@ -86,7 +85,7 @@ func CheckCookie(sesssionName string) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			if accessToken, refreshToken, err := GetCookies(c); err != nil || accessToken == "" || refreshToken == "" {
-				logrus.Errorf("GetCookies(): %v\n", err)
+				logger.FromContext(c.Request().Context()).Debug("auth cookies missing", "err", err)
 				return c.Redirect(http.StatusTemporaryRedirect, "/login")
 			}
 			return next(c)
@@ -140,13 +139,14 @@ func PASETOWithConfig(config PASETOConfig) echo.MiddlewareFunc {
 				}
 			}()
 
+			log := logger.FromContext(c.Request().Context())
 			auth, err := extractor(c)
 			if err != nil {
-				fmt.Printf("extractor error: %v\n", err)
+				log.Debug("paseto token missing", "err", err)
 				return c.Redirect(http.StatusTemporaryRedirect, "/login")
 			}
 			if !strings.HasPrefix(auth, "v2.local") {
-				fmt.Printf("not support token: %v\n", err)
+				log.Debug("paseto token unsupported")
 				return c.Redirect(http.StatusTemporaryRedirect, "/login")
 			}
 
@@ -164,14 +164,13 @@ func PASETOWithConfig(config PASETOConfig) echo.MiddlewareFunc {
 				err = claims.Validate(append(config.Validators, paseto.ValidAt(time.Now()))...)
 				if err == nil {
 					c.Set(config.ContextKey, claims)
-					println("set context key")
 					if config.SuccessHandler != nil {
 						config.SuccessHandler(c)
 					}
 					return next(c)
 				}
 			}
-			println("invalid or expired token")
+			log.Debug("paseto token invalid or expired", "err", err)
 			return c.Redirect(http.StatusTemporaryRedirect, "/login")
 		}
 	}
@@ -220,7 +219,6 @@ func pasetoFromParam(param string) pasetoExtractor {
 
 func pasetoFromCookie(name string) pasetoExtractor {
 	return func(c echo.Context) (string, error) {
-		fmt.Printf("name: %v\n", name)
 		cookie, err := c.Cookie(name)
 		if err != nil {
 			return "", ErrPASETOMissing
@@ -234,14 +232,36 @@ func Auth(cfg config.Config) []echo.MiddlewareFunc {
 		PASETOWithConfig(PASETOConfig{
 			Skipper:    func(c echo.Context) bool { return c.Path() == "/_healthz" },
 			SigningKey: cfg.PasetoSecret(),
-			ErrorHandlerWithContext: func(err error, c echo.Context) error {
-				httpStatus := HttpStatusPbFromRPC(GRPCStatusFromErr(err))
-				b, _ := protojson.Marshal(httpStatus)
-				return c.JSONBlob(int(httpStatus.Error.Code), b)
-			},
 		},
 		),
 		SetClaimsMiddleware(),
+		ActiveSession(cfg.SessionName()),
+	}
+}
+
+// sessionChecker is implemented by the stores keeping sessions on the
+// server, see session.DBStore.
+type sessionChecker interface {
+	Active(r *http.Request, name, userID string) bool
+}
+
+// ActiveSession rejects a valid token whose web session has been revoked or
+// has expired. With the cookie store there is nothing to revoke and every
+// request passes.
+func ActiveSession(name string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			checker, ok := gothic.Store.(sessionChecker)
+			if !ok {
+				return next(c)
+			}
+			userID := UserClaimFromContext(c.Request().Context()).ID
+			if userID == "" || !checker.Active(c.Request(), name, userID) {
+				logger.FromContext(c.Request().Context()).Debug("session revoked or expired")
+				return c.Redirect(http.StatusTemporaryRedirect, "/login")
+			}
+			return next(c)
+		}
 	}
 }
 
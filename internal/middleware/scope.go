@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/anousonefs/golang-htmx-template/internal/utils"
+)
+
+// The scopes of a policy: a rule reaches every record, or only the ones of
+// the subject's department and of the departments under it.
+const (
+	ScopeAll        = "all"
+	ScopeDepartment = "department"
+)
+
+var Scopes = []string{ScopeAll, ScopeDepartment}
+
+// Scope is what Authorize allowed the request to reach, the repositories
+// filter their queries with it.
+type Scope struct {
+	All         bool
+	Departments []string
+}
+
+// Contains reports whether a record of the department is in the scope.
+func (s Scope) Contains(departmentID string) bool {
+	return s.All || (departmentID != "" && utils.ContainsString(s.Departments, departmentID))
+}
+
+type scopeCtxKey struct{}
+
+func WithScope(ctx context.Context, s Scope) context.Context {
+	return context.WithValue(ctx, scopeCtxKey{}, s)
+}
+
+// ScopeFromContext returns the scope set by Authorize, ok is false outside
+// of an authorized request, e.g. in the CLI or at login.
+func ScopeFromContext(ctx context.Context) (s Scope, ok bool) {
+	s, ok = ctx.Value(scopeCtxKey{}).(Scope)
+	return s, ok
+}
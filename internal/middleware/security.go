@@ -0,0 +1,207 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// CSPReportPath receives the violation reports of the browsers, see
+// CSPReportHandler.
+const CSPReportPath = "/csp-report"
+
+// directive is one "name value" pair of a policy, kept in a slice so the
+// header is written in a stable order.
+type directive struct {
+	name  string
+	value string
+}
+
+var defaultDirectives = []directive{
+	{"default-src", "'self'"},
+	{"script-src", ""},
+	{"style-src", ""},
+	{"img-src", "'self' data:"},
+	{"object-src", "'none'"},
+	{"base-uri", "'self'"},
+	{"form-action", "'self'"},
+	{"frame-ancestors", "'none'"},
+}
+
+// parseDirectives reads "name value; name value".
+func parseDirectives(s string) []directive {
+	var res []directive
+	for _, part := range strings.Split(s, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), " ")
+		if name == "" {
+			continue
+		}
+		res = append(res, directive{strings.ToLower(name), strings.TrimSpace(value)})
+	}
+	return res
+}
+
+// cspTemplate merges the configured directives over the defaults. The
+// result still needs the nonces of the request, see Nonces.policy.
+func cspTemplate(cfg config.Config) []directive {
+	res := append([]directive(nil), defaultDirectives...)
+	set := func(d directive) {
+		for i := range res {
+			if res[i].name == d.name {
+				res[i].value = d.value
+				return
+			}
+		}
+		res = append(res, d)
+	}
+	// keep frame-ancestors in line with X-Frame-Options.
+	if cfg.FrameOptions() == "SAMEORIGIN" {
+		set(directive{"frame-ancestors", "'self'"})
+	}
+	for _, d := range parseDirectives(cfg.CSPDirectives()) {
+		set(d)
+	}
+	for i, d := range res {
+		if d.name == "style-src" {
+			for _, h := range cfg.CSPStyleHashes() {
+				res[i].value = strings.TrimSpace(res[i].value + " '" + h + "'")
+			}
+		}
+	}
+	set(directive{"report-uri", CSPReportPath})
+	return res
+}
+
+func (n Nonces) policy(tmpl []directive) string {
+	parts := make([]string, 0, len(tmpl))
+	for _, d := range tmpl {
+		value := d.value
+		switch d.name {
+		case "script-src":
+			value = strings.TrimSpace(fmt.Sprintf("%s 'nonce-%s' 'nonce-%s'", value, n.Htmx, n.ResponseTargets))
+		case "style-src":
+			value = strings.TrimSpace(fmt.Sprintf("%s 'nonce-%s' 'nonce-%s'", value, n.Tw, n.InlineStyle))
+		}
+		parts = append(parts, d.name+" "+value)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// CSP generates the nonces of the request, stores them for GetNonces and
+// writes the Content-Security-Policy built from cfg.
+func CSP(cfg config.Config) echo.MiddlewareFunc {
+	tmpl := cspTemplate(cfg)
+	header := echo.HeaderContentSecurityPolicy
+	if cfg.CSPReportOnly() {
+		header = echo.HeaderContentSecurityPolicyReportOnly
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			nonceSet := Nonces{
+				Htmx:            generateRandomString(16),
+				ResponseTargets: generateRandomString(16),
+				Tw:              generateRandomString(16),
+				InlineStyle:     generateRandomString(16),
+			}
+			ctx := context.WithValue(c.Request().Context(), NonceKey, nonceSet)
+			c.SetRequest(c.Request().WithContext(ctx))
+			c.Response().Header().Set(header, nonceSet.policy(tmpl))
+			return next(c)
+		}
+	}
+}
+
+// Security returns the CSP, the echo Secure headers, Permissions-Policy and,
+// when origins are configured, CORS. Without CORS_ALLOW_ORIGINS no CORS
+// header is written so browsers keep the same-origin policy.
+func Security(cfg config.Config) []echo.MiddlewareFunc {
+	mws := []echo.MiddlewareFunc{
+		CSP(cfg),
+		middleware.SecureWithConfig(middleware.SecureConfig{
+			XSSProtection:      "0",
+			ContentTypeNosniff: "nosniff",
+			XFrameOptions:      cfg.FrameOptions(),
+			HSTSMaxAge:         cfg.HSTSMaxAge(),
+			ReferrerPolicy:     "strict-origin-when-cross-origin",
+		}),
+		func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(c echo.Context) error {
+				if p := cfg.PermissionsPolicy(); p != "" {
+					c.Response().Header().Set("Permissions-Policy", p)
+				}
+				return next(c)
+			}
+		},
+	}
+	if origins := cfg.CORSAllowOrigins(); len(origins) > 0 {
+		mws = append(mws, middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins:     origins,
+			AllowCredentials: true,
+			ExposeHeaders:    []string{echo.HeaderXRequestID, "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+		}))
+	}
+	return mws
+}
+
+// maxReportSize caps the body of a violation report, browsers send a few
+// hundred bytes.
+const maxReportSize = 16 << 10
+
+// reportFields are the members of a report worth logging.
+var reportFields = []string{
+	"document-uri",
+	"violated-directive",
+	"effective-directive",
+	"blocked-uri",
+	"source-file",
+	"line-number",
+	"disposition",
+}
+
+// CSPReportHandler logs the violation reports sent to report-uri, in the
+// application/csp-report format.
+func CSPReportHandler(c echo.Context) error {
+	var body struct {
+		Report map[string]interface{} `json:"csp-report"`
+	}
+	b, err := io.ReadAll(io.LimitReader(c.Request().Body, maxReportSize))
+	if err != nil || json.Unmarshal(b, &body) != nil || body.Report == nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	var attrs []any
+	for _, k := range reportFields {
+		if v, ok := body.Report[k]; ok {
+			attrs = append(attrs, strings.ReplaceAll(k, "-", "_"), v)
+		}
+	}
+	logger.FromContext(c.Request().Context()).Warn("csp violation", attrs...)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// IPExtractor reads the client IP of a request. Behind the TrustedProxies
+// it is the first X-Forwarded-For hop not one of them, otherwise the remote
+// address: a header the client sent itself is never believed.
+func IPExtractor(cfg config.Config) echo.IPExtractor {
+	proxies := cfg.TrustedProxies()
+	if len(proxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, n := range proxies {
+		options = append(options, echo.TrustIPRange(n))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
@@ -0,0 +1,88 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+
+	_ "modernc.org/sqlite"
+)
+
+// tables lists the tables of the database but schema_migrations.
+func tables(t *testing.T, db *sql.DB) []string {
+	t.Helper()
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name <> 'schema_migrations' ORDER BY name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var res []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// TestUpDownSqlite applies the sqlite migrations of the app, rolls every one
+// back and applies them again.
+func TestUpDownSqlite(t *testing.T) {
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", filepath.Join(t.TempDir(), "test.db"))
+	db, err := sql.Open(config.DriverSqlite, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	m, err := New(db, config.DriverSqlite, os.DirFS("../../cmd/migrations"), config.DriverSqlite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	applied, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) == 0 || len(applied) != len(m.migrations) {
+		t.Fatalf("applied %d of %d migrations", len(applied), len(m.migrations))
+	}
+	if len(tables(t, db)) == 0 {
+		t.Fatal("no table created")
+	}
+	if _, err := m.Up(ctx); !errors.Is(err, ErrNoChange) {
+		t.Fatalf("second Up: got %v, want ErrNoChange", err)
+	}
+	if n, err := m.Pending(ctx); err != nil || n != 0 {
+		t.Fatalf("Pending: got %d, %v", n, err)
+	}
+
+	reverted, err := m.Down(ctx, len(applied))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != len(applied) {
+		t.Fatalf("reverted %d of %d migrations", len(reverted), len(applied))
+	}
+	if left := tables(t, db); len(left) > 0 {
+		t.Fatalf("tables left after Down: %v", left)
+	}
+	if n, err := m.Pending(ctx); err != nil || n != len(applied) {
+		t.Fatalf("Pending: got %d, %v, want %d", n, err, len(applied))
+	}
+
+	if again, err := m.Up(ctx); err != nil || len(again) != len(applied) {
+		t.Fatalf("Up after Down: applied %d, %v", len(again), err)
+	}
+}
@@ -0,0 +1,151 @@
+package notification
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// pollWindow is how far back the broker looks for new items. An item
+// becomes visible when the transaction creating it commits, possibly well
+// after its created_at, and other processes stamp it with their own clock.
+const pollWindow = time.Minute
+
+// subscriberBuffer is the number of items a slow stream may lag behind
+// before it is dropped, the client then reconnects and catches up with
+// Last-Event-ID.
+const subscriberBuffer = 16
+
+type subscriber struct {
+	ch chan Item
+}
+
+// Broker fans the new inbox items out to the open streams of their user.
+// It polls the notifications table so items created by any process, the
+// workers included, reach every server.
+type Broker struct {
+	repo     *Repo
+	interval time.Duration
+	log      *slog.Logger
+
+	mu     sync.Mutex
+	subs   map[string]map[*subscriber]struct{}
+	closed bool
+}
+
+func NewBroker(repo *Repo, interval time.Duration, log *slog.Logger) *Broker {
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	return &Broker{
+		repo:     repo,
+		interval: interval,
+		log:      log,
+		subs:     map[string]map[*subscriber]struct{}{},
+	}
+}
+
+// Subscribe returns the items created for userID from now on. The channel
+// is closed when the broker closes or the subscriber falls behind, call
+// cancel once done with it.
+func (b *Broker) Subscribe(userID string) (<-chan Item, func()) {
+	s := &subscriber{ch: make(chan Item, subscriberBuffer)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(s.ch)
+		return s.ch, func() {}
+	}
+	if b.subs[userID] == nil {
+		b.subs[userID] = map[*subscriber]struct{}{}
+	}
+	b.subs[userID][s] = struct{}{}
+	return s.ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(userID, s)
+	}
+}
+
+// remove drops s, b.mu must be held.
+func (b *Broker) remove(userID string, s *subscriber) {
+	if _, ok := b.subs[userID][s]; !ok {
+		return
+	}
+	delete(b.subs[userID], s)
+	if len(b.subs[userID]) == 0 {
+		delete(b.subs, userID)
+	}
+	close(s.ch)
+}
+
+// Close ends every stream, register it with the server's RegisterOnShutdown
+// so the open streams do not hold the shutdown until its timeout.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for userID, subs := range b.subs {
+		for s := range subs {
+			b.remove(userID, s)
+		}
+	}
+}
+
+// Run polls for new items until ctx is done.
+func (b *Broker) Run(ctx context.Context) error {
+	seen := map[int64]time.Time{}
+	// the items already there when the broker starts are not new.
+	b.poll(ctx, seen, false)
+	t := time.NewTicker(b.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+			b.poll(ctx, seen, true)
+		}
+	}
+}
+
+func (b *Broker) poll(ctx context.Context, seen map[int64]time.Time, publish bool) {
+	since := time.Now().Add(-pollWindow)
+	items, err := b.repo.listItems(ctx, FilterItem{Since: since})
+	if err != nil {
+		if ctx.Err() == nil {
+			b.log.Error("poll notifications", "err", err)
+		}
+		return
+	}
+	for id, t := range seen {
+		if t.Before(since) {
+			delete(seen, id)
+		}
+	}
+	// oldest first, the order the streams send them.
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		if _, ok := seen[item.ID]; ok {
+			continue
+		}
+		seen[item.ID] = item.CreatedAt
+		if publish {
+			b.publish(item)
+		}
+	}
+}
+
+func (b *Broker) publish(item Item) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs[item.UserID] {
+		select {
+		case s.ch <- item:
+		default:
+			b.log.Warn("notification stream lagging, dropped", "user_id", item.UserID)
+			b.remove(item.UserID, s)
+		}
+	}
+}
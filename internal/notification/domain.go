@@ -0,0 +1,93 @@
+package notification
+
+import (
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/validation"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// KindPush is the job kind sending a Push.
+const KindPush = "notification.push"
+
+type Device struct {
+	PlayerID  string    `json:"playerID"`
+	UserID    string    `json:"-"`
+	Platform  string    `json:"platform"`
+	Locale    string    `json:"locale"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Platforms are the device kinds accepted at registration.
+var Platforms = []string{"android", "ios", "web"}
+
+func (d Device) Validate() error {
+	v := validation.New()
+	v.Required("playerID", d.PlayerID)
+	v.MaxLength("playerID", d.PlayerID, 128)
+	validation.Enum(v, "platform", d.Platform, Platforms...)
+	return v.Err()
+}
+
+// Push is a notification to every device of a user. Title and Message are
+// i18n keys translated in the locale of each device when it is sent, Args
+// fill the message.
+type Push struct {
+	UserID  string            `json:"userID"`
+	Title   string            `json:"title"`
+	Message string            `json:"message"`
+	Args    []string          `json:"args,omitempty"`
+	URL     string            `json:"url,omitempty"`
+	Data    map[string]string `json:"data,omitempty"`
+}
+
+// Item is a notification of the in-app inbox, Title and Message are i18n
+// keys like the ones of Push.
+type Item struct {
+	ID        int64             `json:"id"`
+	UserID    string            `json:"-"`
+	Title     string            `json:"title"`
+	Message   string            `json:"message"`
+	Args      []string          `json:"args"`
+	URL       string            `json:"url"`
+	Data      map[string]string `json:"data"`
+	ReadAt    *time.Time        `json:"readAt"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+func (i Item) Unread() bool {
+	return i.ReadAt == nil
+}
+
+type FilterItem struct {
+	ID     int64
+	UserID string
+	// AfterID lists the items newer than a known one, for the stream
+	// reconnects.
+	AfterID int64
+	Since   time.Time
+	Unread  bool
+	Limit   uint64
+}
+
+func (f FilterItem) ToSql() (string, []interface{}, error) {
+	and := squirrel.And{}
+	if f.ID != 0 {
+		and = append(and, squirrel.Eq{"id": f.ID})
+	}
+	if f.UserID != "" {
+		and = append(and, squirrel.Eq{"user_id": f.UserID})
+	}
+	if f.AfterID > 0 {
+		and = append(and, squirrel.Gt{"id": f.AfterID})
+	}
+	if !f.Since.IsZero() {
+		and = append(and, squirrel.Gt{"created_at": f.Since.UTC()})
+	}
+	if f.Unread {
+		and = append(and, squirrel.Eq{"read_at": nil})
+	}
+	return and.ToSql()
+}
@@ -0,0 +1,310 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/middleware"
+	"github.com/anousonefs/golang-htmx-template/internal/ratelimit"
+	"github.com/anousonefs/golang-htmx-template/internal/tracing"
+
+	"github.com/a-h/templ"
+	"github.com/labstack/echo/v4"
+)
+
+// heartbeat keeps idle streams from being closed by proxies.
+const heartbeat = 30 * time.Second
+
+// replayLimit is the most missed items a reconnecting client is sent one by
+// one, past it the stream sends the whole list again.
+const replayLimit = 50
+
+type handler struct {
+	notification *Service
+	broker       *Broker
+}
+
+func NewHandler(notification *Service, broker *Broker) *handler {
+	return &handler{notification, broker}
+}
+
+// Install adds the push device routes, the inbox and its stream of the
+// signed in user.
+func (h *handler) Install(e *echo.Echo, cfg config.Config, rl *ratelimit.Limiter) {
+	mws := append(middleware.Auth(cfg), rl.ReadWrite())
+
+	devices := e.Group("/api/v1/devices", mws...)
+	devices.GET("", h.listDevices)
+	devices.POST("", h.registerDevice)
+	devices.DELETE("/:playerID", h.removeDevice)
+
+	api := e.Group("/api/v1/notifications", mws...)
+	api.GET("", h.listInbox)
+	api.POST("/read", h.markAllRead)
+	api.POST("/:id/read", h.markRead)
+
+	page := e.Group("", mws...)
+	page.GET("/notifications", h.inboxPage)
+	page.GET("/notifications/stream", h.stream)
+	page.POST("/notifications/read", h.markAllReadPage)
+	page.POST("/notifications/:id/read", h.markReadPage)
+}
+
+func (h *handler) listDevices(c echo.Context) error {
+	ctx := c.Request().Context()
+	res, err := h.notification.ListDevices(ctx, middleware.UserClaimFromContext(ctx).ID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *handler) registerDevice(c echo.Context) error {
+	var req Device
+	if err := c.Bind(&req); err != nil {
+		logger.FromContext(c.Request().Context()).Warn("bind request", "err", err)
+		return apperror.StatusBindingFailure.Err()
+	}
+	ctx := c.Request().Context()
+	req.UserID = middleware.UserClaimFromContext(ctx).ID
+	if err := h.notification.RegisterDevice(ctx, req); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *handler) removeDevice(c echo.Context) error {
+	ctx := c.Request().Context()
+	if err := h.notification.RemoveDevice(ctx, middleware.UserClaimFromContext(ctx).ID, c.Param("playerID")); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func paramID(c echo.Context) (int64, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, apperror.ErrBadRequest
+	}
+	return id, nil
+}
+
+func (h *handler) listInbox(c echo.Context) error {
+	ctx := c.Request().Context()
+	filter := FilterItem{
+		UserID: middleware.UserClaimFromContext(ctx).ID,
+		Unread: c.QueryParam("unread") == "true",
+	}
+	res, err := h.notification.ListInbox(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *handler) markRead(c echo.Context) error {
+	id, err := paramID(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	if _, err := h.notification.MarkRead(ctx, middleware.UserClaimFromContext(ctx).ID, id); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *handler) markAllRead(c echo.Context) error {
+	ctx := c.Request().Context()
+	if err := h.notification.MarkAllRead(ctx, middleware.UserClaimFromContext(ctx).ID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *handler) inboxPage(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := middleware.UserClaimFromContext(ctx).ID
+	items, err := h.notification.ListInbox(ctx, FilterItem{UserID: userID})
+	if err != nil {
+		return err
+	}
+	n, err := h.notification.UnreadCount(ctx, userID)
+	if err != nil {
+		return err
+	}
+	w := c.Response().Writer
+	if err := tracing.Component("InboxPage", InboxPage(items)).Render(ctx, w); err != nil {
+		return err
+	}
+	return unreadOOB(n).Render(ctx, w)
+}
+
+func (h *handler) markReadPage(c echo.Context) error {
+	id, err := paramID(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	userID := middleware.UserClaimFromContext(ctx).ID
+	item, err := h.notification.MarkRead(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	n, err := h.notification.UnreadCount(ctx, userID)
+	if err != nil {
+		return err
+	}
+	w := c.Response().Writer
+	if err := InboxItem(item).Render(ctx, w); err != nil {
+		return err
+	}
+	return unreadOOB(n).Render(ctx, w)
+}
+
+func (h *handler) markAllReadPage(c echo.Context) error {
+	ctx := c.Request().Context()
+	if err := h.notification.MarkAllRead(ctx, middleware.UserClaimFromContext(ctx).ID); err != nil {
+		return err
+	}
+	return h.inboxPage(c)
+}
+
+// lastEventID is the id of the last item the client got: the header the
+// browser sends when it reconnects, or the query parameter of a new
+// connection.
+func lastEventID(c echo.Context) int64 {
+	v := c.Request().Header.Get("Last-Event-ID")
+	if v == "" {
+		v = c.QueryParam("last_event_id")
+	}
+	id, _ := strconv.ParseInt(v, 10, 64)
+	return id
+}
+
+// stream sends the new inbox items of the user as server sent events, the
+// "notification" events carry the html of the item and "unread" the bell
+// badge. A reconnecting client first gets the items it missed, or the
+// latest ones in a "reload" event when it missed more than replayLimit.
+func (h *handler) stream(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := middleware.UserClaimFromContext(ctx).ID
+	// subscribe before the replay so nothing created meanwhile is lost.
+	items, cancel := h.broker.Subscribe(userID)
+	defer cancel()
+
+	var missed, inbox []Item
+	if last := lastEventID(c); last > 0 {
+		var err error
+		if missed, err = h.notification.ListInbox(ctx, FilterItem{UserID: userID, AfterID: last, Limit: replayLimit + 1}); err != nil {
+			return err
+		}
+		if len(missed) > replayLimit {
+			if inbox, err = h.notification.ListInbox(ctx, FilterItem{UserID: userID}); err != nil {
+				return err
+			}
+		}
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprint(res, "retry: 5000\n\n"); err != nil {
+		return nil
+	}
+
+	// the items already sent, the subscription may deliver them again. Ids
+	// are not compared to the last one sent: transactions commit out of
+	// order, a smaller id can come later.
+	sent := map[int64]bool{}
+	if inbox != nil {
+		if err := h.send(ctx, c, "reload", missed[0].ID, reloaded(inbox)); err != nil {
+			return nil
+		}
+		for _, item := range inbox {
+			sent[item.ID] = true
+		}
+	} else {
+		for i := len(missed) - 1; i >= 0; i-- {
+			if err := h.send(ctx, c, "notification", missed[i].ID, streamedItem(missed[i])); err != nil {
+				return nil
+			}
+			sent[missed[i].ID] = true
+		}
+	}
+	if err := h.sendUnread(ctx, c, userID); err != nil {
+		return nil
+	}
+
+	t := time.NewTicker(heartbeat)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case item, ok := <-items:
+			if !ok {
+				return nil
+			}
+			if sent[item.ID] {
+				// delivered at most twice, once by the replay.
+				delete(sent, item.ID)
+				continue
+			}
+			if err := h.send(ctx, c, "notification", item.ID, streamedItem(item)); err != nil {
+				return nil
+			}
+			if err := h.sendUnread(ctx, c, userID); err != nil {
+				return nil
+			}
+		case <-t.C:
+			if _, err := fmt.Fprint(res, ": ping\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
+
+func (h *handler) sendUnread(ctx context.Context, c echo.Context, userID string) error {
+	n, err := h.notification.UnreadCount(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return h.send(ctx, c, "unread", 0, Unread(n))
+}
+
+// send writes comp as the data of an event, id 0 leaves the last event id
+// of the client as it is.
+func (h *handler) send(ctx context.Context, c echo.Context, event string, id int64, comp templ.Component) error {
+	var buf bytes.Buffer
+	if err := comp.Render(ctx, &buf); err != nil {
+		return err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "event: %s\n", event)
+	if id > 0 {
+		fmt.Fprintf(&b, "id: %d\n", id)
+	}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	res := c.Response()
+	if _, err := res.Write([]byte(b.String())); err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
+}
@@ -0,0 +1,61 @@
+package notification
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// Notification is the message delivered to a device, already translated.
+type Notification struct {
+	Title   string            `json:"title"`
+	Message string            `json:"message"`
+	URL     string            `json:"url,omitempty"`
+	Data    map[string]string `json:"data,omitempty"`
+}
+
+type Result struct {
+	// ID is the id of the notification at the provider.
+	ID string
+	// Invalid lists the player ids the provider no longer knows, their
+	// devices are removed.
+	Invalid []string
+}
+
+// Notifier delivers notifications to devices identified by their player id.
+type Notifier interface {
+	Send(ctx context.Context, playerIDs []string, n Notification) (Result, error)
+}
+
+// Sent is a notification recorded by LogNotifier.
+type Sent struct {
+	PlayerIDs    []string
+	Notification Notification
+}
+
+// LogNotifier logs the notifications instead of delivering them and keeps
+// them in memory, for development and tests.
+type LogNotifier struct {
+	log  *slog.Logger
+	mu   sync.Mutex
+	sent []Sent
+}
+
+func NewLogNotifier(log *slog.Logger) *LogNotifier {
+	return &LogNotifier{log: log}
+}
+
+func (l *LogNotifier) Send(ctx context.Context, playerIDs []string, n Notification) (Result, error) {
+	l.mu.Lock()
+	l.sent = append(l.sent, Sent{PlayerIDs: playerIDs, Notification: n})
+	l.mu.Unlock()
+	l.log.InfoContext(ctx, "notification", "to", playerIDs, "title", n.Title, "message", n.Message)
+	return Result{}, nil
+}
+
+// Sent returns the notifications sent so far.
+func (l *LogNotifier) Sent() []Sent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Sent(nil), l.sent...)
+}
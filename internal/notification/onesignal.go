@@ -0,0 +1,109 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const oneSignalURL = "https://onesignal.com/api/v1/notifications"
+
+// OneSignal sends the notifications with the OneSignal REST API.
+type OneSignal struct {
+	appID  string
+	apiKey string
+	url    string
+	client *http.Client
+}
+
+func NewOneSignal(appID, apiKey string) *OneSignal {
+	return &OneSignal{
+		appID:  appID,
+		apiKey: apiKey,
+		url:    oneSignalURL,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Error is a request OneSignal answered with an error status.
+type Error struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("onesignal: status %d: %s", e.StatusCode, e.Body)
+}
+
+// Temporary reports whether sending again later may succeed, the other
+// errors come from the request itself.
+func (e *Error) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+type oneSignalRequest struct {
+	AppID            string            `json:"app_id"`
+	IncludePlayerIDs []string          `json:"include_player_ids"`
+	Headings         map[string]string `json:"headings,omitempty"`
+	Contents         map[string]string `json:"contents"`
+	URL              string            `json:"url,omitempty"`
+	Data             map[string]string `json:"data,omitempty"`
+}
+
+type oneSignalResponse struct {
+	ID string `json:"id"`
+	// Errors is a list of messages, or an object with the invalid player
+	// ids when only some of them were rejected.
+	Errors json.RawMessage `json:"errors"`
+}
+
+func (o *OneSignal) Send(ctx context.Context, playerIDs []string, n Notification) (Result, error) {
+	// the text is already in the language of the devices, OneSignal
+	// requires it under "en".
+	body, err := json.Marshal(oneSignalRequest{
+		AppID:            o.appID,
+		IncludePlayerIDs: playerIDs,
+		Headings:         map[string]string{"en": n.Title},
+		Contents:         map[string]string{"en": n.Message},
+		URL:              n.URL,
+		Data:             n.Data,
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url, bytes.NewReader(body))
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Basic "+o.apiKey)
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Result{}, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return Result{}, &Error{StatusCode: resp.StatusCode, Body: string(b)}
+	}
+	var res oneSignalResponse
+	if err := json.Unmarshal(b, &res); err != nil {
+		return Result{}, fmt.Errorf("onesignal: decode response: %w", err)
+	}
+	var invalid struct {
+		PlayerIDs []string `json:"invalid_player_ids"`
+	}
+	if len(res.Errors) > 0 && res.Errors[0] == '{' {
+		if err := json.Unmarshal(res.Errors, &invalid); err != nil {
+			return Result{}, fmt.Errorf("onesignal: decode errors: %w", err)
+		}
+	}
+	return Result{ID: res.ID, Invalid: invalid.PlayerIDs}, nil
+}
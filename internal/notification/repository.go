@@ -0,0 +1,213 @@
+package notification
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/database"
+	"github.com/anousonefs/golang-htmx-template/internal/metrics"
+
+	"github.com/Masterminds/squirrel"
+)
+
+type Repo struct {
+	db database.DBTX
+	sb squirrel.StatementBuilderType
+}
+
+func NewRepo(db *sql.DB, sb squirrel.StatementBuilderType) *Repo {
+	return &Repo{db: db, sb: sb}
+}
+
+// WithTx returns a copy of the repo that runs its queries on tx.
+func (r Repo) WithTx(tx *sql.Tx) *Repo {
+	return &Repo{db: tx, sb: r.sb}
+}
+
+func (r *Repo) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return database.WithTx(ctx, r.db, fn)
+}
+
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// saveDevice registers the device, moving it to the user when it belonged
+// to another one.
+func (r *Repo) saveDevice(ctx context.Context, d Device) error {
+	defer metrics.ObserveQuery("notification", "saveDevice")()
+	t := now()
+	query, args, err := r.sb.
+		Update("user_devices").
+		SetMap(squirrel.Eq{
+			"user_id":    d.UserID,
+			"platform":   d.Platform,
+			"locale":     d.Locale,
+			"updated_at": t,
+		}).
+		Where(squirrel.Eq{"player_id": d.PlayerID}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	query, args, err = r.sb.
+		Insert("user_devices").
+		Columns("player_id", "user_id", "platform", "locale", "created_at", "updated_at").
+		Values(d.PlayerID, d.UserID, d.Platform, d.Locale, t, t).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (r *Repo) deleteDevices(ctx context.Context, where squirrel.Sqlizer) (int64, error) {
+	defer metrics.ObserveQuery("notification", "deleteDevices")()
+	query, args, err := r.sb.Delete("user_devices").Where(where).ToSql()
+	if err != nil {
+		return 0, err
+	}
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *Repo) listDevices(ctx context.Context, userID string) ([]Device, error) {
+	defer metrics.ObserveQuery("notification", "listDevices")()
+	query, args, err := r.sb.
+		Select("player_id", "user_id", "platform", "locale", "created_at", "updated_at").
+		From("user_devices").
+		Where(squirrel.Eq{"user_id": userID}).
+		OrderBy("created_at").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []Device{}
+	for rows.Next() {
+		var d Device
+		if err := rows.Scan(&d.PlayerID, &d.UserID, &d.Platform, &d.Locale, &d.CreatedAt, &d.UpdatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+	return res, rows.Err()
+}
+
+func (r *Repo) insertItem(ctx context.Context, i Item) error {
+	defer metrics.ObserveQuery("notification", "insertItem")()
+	if i.Args == nil {
+		i.Args = []string{}
+	}
+	args, err := json.Marshal(i.Args)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(i.Data)
+	if err != nil {
+		return err
+	}
+	query, qargs, err := r.sb.
+		Insert("notifications").
+		Columns("user_id", "title", "message", "args", "url", "data", "created_at").
+		Values(i.UserID, i.Title, i.Message, string(args), i.URL, string(data), now()).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, query, qargs...)
+	return err
+}
+
+// listItems returns the items of filter, newest first.
+func (r *Repo) listItems(ctx context.Context, filter FilterItem) ([]Item, error) {
+	defer metrics.ObserveQuery("notification", "listItems")()
+	q := r.sb.
+		Select("id", "user_id", "title", "message", "args", "url", "data", "read_at", "created_at").
+		From("notifications").
+		Where(filter).
+		OrderBy("id DESC")
+	if filter.Limit > 0 {
+		q = q.Limit(filter.Limit)
+	}
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []Item{}
+	for rows.Next() {
+		var i Item
+		var args, data string
+		var readAt sql.NullTime
+		if err := rows.Scan(&i.ID, &i.UserID, &i.Title, &i.Message, &args, &i.URL, &data, &readAt, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(args), &i.Args); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(data), &i.Data); err != nil {
+			return nil, err
+		}
+		if readAt.Valid {
+			i.ReadAt = &readAt.Time
+		}
+		res = append(res, i)
+	}
+	return res, rows.Err()
+}
+
+func (r *Repo) countUnread(ctx context.Context, userID string) (int, error) {
+	defer metrics.ObserveQuery("notification", "countUnread")()
+	query, args, err := r.sb.
+		Select("COUNT(*)").
+		From("notifications").
+		Where(FilterItem{UserID: userID, Unread: true}).
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	err = r.db.QueryRowContext(ctx, query, args...).Scan(&n)
+	return n, err
+}
+
+// markRead marks the unread items of the user matching where as read.
+func (r *Repo) markRead(ctx context.Context, userID string, where squirrel.Sqlizer) (int64, error) {
+	defer metrics.ObserveQuery("notification", "markRead")()
+	query, args, err := r.sb.
+		Update("notifications").
+		Set("read_at", now()).
+		Where(FilterItem{UserID: userID, Unread: true}).
+		Where(where).
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
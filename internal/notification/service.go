@@ -0,0 +1,237 @@
+package notification
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/event"
+	"github.com/anousonefs/golang-htmx-template/internal/i18n"
+	"github.com/anousonefs/golang-htmx-template/internal/job"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/validation"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// maxItems bounds the inbox pages and the replays of the stream.
+const maxItems = 100
+
+type Service struct {
+	repo     *Repo
+	jobs     *job.Service
+	notifier Notifier
+}
+
+func NewService(repo *Repo, jobs *job.Service, notifier Notifier) *Service {
+	return &Service{repo: repo, jobs: jobs, notifier: notifier}
+}
+
+// RegisterDevice stores the player id of a device of d.UserID, the locale
+// defaults to the one of the request.
+func (s *Service) RegisterDevice(ctx context.Context, d Device) (err error) {
+	defer func() {
+		if err != nil {
+			logger.FromContext(ctx).Error("notification.RegisterDevice", "err", err)
+		}
+	}()
+	if d.Locale == "" {
+		d.Locale = i18n.Lang(ctx)
+	}
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	v := validation.New()
+	validation.Enum(v, "locale", d.Locale, i18n.Supported...)
+	if err := v.Err(); err != nil {
+		return err
+	}
+	return s.repo.saveDevice(ctx, d)
+}
+
+func (s *Service) RemoveDevice(ctx context.Context, userID, playerID string) (err error) {
+	defer func() {
+		if err != nil && !errors.Is(err, apperror.ErrStatusNotFound) {
+			logger.FromContext(ctx).Error("notification.RemoveDevice", "err", err)
+		}
+	}()
+	n, err := s.repo.deleteDevices(ctx, squirrel.Eq{"user_id": userID, "player_id": playerID})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return apperror.ErrStatusNotFound
+	}
+	return nil
+}
+
+func (s *Service) ListDevices(ctx context.Context, userID string) (res []Device, err error) {
+	res, err = s.repo.listDevices(ctx, userID)
+	if err != nil {
+		logger.FromContext(ctx).Error("notification.ListDevices", "err", err)
+		return []Device{}, err
+	}
+	return res, nil
+}
+
+// Notify adds p to the inbox of the user and queues it for their devices,
+// it is pushed by a worker so a slow or failing provider neither delays nor
+// fails the caller.
+func (s *Service) Notify(ctx context.Context, p Push) error {
+	return s.repo.inTx(ctx, func(tx *sql.Tx) error {
+		return s.notify(ctx, tx, p)
+	})
+}
+
+func (s *Service) notify(ctx context.Context, tx *sql.Tx, p Push) error {
+	if p.UserID == "" || p.Title == "" || p.Message == "" {
+		return apperror.ErrBadRequest
+	}
+	if err := s.repo.WithTx(tx).insertItem(ctx, Item{
+		UserID:  p.UserID,
+		Title:   p.Title,
+		Message: p.Message,
+		Args:    p.Args,
+		URL:     p.URL,
+		Data:    p.Data,
+	}); err != nil {
+		return err
+	}
+	_, err := s.jobs.WithTx(tx).Enqueue(ctx, KindPush, p)
+	return err
+}
+
+// ListInbox returns the latest items of the user, newest first.
+func (s *Service) ListInbox(ctx context.Context, filter FilterItem) (res []Item, err error) {
+	if filter.UserID == "" {
+		return nil, apperror.ErrBadRequest
+	}
+	if filter.Limit == 0 || filter.Limit > maxItems {
+		filter.Limit = maxItems
+	}
+	res, err = s.repo.listItems(ctx, filter)
+	if err != nil {
+		logger.FromContext(ctx).Error("notification.ListInbox", "err", err)
+		return []Item{}, err
+	}
+	return res, nil
+}
+
+func (s *Service) UnreadCount(ctx context.Context, userID string) (n int, err error) {
+	n, err = s.repo.countUnread(ctx, userID)
+	if err != nil {
+		logger.FromContext(ctx).Error("notification.UnreadCount", "err", err)
+	}
+	return n, err
+}
+
+// MarkRead marks an item of the user as read and returns it, reading it
+// twice is not an error.
+func (s *Service) MarkRead(ctx context.Context, userID string, id int64) (res Item, err error) {
+	defer func() {
+		if err != nil && !errors.Is(err, apperror.ErrStatusNotFound) {
+			logger.FromContext(ctx).Error("notification.MarkRead", "err", err)
+		}
+	}()
+	if _, err := s.repo.markRead(ctx, userID, squirrel.Eq{"id": id}); err != nil {
+		return Item{}, err
+	}
+	items, err := s.repo.listItems(ctx, FilterItem{ID: id, UserID: userID})
+	if err != nil {
+		return Item{}, err
+	}
+	if len(items) == 0 {
+		return Item{}, apperror.ErrStatusNotFound
+	}
+	return items[0], nil
+}
+
+func (s *Service) MarkAllRead(ctx context.Context, userID string) (err error) {
+	if _, err = s.repo.markRead(ctx, userID, nil); err != nil {
+		logger.FromContext(ctx).Error("notification.MarkAllRead", "err", err)
+	}
+	return err
+}
+
+// Install registers the job sending the pushes on w.
+func (s *Service) Install(w *job.Worker) {
+	job.Register(w, KindPush, s.send)
+}
+
+// send delivers p to the devices of the user, one notification per locale.
+func (s *Service) send(ctx context.Context, p Push) error {
+	devices, err := s.repo.listDevices(ctx, p.UserID)
+	if err != nil {
+		return err
+	}
+	byLocale := map[string][]string{}
+	var locales []string
+	for _, d := range devices {
+		locale := d.Locale
+		if !i18n.IsSupported(locale) {
+			locale = i18n.Default()
+		}
+		if _, ok := byLocale[locale]; !ok {
+			locales = append(locales, locale)
+		}
+		byLocale[locale] = append(byLocale[locale], d.PlayerID)
+	}
+	args := make([]interface{}, len(p.Args))
+	for i, a := range p.Args {
+		args[i] = a
+	}
+	log := logger.FromContext(ctx)
+	for _, locale := range locales {
+		res, err := s.notifier.Send(ctx, byLocale[locale], Notification{
+			Title:   i18n.Translate(locale, p.Title),
+			Message: i18n.Translate(locale, p.Message, args...),
+			URL:     p.URL,
+			Data:    p.Data,
+		})
+		var providerErr *Error
+		if errors.As(err, &providerErr) && !providerErr.Temporary() {
+			return job.Permanent(err)
+		}
+		if err != nil {
+			return err
+		}
+		log.Debug("notification sent", "user_id", p.UserID, "locale", locale, "id", res.ID)
+		if len(res.Invalid) > 0 {
+			n, err := s.repo.deleteDevices(ctx, squirrel.Eq{"player_id": res.Invalid})
+			if err != nil {
+				return err
+			}
+			log.Info("unsubscribed devices removed", "count", n)
+		}
+	}
+	return nil
+}
+
+// Subscribe notifies the users of the account changes they should hear
+// about, published on bus.
+func (s *Service) Subscribe(bus *event.Bus) {
+	bus.Subscribe(s.onEvent, event.UserCreated{}, event.PasswordReset{}, event.StatusChanged{}, event.RoleChanged{})
+}
+
+func (s *Service) onEvent(ctx context.Context, tx *sql.Tx, e event.Event) error {
+	p := Push{Data: map[string]string{"event": e.EventName()}}
+	switch e := e.(type) {
+	case event.UserCreated:
+		p.UserID = e.UserID
+		p.Title, p.Message = "notification.user_created.title", "notification.user_created.message"
+	case event.PasswordReset:
+		p.UserID = e.UserID
+		p.Title, p.Message = "notification.password_reset.title", "notification.password_reset.message"
+	case event.StatusChanged:
+		p.UserID = e.UserID
+		p.Title, p.Message = "notification.status_changed.title", "notification.status_changed."+e.Status
+	case event.RoleChanged:
+		p.UserID = e.UserID
+		p.Title, p.Message = "notification.role_changed.title", "notification.role_changed.message"
+		p.Args = []string{e.RoleID}
+	default:
+		return nil
+	}
+	return s.notify(ctx, tx, p)
+}
@@ -0,0 +1,158 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/metrics"
+	"github.com/anousonefs/golang-htmx-template/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Policy names used by the route groups, see config.RateLimits.
+const (
+	PolicyLogin  = "login"
+	PolicyUpload = "upload"
+	PolicyRead   = "read"
+	PolicyWrite  = "write"
+)
+
+const (
+	HeaderLimit     = "RateLimit-Limit"
+	HeaderRemaining = "RateLimit-Remaining"
+	HeaderReset     = "RateLimit-Reset"
+	HeaderPolicy    = "RateLimit-Policy"
+)
+
+// Policy allows Limit requests per Per, with bursts of up to Limit.
+type Policy struct {
+	Name  string
+	Limit int
+	Per   time.Duration
+}
+
+func (p Policy) rate() float64 {
+	return float64(p.Limit) / p.Per.Seconds()
+}
+
+// ParsePolicy reads "<limit>/<duration>", e.g. "5/1m". "off" or "" disables
+// the policy, Disabled reports it.
+func ParsePolicy(name, s string) (Policy, error) {
+	p := Policy{Name: name}
+	if s == "" || s == "off" {
+		return p, nil
+	}
+	limit, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return p, fmt.Errorf("rate limit %s: %q is not <limit>/<duration>", name, s)
+	}
+	var err error
+	if p.Limit, err = strconv.Atoi(limit); err != nil || p.Limit < 0 {
+		return p, fmt.Errorf("rate limit %s: invalid limit %q", name, limit)
+	}
+	if p.Per, err = time.ParseDuration(per); err != nil || p.Per <= 0 {
+		return p, fmt.Errorf("rate limit %s: invalid duration %q", name, per)
+	}
+	return p, nil
+}
+
+func (p Policy) Disabled() bool {
+	return p.Limit == 0
+}
+
+// Limiter hands out the middleware of each named policy, all sharing one
+// store.
+type Limiter struct {
+	store    Store
+	policies map[string]Policy
+}
+
+func New(store Store, policies ...Policy) *Limiter {
+	l := &Limiter{store: store, policies: map[string]Policy{}}
+	for _, p := range policies {
+		l.policies[p.Name] = p
+	}
+	return l
+}
+
+// Key identifies the client: the signed in user when the claims are
+// already set, the client IP otherwise (login, public pages).
+func Key(c echo.Context) string {
+	if id := middleware.UserClaimFromContext(c.Request().Context()).ID; id != "" {
+		return "user:" + id
+	}
+	return "ip:" + c.RealIP()
+}
+
+// Limit applies the named policy. In a group it must come after
+// middleware.Auth so requests are counted per user.
+func (l *Limiter) Limit(name string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			p, ok := l.policies[name]
+			if !ok || p.Disabled() {
+				return next(c)
+			}
+			return l.take(c, p, next)
+		}
+	}
+}
+
+// ReadWrite applies the read policy to GET and HEAD and the write policy to
+// every other method.
+func (l *Limiter) ReadWrite() echo.MiddlewareFunc {
+	read, write := l.Limit(PolicyRead), l.Limit(PolicyWrite)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		readNext, writeNext := read(next), write(next)
+		return func(c echo.Context) error {
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead:
+				return readNext(c)
+			}
+			return writeNext(c)
+		}
+	}
+}
+
+func (l *Limiter) take(c echo.Context, p Policy, next echo.HandlerFunc) error {
+	ctx := c.Request().Context()
+	res, err := l.store.Take(ctx, p.Name+":"+Key(c), p)
+	if err != nil {
+		// a broken store must not take the app down with it.
+		logger.FromContext(ctx).Error("rate limit store", "policy", p.Name, "err", err)
+		return next(c)
+	}
+
+	h := c.Response().Header()
+	h.Set(HeaderLimit, strconv.Itoa(res.Limit))
+	h.Set(HeaderRemaining, strconv.Itoa(res.Remaining))
+	h.Set(HeaderReset, ceilSeconds(res.Reset))
+	h.Set(HeaderPolicy, fmt.Sprintf("%d;w=%d", p.Limit, int(p.Per.Seconds())))
+	if res.Allowed {
+		return next(c)
+	}
+
+	metrics.RateLimited(p.Name)
+	logger.FromContext(ctx).Warn("rate limited", "policy", p.Name)
+	h.Set("Retry-After", ceilSeconds(res.RetryAfter))
+	s, err := apperror.StatusTooManyRequests.WithDetails(&edpb.RetryInfo{
+		RetryDelay: durationpb.New(res.RetryAfter),
+	})
+	if err != nil {
+		return apperror.StatusTooManyRequests.Err()
+	}
+	return s.Err()
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
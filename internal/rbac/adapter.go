@@ -0,0 +1,150 @@
+package rbac
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/anousonefs/golang-htmx-template/internal/database"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+)
+
+var columns = [6]string{"v0", "v1", "v2", "v3", "v4", "v5"}
+
+// Adapter stores casbin rules in the same table layout as casbin-pg-adapter
+// (p_type, v0..v5), but on a database.DBTX so policy changes can be part of
+// the caller's transaction.
+type Adapter struct {
+	db    database.DBTX
+	sb    squirrel.StatementBuilderType
+	table string
+}
+
+var _ persist.Adapter = (*Adapter)(nil)
+
+func NewAdapter(db database.DBTX, sb squirrel.StatementBuilderType, table string) *Adapter {
+	return &Adapter{db: db, sb: sb, table: table}
+}
+
+// WithTx returns a copy of the adapter that reads and writes through tx.
+func (a *Adapter) WithTx(tx *sql.Tx) *Adapter {
+	return &Adapter{db: tx, sb: a.sb, table: a.table}
+}
+
+func (a *Adapter) LoadPolicy(m model.Model) error {
+	query, args, err := a.sb.
+		Select("p_type", "v0", "v1", "v2", "v3", "v4", "v5").
+		From(a.table).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	rows, err := a.db.QueryContext(context.Background(), query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ptype string
+		var v [6]string
+		if err := rows.Scan(&ptype, &v[0], &v[1], &v[2], &v[3], &v[4], &v[5]); err != nil {
+			return err
+		}
+		if err := persist.LoadPolicyArray(ruleLine(ptype, v[:]), m); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (a *Adapter) SavePolicy(m model.Model) error {
+	return database.WithTx(context.Background(), a.db, func(tx *sql.Tx) error {
+		query, args, err := a.sb.Delete(a.table).ToSql()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(context.Background(), query, args...); err != nil {
+			return err
+		}
+		for _, sec := range []string{"p", "g"} {
+			for ptype, ast := range m[sec] {
+				for _, rule := range ast.Policy {
+					if err := a.insertRule(tx, ptype, rule); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (a *Adapter) AddPolicy(_ string, ptype string, rule []string) error {
+	return a.insertRule(a.db, ptype, rule)
+}
+
+func (a *Adapter) RemovePolicy(_ string, ptype string, rule []string) error {
+	eq := squirrel.Eq{"p_type": ptype}
+	for i, v := range rule {
+		if i < len(columns) {
+			eq[columns[i]] = v
+		}
+	}
+	return a.delete(eq)
+}
+
+func (a *Adapter) RemoveFilteredPolicy(_ string, ptype string, fieldIndex int, fieldValues ...string) error {
+	eq := squirrel.Eq{"p_type": ptype}
+	for i, v := range fieldValues {
+		idx := fieldIndex + i
+		if v == "" || idx >= len(columns) {
+			continue
+		}
+		eq[columns[idx]] = v
+	}
+	return a.delete(eq)
+}
+
+func (a *Adapter) delete(where squirrel.Eq) error {
+	query, args, err := a.sb.Delete(a.table).Where(where).ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = a.db.ExecContext(context.Background(), query, args...)
+	return err
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (a *Adapter) insertRule(db execer, ptype string, rule []string) error {
+	values := []interface{}{ptype, "", "", "", "", "", ""}
+	for i, v := range rule {
+		if i < len(columns) {
+			values[i+1] = v
+		}
+	}
+	query, args, err := a.sb.
+		Insert(a.table).
+		Columns("p_type", "v0", "v1", "v2", "v3", "v4", "v5").
+		Values(values...).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(context.Background(), query, args...)
+	return err
+}
+
+func ruleLine(ptype string, v []string) []string {
+	line := []string{ptype}
+	// trailing empty columns are not part of the rule.
+	n := len(v)
+	for n > 0 && v[n-1] == "" {
+		n--
+	}
+	return append(line, v[:n]...)
+}
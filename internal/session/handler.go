@@ -0,0 +1,68 @@
+package session
+
+import (
+	"net/http"
+
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/middleware"
+	"github.com/anousonefs/golang-htmx-template/internal/ratelimit"
+
+	"github.com/labstack/echo/v4"
+	"github.com/markbates/goth/gothic"
+)
+
+type handler struct {
+	registry Registry
+	name     string
+}
+
+func NewHandler(registry Registry, cfg config.Config) *handler {
+	return &handler{registry, cfg.SessionName()}
+}
+
+// Install adds the session routes of the signed in user.
+func (h *handler) Install(e *echo.Echo, cfg config.Config, rl *ratelimit.Limiter) {
+	api := e.Group("/api/v1/sessions", append(middleware.Auth(cfg), rl.ReadWrite())...)
+	api.GET("", h.listSessions)
+	api.DELETE("", h.revokeOtherSessions)
+	api.DELETE("/:id", h.revokeSession)
+}
+
+func (h *handler) current(c echo.Context) string {
+	s, err := gothic.Store.Get(c.Request(), h.name)
+	if err != nil {
+		return ""
+	}
+	return s.ID
+}
+
+func (h *handler) listSessions(c echo.Context) error {
+	ctx := c.Request().Context()
+	res, err := h.registry.List(ctx, middleware.UserClaimFromContext(ctx).ID)
+	if err != nil {
+		return err
+	}
+	current := h.current(c)
+	for i := range res {
+		res[i].Current = res[i].ID == current
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *handler) revokeSession(c echo.Context) error {
+	ctx := c.Request().Context()
+	if err := h.registry.Revoke(ctx, middleware.UserClaimFromContext(ctx).ID, c.Param("id")); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// revokeOtherSessions signs the user out everywhere but here.
+func (h *handler) revokeOtherSessions(c echo.Context) error {
+	ctx := c.Request().Context()
+	n, err := h.registry.RevokeUser(ctx, middleware.UserClaimFromContext(ctx).ID, h.current(c))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{"revoked": n})
+}
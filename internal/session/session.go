@@ -0,0 +1,58 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+
+	"github.com/gorilla/sessions"
+)
+
+// UserIDKey is the session value holding the signed in user, the db store
+// also keeps it in its own column to list the sessions of a user.
+const UserIDKey = "user_id"
+
+// Info describes a stored session without its data.
+type Info struct {
+	ID        string    `json:"id"`
+	UserAgent string    `json:"userAgent"`
+	IPAddress string    `json:"ipAddress"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Current   bool      `json:"current"`
+}
+
+// Registry is implemented by stores keeping sessions on the server.
+type Registry interface {
+	List(ctx context.Context, userID string) ([]Info, error)
+	// Revoke deletes one session of userID.
+	Revoke(ctx context.Context, userID, id string) error
+	// RevokeUser deletes every session of userID except keep.
+	RevokeUser(ctx context.Context, userID, keep string) (int64, error)
+	// Active reports whether r carries a live session of userID.
+	Active(r *http.Request, name, userID string) bool
+}
+
+// Options are the cookie options shared by every store.
+func Options(cfg config.Config) *sessions.Options {
+	return &sessions.Options{
+		Path:     "/",
+		Domain:   cfg.CookieDomain(),
+		MaxAge:   int(cfg.SessionMaxAge().Seconds()),
+		Secure:   cfg.CookieSecure(),
+		HttpOnly: true,
+		SameSite: cfg.CookieSameSite(),
+	}
+}
+
+// NewCookieStore keeps the whole session in the cookie, signed (and
+// encrypted when the key pair has an encryption key) with cfg.SessionKeys.
+func NewCookieStore(cfg config.Config) *sessions.CookieStore {
+	store := sessions.NewCookieStore(cfg.SessionKeys()...)
+	store.Options = Options(cfg)
+	store.MaxAge(store.Options.MaxAge)
+	return store
+}
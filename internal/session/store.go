@@ -0,0 +1,285 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/metrics"
+	"github.com/anousonefs/golang-htmx-template/internal/middleware"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo/v4"
+)
+
+// DBStore keeps the session values in the sessions table, the cookie only
+// holds the signed session ID.
+type DBStore struct {
+	db      *sql.DB
+	sb      squirrel.StatementBuilderType
+	codecs  []securecookie.Codec
+	options *sessions.Options
+	// clientIP is the extractor of the echo server, the IP is recorded
+	// with the session.
+	clientIP echo.IPExtractor
+}
+
+func NewDBStore(db *sql.DB, sb squirrel.StatementBuilderType, cfg config.Config) *DBStore {
+	codecs := securecookie.CodecsFromPairs(cfg.SessionKeys()...)
+	for _, c := range codecs {
+		if sc, ok := c.(*securecookie.SecureCookie); ok {
+			// the values are stored in a text column, not in the cookie.
+			sc.MaxLength(0)
+			sc.MaxAge(int(cfg.SessionMaxAge().Seconds()))
+		}
+	}
+	return &DBStore{db: db, sb: sb, codecs: codecs, options: Options(cfg), clientIP: middleware.IPExtractor(cfg)}
+}
+
+func (s *DBStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session named in the cookie of r, or returns an empty one
+// when there is none or it has expired or been revoked.
+func (s *DBStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var id string
+	if err := securecookie.DecodeMulti(name, c.Value, &id, s.codecs...); err != nil {
+		// a cookie signed with a removed key, start over.
+		return session, nil
+	}
+	data, err := s.load(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+	if err := securecookie.DecodeMulti(name, data, &session.Values, s.codecs...); err != nil {
+		return session, nil
+	}
+	session.ID = id
+	session.IsNew = false
+	return session, nil
+}
+
+// Save writes the session, a negative MaxAge deletes it. Setting ID to ""
+// before saving gives the session a new ID, which login does against
+// session fixation.
+func (s *DBStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	ctx := r.Context()
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.delete(ctx, squirrel.Eq{"id": session.ID}); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.codecs...)
+	if err != nil {
+		return err
+	}
+	userID, _ := session.Values[UserIDKey].(string)
+	expiresAt := time.Now().UTC().Add(time.Duration(session.Options.MaxAge) * time.Second)
+	if session.ID == "" {
+		session.ID = newID()
+		err = s.insert(ctx, session.ID, userID, data, r, expiresAt)
+	} else {
+		err = s.update(ctx, session.ID, userID, data, expiresAt)
+	}
+	if err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+func (s *DBStore) Active(r *http.Request, name, userID string) bool {
+	session, err := s.Get(r, name)
+	if err != nil || session.IsNew {
+		return false
+	}
+	id, _ := session.Values[UserIDKey].(string)
+	return id != "" && id == userID
+}
+
+func newID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(b), "=")
+}
+
+func (s *DBStore) load(ctx context.Context, id string) (string, error) {
+	defer metrics.ObserveQuery("session", "load")()
+	query, args, err := s.sb.
+		Select("data").
+		From("sessions").
+		Where(squirrel.Eq{"id": id}).
+		Where(squirrel.Gt{"expires_at": time.Now().UTC()}).
+		ToSql()
+	if err != nil {
+		return "", err
+	}
+	var data string
+	err = s.db.QueryRowContext(ctx, query, args...).Scan(&data)
+	return data, err
+}
+
+func (s *DBStore) insert(ctx context.Context, id, userID, data string, r *http.Request, expiresAt time.Time) error {
+	defer metrics.ObserveQuery("session", "insert")()
+	now := time.Now().UTC()
+	userAgent := r.UserAgent()
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+	query, args, err := s.sb.
+		Insert("sessions").
+		Columns("id", "user_id", "data", "user_agent", "ip_address", "created_at", "updated_at", "expires_at").
+		Values(id, userID, data, userAgent, s.clientIP(r), now, now, expiresAt).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (s *DBStore) update(ctx context.Context, id, userID, data string, expiresAt time.Time) error {
+	defer metrics.ObserveQuery("session", "update")()
+	query, args, err := s.sb.
+		Update("sessions").
+		Set("user_id", userID).
+		Set("data", data).
+		Set("updated_at", time.Now().UTC()).
+		Set("expires_at", expiresAt).
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (s *DBStore) delete(ctx context.Context, where squirrel.Sqlizer) error {
+	_, err := s.deleteCount(ctx, where)
+	return err
+}
+
+func (s *DBStore) deleteCount(ctx context.Context, where squirrel.Sqlizer) (int64, error) {
+	defer metrics.ObserveQuery("session", "delete")()
+	query, args, err := s.sb.Delete("sessions").Where(where).ToSql()
+	if err != nil {
+		return 0, err
+	}
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *DBStore) List(ctx context.Context, userID string) ([]Info, error) {
+	defer metrics.ObserveQuery("session", "list")()
+	query, args, err := s.sb.
+		Select("id", "user_agent", "ip_address", "created_at", "updated_at", "expires_at").
+		From("sessions").
+		Where(squirrel.Eq{"user_id": userID}).
+		Where(squirrel.Gt{"expires_at": time.Now().UTC()}).
+		OrderBy("updated_at DESC").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []Info{}
+	for rows.Next() {
+		var i Info
+		if err := rows.Scan(&i.ID, &i.UserAgent, &i.IPAddress, &i.CreatedAt, &i.UpdatedAt, &i.ExpiresAt); err != nil {
+			return nil, err
+		}
+		res = append(res, i)
+	}
+	return res, rows.Err()
+}
+
+func (s *DBStore) Revoke(ctx context.Context, userID, id string) error {
+	n, err := s.deleteCount(ctx, squirrel.Eq{"id": id, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return apperror.ErrStatusNotFound
+	}
+	logger.FromContext(ctx).Info("session revoked", "session_user_id", userID)
+	return nil
+}
+
+func (s *DBStore) RevokeUser(ctx context.Context, userID, keep string) (int64, error) {
+	n, err := s.deleteCount(ctx, squirrel.And{
+		squirrel.Eq{"user_id": userID},
+		squirrel.NotEq{"id": keep},
+	})
+	if err != nil {
+		return 0, err
+	}
+	logger.FromContext(ctx).Info("sessions revoked", "session_user_id", userID, "count", n)
+	return n, nil
+}
+
+// DeleteExpired removes the sessions past their expiry, including the
+// anonymous ones the oauth flow leaves behind.
+func (s *DBStore) DeleteExpired(ctx context.Context) (int64, error) {
+	return s.deleteCount(ctx, squirrel.LtOrEq{"expires_at": time.Now().UTC()})
+}
+
+// Cleanup runs DeleteExpired every interval until ctx is done.
+func (s *DBStore) Cleanup(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if n, err := s.DeleteExpired(ctx); err != nil {
+				logger.FromContext(ctx).Error("delete expired sessions", "err", err)
+			} else if n > 0 {
+				logger.FromContext(ctx).Debug("expired sessions deleted", "count", n)
+			}
+		}
+	}
+}
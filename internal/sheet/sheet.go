@@ -0,0 +1,192 @@
+package sheet
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+var Formats = []string{FormatCSV, FormatXLSX}
+
+// MaxRows is the most rows Read accepts, the header included.
+const MaxRows = 10000
+
+var number = regexp.MustCompile(`^[+-]?[0-9][0-9 .]*$`)
+
+var ErrTooManyRows = fmt.Errorf("sheet: more than %d rows", MaxRows)
+
+// FormatOf returns the format of a file from its name, ok is false for the
+// other files.
+func FormatOf(name string) (format string, ok bool) {
+	format = strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+	for _, f := range Formats {
+		if f == format {
+			return format, true
+		}
+	}
+	return "", false
+}
+
+func ContentType(format string) string {
+	if format == FormatXLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Read returns the rows of a CSV file or of the first sheet of a XLSX one,
+// the cells trimmed and the empty rows left out.
+func Read(r io.Reader, format string) ([][]string, error) {
+	var (
+		rows [][]string
+		err  error
+	)
+	switch format {
+	case FormatCSV:
+		rows, err = readCSV(r)
+	case FormatXLSX:
+		rows, err = readXLSX(r)
+	default:
+		return nil, fmt.Errorf("sheet: unknown format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := rows[:0]
+	for _, row := range rows {
+		empty := true
+		for i := range row {
+			row[i] = strings.TrimSpace(row[i])
+			empty = empty && row[i] == ""
+		}
+		if !empty {
+			res = append(res, row)
+		}
+	}
+	if len(res) > MaxRows {
+		return nil, ErrTooManyRows
+	}
+	return res, nil
+}
+
+func readCSV(r io.Reader) ([][]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	var rows [][]string
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 && len(row) > 0 {
+			// Excel saves "CSV UTF-8" with a byte order mark.
+			row[0] = strings.TrimPrefix(row[0], "\ufeff")
+		}
+		if rows = append(rows, row); len(rows) > MaxRows {
+			return nil, ErrTooManyRows
+		}
+	}
+	return rows, nil
+}
+
+func readXLSX(r io.Reader) ([][]string, error) {
+	f, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sheets := f.GetSheetList()
+	if len(sheets) == 0 {
+		return nil, nil
+	}
+	it, err := f.Rows(sheets[0])
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	var rows [][]string
+	for it.Next() {
+		row, err := it.Columns()
+		if err != nil {
+			return nil, err
+		}
+		if rows = append(rows, row); len(rows) > MaxRows {
+			return nil, ErrTooManyRows
+		}
+	}
+	return rows, it.Error()
+}
+
+// Write writes rows as a CSV file or as the single sheet of a XLSX one.
+func Write(w io.Writer, format string, rows [][]string) error {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		for _, row := range rows {
+			if err := cw.Write(escapeRow(row)); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case FormatXLSX:
+		return writeXLSX(w, rows)
+	}
+	return fmt.Errorf("sheet: unknown format %q", format)
+}
+
+func writeXLSX(w io.Writer, rows [][]string) error {
+	f := excelize.NewFile()
+	defer f.Close()
+	sw, err := f.NewStreamWriter("Sheet1")
+	if err != nil {
+		return err
+	}
+	for i, row := range rows {
+		cells := make([]interface{}, len(row))
+		for j, v := range escapeRow(row) {
+			cells[j] = v
+		}
+		cell, err := excelize.CoordinatesToCellName(1, i+1)
+		if err != nil {
+			return err
+		}
+		if err := sw.SetRow(cell, cells); err != nil {
+			return err
+		}
+	}
+	if err := sw.Flush(); err != nil {
+		return err
+	}
+	_, err = f.WriteTo(w)
+	return err
+}
+
+// escapeRow keeps a spreadsheet from running a value as a formula, e.g. a
+// name starting with "=". Signed numbers such as phone numbers are left
+// alone.
+func escapeRow(row []string) []string {
+	res := make([]string, len(row))
+	for i, v := range row {
+		if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) && !number.MatchString(v) {
+			v = "'" + v
+		}
+		res[i] = v
+	}
+	return res
+}
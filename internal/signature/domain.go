@@ -0,0 +1,28 @@
+package signature
+
+import "time"
+
+// Signature is an image a signer recorded. It is the one in force from
+// ValidFrom until ValidTo, nil while it is the current one.
+type Signature struct {
+	ID        int64      `json:"id"`
+	UserID    string     `json:"userID"`
+	Key       string     `json:"key"`
+	SHA256    string     `json:"sha256"`
+	Width     int        `json:"width"`
+	Height    int        `json:"height"`
+	Size      int64      `json:"size"`
+	ValidFrom time.Time  `json:"validFrom"`
+	ValidTo   *time.Time `json:"validTo"`
+	CreatedAt time.Time  `json:"createdAt"`
+	CreatedBy string     `json:"createdBy"`
+}
+
+// Signer is the part of a user the signatures depend on.
+type Signer struct {
+	ID           string `json:"id"`
+	DepartmentID string `json:"departmentID"`
+	IsSigner     bool   `json:"isSigner"`
+	// Signature is the blob key of the current signature.
+	Signature string `json:"signature"`
+}
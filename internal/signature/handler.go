@@ -0,0 +1,248 @@
+package signature
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/event"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/middleware"
+	"github.com/anousonefs/golang-htmx-template/internal/ratelimit"
+	"github.com/anousonefs/golang-htmx-template/internal/tracing"
+
+	"github.com/labstack/echo/v4"
+)
+
+type handler struct {
+	signature *Service
+	authz     *middleware.CasbinMiddleware
+}
+
+func NewHandler(signature *Service, authz *middleware.CasbinMiddleware) *handler {
+	return &handler{signature, authz}
+}
+
+// Install adds the API flagging the signers and reading their signatures,
+// and the page where a signer records their own.
+func (h *handler) Install(e *echo.Echo, cfg config.Config, rl *ratelimit.Limiter) {
+	mws := append(middleware.Auth(cfg), rl.ReadWrite())
+
+	users := e.Group("/api/v1/users", mws...)
+	users.PUT("/:id/signer", h.setSigner, h.authz.Authorize("user", "update"))
+	users.GET("/:id/signature", h.getSignature, h.authz.Authorize("user", "get"))
+	users.GET("/:id/signature/image", h.getSignatureImage, h.authz.Authorize("user", "get"))
+	users.GET("/:id/signatures", h.listSignatures, h.authz.Authorize("user", "get"))
+
+	own := e.Group("/api/v1/signature", mws...)
+	own.GET("", h.getOwnSignature)
+	own.POST("", h.saveOwnSignature, rl.Limit(ratelimit.PolicyUpload))
+	own.GET("/image", h.getOwnSignatureImage)
+	own.GET("/history", h.listOwnSignatures)
+
+	page := e.Group("", mws...)
+	page.GET("/signature", h.signaturePage)
+	page.POST("/signature", h.saveSignaturePage, rl.Limit(ratelimit.PolicyUpload))
+	page.PUT("/users/:id/signer", h.setSignerPage, h.authz.Authorize("user", "update"))
+}
+
+func actorOf(c echo.Context) event.Actor {
+	claim := middleware.UserClaimFromContext(c.Request().Context())
+	return event.Actor{ID: claim.ID, DepartmentID: claim.DepartmentID}
+}
+
+// at reads the ?at= instant, now when it is missing.
+func at(c echo.Context) (time.Time, error) {
+	v := c.QueryParam("at")
+	if v == "" {
+		return time.Now(), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		logger.FromContext(c.Request().Context()).Warn("parse at", "at", v, "err", err)
+		return time.Time{}, apperror.StatusBindingFailure.Err()
+	}
+	return t, nil
+}
+
+// upload reads the image of the request: a multipart "file", or a "data"
+// field holding the data URL of the pad.
+func upload(c echo.Context) ([]byte, error) {
+	if data := c.FormValue("data"); data != "" {
+		_, payload, ok := strings.Cut(data, ";base64,")
+		if !ok || !strings.HasPrefix(data, "data:image/") {
+			return nil, apperror.StatusBindingFailure.Err()
+		}
+		res, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			return nil, apperror.StatusBindingFailure.Err()
+		}
+		return res, nil
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		return nil, apperror.StatusBindingFailure.Err()
+	}
+	f, err := file.Open()
+	if err != nil {
+		return nil, apperror.StatusBindingFailure.Err()
+	}
+	defer f.Close()
+	// one byte over the limit is enough for the validation to refuse it.
+	return io.ReadAll(io.LimitReader(f, MaxUploadSize+1))
+}
+
+// image writes the PNG image of sig.
+func (h *handler) image(c echo.Context, sig Signature) error {
+	// the same URL shows another signature once it changes, the browser
+	// asks again but only downloads a new one.
+	etag := `"` + sig.SHA256 + `"`
+	c.Response().Header().Set(echo.HeaderCacheControl, "private, no-cache")
+	c.Response().Header().Set("ETag", etag)
+	if c.Request().Header.Get("If-None-Match") == etag {
+		return c.NoContent(http.StatusNotModified)
+	}
+	r, err := h.signature.Open(c.Request().Context(), sig)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return c.Stream(http.StatusOK, "image/png", r)
+}
+
+func (h *handler) setSigner(c echo.Context) error {
+	var req struct {
+		IsSigner bool `json:"isSigner"`
+	}
+	if err := c.Bind(&req); err != nil {
+		logger.FromContext(c.Request().Context()).Warn("bind request", "err", err)
+		return apperror.StatusBindingFailure.Err()
+	}
+	if err := h.signature.SetSigner(c.Request().Context(), c.Param("id"), req.IsSigner, actorOf(c)); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *handler) getSignature(c echo.Context) error {
+	t, err := at(c)
+	if err != nil {
+		return err
+	}
+	res, err := h.signature.SignatureAt(c.Request().Context(), c.Param("id"), t)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *handler) getSignatureImage(c echo.Context) error {
+	t, err := at(c)
+	if err != nil {
+		return err
+	}
+	sig, err := h.signature.SignatureAt(c.Request().Context(), c.Param("id"), t)
+	if err != nil {
+		return err
+	}
+	return h.image(c, sig)
+}
+
+func (h *handler) listSignatures(c echo.Context) error {
+	res, err := h.signature.History(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *handler) getOwnSignature(c echo.Context) error {
+	t, err := at(c)
+	if err != nil {
+		return err
+	}
+	res, err := h.signature.SignatureAt(c.Request().Context(), actorOf(c).ID, t)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *handler) saveOwnSignature(c echo.Context) error {
+	data, err := upload(c)
+	if err != nil {
+		return err
+	}
+	actor := actorOf(c)
+	res, err := h.signature.Save(c.Request().Context(), actor.ID, data, actor)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, res)
+}
+
+func (h *handler) getOwnSignatureImage(c echo.Context) error {
+	t, err := at(c)
+	if err != nil {
+		return err
+	}
+	sig, err := h.signature.SignatureAt(c.Request().Context(), actorOf(c).ID, t)
+	if err != nil {
+		return err
+	}
+	return h.image(c, sig)
+}
+
+func (h *handler) listOwnSignatures(c echo.Context) error {
+	res, err := h.signature.History(c.Request().Context(), actorOf(c).ID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// renderSignature renders the signature page of the current user.
+func (h *handler) renderSignature(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := actorOf(c).ID
+	signer, err := h.signature.signer(ctx, userID)
+	if err != nil {
+		return err
+	}
+	history, err := h.signature.History(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return tracing.Component("SignaturePage", SignaturePage(signer, history)).Render(ctx, c.Response().Writer)
+}
+
+func (h *handler) signaturePage(c echo.Context) error {
+	return h.renderSignature(c)
+}
+
+func (h *handler) saveSignaturePage(c echo.Context) error {
+	data, err := upload(c)
+	if err != nil {
+		return err
+	}
+	actor := actorOf(c)
+	if _, err := h.signature.Save(c.Request().Context(), actor.ID, data, actor); err != nil {
+		return err
+	}
+	return h.renderSignature(c)
+}
+
+// setSignerPage flips the signer flag from the users table and renders the
+// toggle again.
+func (h *handler) setSignerPage(c echo.Context) error {
+	ctx := c.Request().Context()
+	isSigner := c.FormValue("isSigner") == "true"
+	if err := h.signature.SetSigner(ctx, c.Param("id"), isSigner, actorOf(c)); err != nil {
+		return err
+	}
+	return tracing.Component("SignerToggle", SignerToggle(c.Param("id"), isSigner)).Render(ctx, c.Response().Writer)
+}
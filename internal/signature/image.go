@@ -0,0 +1,90 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"image/png"
+	"os"
+	"path/filepath"
+
+	"github.com/anousonefs/golang-htmx-template/internal/utils"
+	"github.com/anousonefs/golang-htmx-template/internal/validation"
+
+	"github.com/disintegration/imaging"
+	"github.com/h2non/filetype"
+)
+
+const (
+	// MaxUploadSize is the largest image accepted, before normalization.
+	MaxUploadSize = 2 << 20
+	// maxSide is the longest side of a stored signature in pixels.
+	maxSide = 600
+	// paper is the lightest level kept opaque, the lighter pixels are the
+	// background of a scan or a photo.
+	paper = 0xe0
+)
+
+// image is a normalized signature, ready to be stored.
+type image struct {
+	data   []byte
+	width  int
+	height int
+	sha256 string
+}
+
+// normalize checks data is a PNG or JPEG and turns it into a PNG of at most
+// maxSide pixels with a transparent background, so it can be laid over a
+// document.
+func normalize(data []byte) (res image, err error) {
+	v := validation.New()
+	v.MaxSize("file", int64(len(data)), MaxUploadSize)
+	v.Image("file", filetype.IsMIME(data, "image/png") || filetype.IsMIME(data, "image/jpeg"), "PNG or JPEG")
+	if err := v.Err(); err != nil {
+		return res, err
+	}
+
+	dir, err := os.MkdirTemp("", "signature-")
+	if err != nil {
+		return res, err
+	}
+	defer os.RemoveAll(dir)
+	// imaging picks the decoder from the content and the encoder from the
+	// extension, a JPEG written here comes back as a PNG.
+	path := filepath.Join(dir, "signature.png")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return res, err
+	}
+	src, err := imaging.Open(path)
+	if err != nil {
+		v.Image("file", false, "PNG or JPEG")
+		return res, v.Err()
+	}
+	if b := src.Bounds(); b.Dx() > maxSide || b.Dy() > maxSide {
+		if err := utils.ResizeFile(path, maxSide); err != nil {
+			return res, err
+		}
+		if src, err = imaging.Open(path); err != nil {
+			return res, err
+		}
+	}
+
+	img := imaging.Clone(src)
+	for i := 0; i < len(img.Pix); i += 4 {
+		p := img.Pix[i : i+4 : i+4]
+		if p[0] >= paper && p[1] >= paper && p[2] >= paper {
+			p[3] = 0
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return res, err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return image{
+		data:   buf.Bytes(),
+		width:  img.Rect.Dx(),
+		height: img.Rect.Dy(),
+		sha256: hex.EncodeToString(sum[:]),
+	}, nil
+}
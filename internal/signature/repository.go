@@ -0,0 +1,167 @@
+package signature
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/database"
+	"github.com/anousonefs/golang-htmx-template/internal/metrics"
+
+	"github.com/Masterminds/squirrel"
+)
+
+type Repo struct {
+	db     database.DBTX
+	sb     squirrel.StatementBuilderType
+	driver string
+}
+
+func NewRepo(db *sql.DB, sb squirrel.StatementBuilderType, driver string) *Repo {
+	return &Repo{db: db, sb: sb, driver: driver}
+}
+
+// WithTx returns a copy of the repo that runs its queries on tx.
+func (r Repo) WithTx(tx *sql.Tx) *Repo {
+	return &Repo{db: tx, sb: r.sb, driver: r.driver}
+}
+
+func (r *Repo) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return database.WithTx(ctx, r.db, fn)
+}
+
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// insertID runs the insert q and returns the id of the new row.
+func (r *Repo) insertID(ctx context.Context, q squirrel.InsertBuilder) (int64, error) {
+	if r.driver == config.DriverPostgres {
+		query, args, err := q.Suffix("RETURNING id").ToSql()
+		if err != nil {
+			return 0, err
+		}
+		var id int64
+		err = r.db.QueryRowContext(ctx, query, args...).Scan(&id)
+		return id, err
+	}
+	query, args, err := q.ToSql()
+	if err != nil {
+		return 0, err
+	}
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (r *Repo) exec(ctx context.Context, q squirrel.Sqlizer) (int64, error) {
+	query, args, err := q.ToSql()
+	if err != nil {
+		return 0, err
+	}
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *Repo) getSigner(ctx context.Context, userID string) (Signer, error) {
+	defer metrics.ObserveQuery("signature", "getSigner")()
+	query, args, err := r.sb.
+		Select("id", "department_id", "is_signer", "signature").
+		From("users").
+		Where(squirrel.Eq{"id": userID}).
+		ToSql()
+	if err != nil {
+		return Signer{}, err
+	}
+	var s Signer
+	err = r.db.QueryRowContext(ctx, query, args...).Scan(&s.ID, &s.DepartmentID, &s.IsSigner, &s.Signature)
+	return s, err
+}
+
+// updateSigner sets the signer flag and the current signature of the user.
+func (r *Repo) updateSigner(ctx context.Context, s Signer, updatedBy string) error {
+	defer metrics.ObserveQuery("signature", "updateSigner")()
+	n, err := r.exec(ctx, r.sb.
+		Update("users").
+		SetMap(squirrel.Eq{
+			"is_signer":  s.IsSigner,
+			"signature":  s.Signature,
+			"updated_at": now(),
+			"updated_by": updatedBy,
+		}).
+		Where(squirrel.Eq{"id": s.ID}))
+	if err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+func (r *Repo) createSignature(ctx context.Context, s Signature) (int64, error) {
+	defer metrics.ObserveQuery("signature", "createSignature")()
+	return r.insertID(ctx, r.sb.
+		Insert("signatures").
+		Columns("user_id", "blob_key", "sha256", "width", "height", "size", "valid_from", "created_at", "created_by").
+		Values(s.UserID, s.Key, s.SHA256, s.Width, s.Height, s.Size, s.ValidFrom, s.CreatedAt, s.CreatedBy))
+}
+
+// closeSignature ends the current signature of the user at t.
+func (r *Repo) closeSignature(ctx context.Context, userID string, t time.Time) error {
+	defer metrics.ObserveQuery("signature", "closeSignature")()
+	_, err := r.exec(ctx, r.sb.
+		Update("signatures").
+		Set("valid_to", t).
+		Where(squirrel.Eq{"user_id": userID, "valid_to": nil}))
+	return err
+}
+
+func (r *Repo) listSignatures(ctx context.Context, where squirrel.Sqlizer, limit uint64) ([]Signature, error) {
+	defer metrics.ObserveQuery("signature", "listSignatures")()
+	q := r.sb.
+		Select("id", "user_id", "blob_key", "sha256", "width", "height", "size", "valid_from", "valid_to", "created_at", "created_by").
+		From("signatures").
+		Where(where).
+		OrderBy("valid_from DESC", "id DESC")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	query, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []Signature{}
+	for rows.Next() {
+		var s Signature
+		if err := rows.Scan(&s.ID, &s.UserID, &s.Key, &s.SHA256, &s.Width, &s.Height, &s.Size, &s.ValidFrom, &s.ValidTo, &s.CreatedAt, &s.CreatedBy); err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+	return res, rows.Err()
+}
+
+// signatureAt returns the signature of the user in force at t.
+func (r *Repo) signatureAt(ctx context.Context, userID string, t time.Time) (Signature, error) {
+	res, err := r.listSignatures(ctx, squirrel.And{
+		squirrel.Eq{"user_id": userID},
+		squirrel.LtOrEq{"valid_from": t},
+		squirrel.Or{squirrel.Eq{"valid_to": nil}, squirrel.Gt{"valid_to": t}},
+	}, 1)
+	if err != nil {
+		return Signature{}, err
+	}
+	if len(res) == 0 {
+		return Signature{}, sql.ErrNoRows
+	}
+	return res[0], nil
+}
@@ -0,0 +1,189 @@
+package signature
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/blob"
+	"github.com/anousonefs/golang-htmx-template/internal/event"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/metrics"
+	"github.com/anousonefs/golang-htmx-template/internal/middleware"
+	"github.com/anousonefs/golang-htmx-template/internal/validation"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// Service keeps the signatures of the signers. A new signature closes the
+// previous one instead of replacing it, so SignatureAt still finds the one a
+// document was signed with.
+type Service struct {
+	repo   *Repo
+	blobs  blob.Store
+	events *event.Bus
+}
+
+func NewService(repo *Repo, blobs blob.Store, events *event.Bus) *Service {
+	return &Service{repo: repo, blobs: blobs, events: events}
+}
+
+// logErr logs the unexpected errors of op.
+func logErr(ctx context.Context, op string, err error) {
+	var verr *validation.Error
+	if err == nil ||
+		errors.As(err, &verr) ||
+		errors.Is(err, apperror.ErrStatusNotFound) ||
+		errors.Is(err, apperror.ErrPermissionDenied) {
+		return
+	}
+	logger.FromContext(ctx).Error(op, "err", err)
+}
+
+// signer returns the user, not found when the request may not reach their
+// department.
+func (s *Service) signer(ctx context.Context, userID string) (Signer, error) {
+	res, err := s.repo.getSigner(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Signer{}, apperror.ErrStatusNotFound
+	}
+	if err != nil {
+		return Signer{}, err
+	}
+	if scope, ok := middleware.ScopeFromContext(ctx); ok && !scope.Contains(res.DepartmentID) {
+		return Signer{}, apperror.ErrStatusNotFound
+	}
+	return res, nil
+}
+
+// SetSigner flags the user as a signer or no longer one, which ends their
+// current signature.
+func (s *Service) SetSigner(ctx context.Context, userID string, isSigner bool, actor event.Actor) (err error) {
+	defer func() { logErr(ctx, "signature.SetSigner", err) }()
+	signer, err := s.signer(ctx, userID)
+	if err != nil || signer.IsSigner == isSigner {
+		return err
+	}
+	signer.IsSigner = isSigner
+	return s.repo.inTx(ctx, func(tx *sql.Tx) error {
+		repo := s.repo.WithTx(tx)
+		if !isSigner {
+			if err := repo.closeSignature(ctx, userID, now()); err != nil {
+				return err
+			}
+			signer.Signature = ""
+		}
+		if err := repo.updateSigner(ctx, signer, actor.ID); err != nil {
+			return err
+		}
+		return s.events.Publish(ctx, tx, event.SignerChanged{UserID: userID, IsSigner: isSigner, Actor: actor})
+	})
+}
+
+// Save normalizes data, a PNG or JPEG image, and makes it the current
+// signature of the user. Only the signers have one.
+func (s *Service) Save(ctx context.Context, userID string, data []byte, actor event.Actor) (res Signature, err error) {
+	defer func() { logErr(ctx, "signature.Save", err) }()
+	signer, err := s.signer(ctx, userID)
+	if err != nil {
+		return Signature{}, err
+	}
+	if !signer.IsSigner {
+		return Signature{}, apperror.ErrPermissionDenied
+	}
+	img, err := normalize(data)
+	if err != nil {
+		return Signature{}, err
+	}
+	// the key is the content, the same image signed again is the same blob.
+	key := "signatures/" + userID + "/" + img.sha256 + ".png"
+	if key == signer.Signature {
+		return s.repo.signatureAt(ctx, userID, now())
+	}
+	size := int64(len(img.data))
+	if err := s.blobs.Put(ctx, key, bytes.NewReader(img.data), size, "image/png"); err != nil {
+		return Signature{}, err
+	}
+	metrics.Upload("signature", size)
+
+	t := now()
+	res = Signature{
+		UserID:    userID,
+		Key:       key,
+		SHA256:    img.sha256,
+		Width:     img.width,
+		Height:    img.height,
+		Size:      size,
+		ValidFrom: t,
+		CreatedAt: t,
+		CreatedBy: actor.ID,
+	}
+	err = s.repo.inTx(ctx, func(tx *sql.Tx) error {
+		repo := s.repo.WithTx(tx)
+		if err := repo.closeSignature(ctx, userID, t); err != nil {
+			return err
+		}
+		if res.ID, err = repo.createSignature(ctx, res); err != nil {
+			return err
+		}
+		signer.Signature = key
+		if err := repo.updateSigner(ctx, signer, actor.ID); err != nil {
+			return err
+		}
+		return s.events.Publish(ctx, tx, event.SignatureChanged{
+			UserID:      userID,
+			SignatureID: res.ID,
+			Key:         key,
+			SHA256:      img.sha256,
+			Actor:       actor,
+		})
+	})
+	return res, err
+}
+
+// Current returns the signature the user signs with now.
+func (s *Service) Current(ctx context.Context, userID string) (Signature, error) {
+	return s.SignatureAt(ctx, userID, time.Now())
+}
+
+// SignatureAt returns the signature the user signed with at t, e.g. when
+// the document was approved. It is found after the user stopped being a
+// signer too.
+func (s *Service) SignatureAt(ctx context.Context, userID string, t time.Time) (res Signature, err error) {
+	defer func() { logErr(ctx, "signature.SignatureAt", err) }()
+	if _, err := s.signer(ctx, userID); err != nil {
+		return Signature{}, err
+	}
+	res, err = s.repo.signatureAt(ctx, userID, t.UTC())
+	if errors.Is(err, sql.ErrNoRows) {
+		return Signature{}, apperror.ErrStatusNotFound
+	}
+	return res, err
+}
+
+// History returns the signatures of the user, the latest first.
+func (s *Service) History(ctx context.Context, userID string) (res []Signature, err error) {
+	defer func() { logErr(ctx, "signature.History", err) }()
+	if _, err := s.signer(ctx, userID); err != nil {
+		return []Signature{}, err
+	}
+	res, err = s.repo.listSignatures(ctx, squirrel.Eq{"user_id": userID}, 0)
+	if err != nil {
+		return []Signature{}, err
+	}
+	return res, nil
+}
+
+// Open reads the PNG image of sig, the caller closes it.
+func (s *Service) Open(ctx context.Context, sig Signature) (_ io.ReadCloser, err error) {
+	defer func() { logErr(ctx, "signature.Open", err) }()
+	r, err := s.blobs.Get(ctx, sig.Key)
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil, apperror.ErrStatusNotFound
+	}
+	return r, err
+}
@@ -1,8 +1,12 @@
 package user
 
 import (
+	"log/slog"
 	"time"
 
+	"github.com/anousonefs/golang-htmx-template/internal/middleware"
+	"github.com/anousonefs/golang-htmx-template/internal/validation"
+
 	"github.com/Masterminds/squirrel"
 )
 
@@ -63,6 +67,7 @@ type UserList struct {
 
 type UserDetail struct {
 	ID           string `json:"id"`
+	TenantID     string `json:"tenantID"`
 	DepartmentID string `json:"departmentID"`
 	Role         struct {
 		ID   string `json:"id"`
@@ -80,20 +85,49 @@ type UserDetail struct {
 	CreatedBy string     `json:"-"`
 	UpdatedAt time.Time  `json:"updatedAt"`
 	UpdatedBy string     `json:"updatedBy"`
+	Locale    string     `json:"locale"`
+}
+
+// Active is false once the user has been disabled.
+func (u UserDetail) Active() bool {
+	return u.Status != UserStatusInActive
+}
+
+// LogValue keeps the password out of the logs.
+func (f User) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", f.ID),
+		slog.String("email", f.Email),
+		slog.String("phone", f.Phone),
+		slog.String("roleID", f.RoleID),
+		slog.String("departmentID", f.DepartmentID),
+	)
 }
 
 func (f User) Validate() error {
-	if f.FirstName == "" || f.LastName == "" || f.Password == "" || f.Phone == "" || f.Email == "" {
-		return ErrBadRequest
-	}
-	return nil
+	v := validation.New()
+	v.Required("firstname", f.FirstName)
+	v.Required("lastname", f.LastName)
+	v.Required("password", f.Password)
+	v.MinLength("password", f.Password, 8)
+	v.Required("phone", f.Phone)
+	v.Phone("phone", f.Phone)
+	v.Required("email", f.Email)
+	v.Email("email", f.Email)
+	v.UUID("departmentID", f.DepartmentID)
+	v.UUID("positionID", f.PositionID)
+	validation.Enum(v, "gender", Genders(f.Gender), GendersM, GendersF, GendersO)
+	validation.Enum(v, "status", f.Status, UserStatusActive, UserStatusInActive)
+	return v.Err()
 }
 
 type FilterUser struct {
-	ID       string
-	Username string
-	Email    string
-	Phone    string
+	ID           string
+	Username     string
+	Email        string
+	Phone        string
+	DepartmentID string
+	Status       UserStatus
 }
 
 func (f FilterUser) ToSql() (string, []interface{}, error) {
@@ -110,11 +144,18 @@ func (f FilterUser) ToSql() (string, []interface{}, error) {
 	if f.Email != "" {
 		eq["u.email"] = f.Email
 	}
+	if f.DepartmentID != "" {
+		eq["u.department_id"] = f.DepartmentID
+	}
+	if f.Status != "" {
+		eq["u.status"] = f.Status
+	}
 	return eq.ToSql()
 }
 
 type Role struct {
 	ID        *string   `json:"id"`
+	Code      string    `json:"code"`
 	Name      string    `json:"name"`
 	Status    string    `json:"status"`
 	CreatedAt time.Time `json:"createdAt"`
@@ -129,6 +170,7 @@ type RoleObj struct {
 type ListPermission struct {
 	Domain string `json:"domain"`
 	Action string `json:"action"`
+	Scope  string `json:"scope"`
 }
 
 type PositionObj struct {
@@ -140,6 +182,18 @@ type PositionObj struct {
 type Permission struct {
 	RoleID string   `json:"roleID"`
 	User   []string `json:"user"`
+	// Scope is middleware.ScopeAll when empty.
+	Scope string `json:"scope"`
+}
+
+func (p Permission) Validate() error {
+	v := validation.New()
+	v.Required("roleID", p.RoleID)
+	for _, action := range p.User {
+		validation.Enum(v, "user", action, PermissionColumn[:]...)
+	}
+	validation.Enum(v, "scope", p.Scope, middleware.Scopes...)
+	return v.Err()
 }
 
 type AllPermission struct {
@@ -0,0 +1,61 @@
+package user
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+)
+
+// exportHeader names the columns after the import fields, an export can be
+// imported again once passwords are added.
+var exportHeader = []string{
+	FieldFirstName, FieldLastName, FieldGender, FieldEmail, FieldPhone,
+	FieldRole, FieldDepartment, FieldPosition, "status", "signer", "createdAt",
+}
+
+// codes inverts the ids to codes maps of the references.
+func codes(ids map[string]string) map[string]string {
+	res := make(map[string]string, len(ids))
+	for code, id := range ids {
+		res[id] = code
+	}
+	return res
+}
+
+// ExportUsers returns the users matching filter as the rows of a sheet,
+// the header first. Roles, departments and positions are given by code.
+func (u *Service) ExportUsers(ctx context.Context, filter FilterUser) (res [][]string, err error) {
+	defer func() {
+		if err != nil {
+			logger.FromContext(ctx).Error("user.ExportUsers", "err", err)
+		}
+	}()
+	users, err := u.repo.listUsers(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := u.references(ctx)
+	if err != nil {
+		return nil, err
+	}
+	roles, departments, positions := codes(refs.roles), codes(refs.departments), codes(refs.positions)
+	res = append(make([][]string, 0, len(users)+1), exportHeader)
+	for _, i := range users {
+		res = append(res, []string{
+			i.FirstName,
+			i.LastName,
+			string(i.Gender),
+			i.Email,
+			i.Phone,
+			roles[i.RoleID],
+			departments[i.DepartmentID],
+			positions[i.PositionID],
+			string(i.Status),
+			strconv.FormatBool(i.IsSigner),
+			i.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	return res, nil
+}
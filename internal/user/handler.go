@@ -1,70 +1,110 @@
 package user
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/anousonefs/golang-htmx-template/internal/activity"
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
 	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/event"
+	"github.com/anousonefs/golang-htmx-template/internal/i18n"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/metrics"
 	"github.com/anousonefs/golang-htmx-template/internal/middleware"
-	"github.com/anousonefs/golang-htmx-template/internal/utils"
+	"github.com/anousonefs/golang-htmx-template/internal/ratelimit"
+	"github.com/anousonefs/golang-htmx-template/internal/sheet"
+	"github.com/anousonefs/golang-htmx-template/internal/tracing"
+	"github.com/anousonefs/golang-htmx-template/internal/validation"
 
+	"github.com/a-h/templ"
 	"github.com/labstack/echo/v4"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
-	"github.com/sirupsen/logrus"
-	"google.golang.org/protobuf/encoding/protojson"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type handler struct {
-	user Service
+	user     Service
+	authz    *middleware.CasbinMiddleware
+	importer *Importer
 }
 
-func NewHandler(e *echo.Echo, user Service, cfg config.Config) *handler {
+func NewHandler(e *echo.Echo, user Service, cfg config.Config, authz *middleware.CasbinMiddleware, importer *Importer) *handler {
 	return &handler{
 		user,
+		authz,
+		importer,
 	}
 }
 
-func (h *handler) Install(e *echo.Echo, cfg config.Config) {
-	api := e.Group("/api/v1/users", middleware.Auth(cfg)...)
-	api.POST("", h.createUser)
-	api.GET("", h.listUsers)
-	api.GET("/:id", h.getUser)
-	api.POST("/upload", h.uploadAvatar)
-
-	roles := e.Group("/api/v1/roles", middleware.Auth(cfg)...)
-	roles.GET("", h.listRoles)
-	roles.POST("/:id/permissions", h.createPermission)
-	roles.GET("/:id/permissions", h.listPermission)
-
-	permissions := e.Group("/api/v1/permissions", middleware.Auth(cfg)...)
-	permissions.GET("", h.listAllPermission)
-
-	page := e.Group("", middleware.Auth(cfg)...)
-	page.GET("/users", h.usersPage)
-	page.GET("/add-user", h.addUserPage)
-	page.POST("/users", h.createUserPage)
+func (h *handler) Install(e *echo.Echo, cfg config.Config, rl *ratelimit.Limiter) {
+	mws := append(middleware.Auth(cfg), rl.ReadWrite())
+
+	api := e.Group("/api/v1/users", mws...)
+	api.POST("", h.createUser, h.authz.Authorize("user", "create"))
+	api.GET("", h.listUsers, h.authz.Authorize("user", "list"))
+	api.GET("/:id", h.getUser, h.authz.Authorize("user", "get"))
+	api.POST("/upload", h.uploadAvatar, rl.Limit(ratelimit.PolicyUpload))
+	api.POST("/import", h.importUsers, h.authz.Authorize("user", "create"), rl.Limit(ratelimit.PolicyUpload))
+	api.GET("/export", h.exportUsers, h.authz.Authorize("user", "list"))
+
+	roles := e.Group("/api/v1/roles", mws...)
+	roles.GET("", h.listRoles, h.authz.Authorize("role", "list"))
+	roles.POST("/:id/permissions", h.createPermission, h.authz.Authorize("permission", "create"))
+	roles.GET("/:id/permissions", h.listPermission, h.authz.Authorize("permission", "list"))
+
+	permissions := e.Group("/api/v1/permissions", mws...)
+	permissions.GET("", h.listAllPermission, h.authz.Authorize("permission", "list"))
+
+	page := e.Group("", mws...)
+	page.GET("/users", h.usersPage, h.authz.Authorize("user", "list"))
+	page.GET("/add-user", h.addUserPage, h.authz.Authorize("user", "create"))
+	page.POST("/users", h.createUserPage, h.authz.Authorize("user", "create"))
+	page.GET("/users/export", h.exportUsers, h.authz.Authorize("user", "list"))
+	page.GET("/users/import", h.importPage, h.authz.Authorize("user", "create"))
+	page.POST("/users/import", h.uploadImportPage, h.authz.Authorize("user", "create"), rl.Limit(ratelimit.PolicyUpload))
+	page.GET("/users/import/:id", h.importMappingPage, h.authz.Authorize("user", "create"))
+	page.POST("/users/import/:id/preview", h.previewImportPage, h.authz.Authorize("user", "create"))
+	page.POST("/users/import/:id/apply", h.applyImportPage, h.authz.Authorize("user", "create"))
+	page.GET("/users/import/:id/progress", h.importProgress, h.authz.Authorize("user", "create"))
+	page.POST("/locale", h.setLocale)
+}
+
+func (h *handler) setLocale(c echo.Context) error {
+	ctx := c.Request().Context()
+	lang := c.FormValue("lang")
+	if err := h.user.SetLocale(ctx, middleware.UserClaimFromContext(ctx).ID, lang); err != nil {
+		return err
+	}
+	i18n.SetCookie(c, lang)
+	c.Response().Header().Set("HX-Refresh", "true")
+	return c.NoContent(http.StatusNoContent)
 }
 
 func (h *handler) usersPage(c echo.Context) error {
-	users, err := h.user.ListUsers(c.Request().Context(), FilterUser{})
+	filter := FilterUser{
+		DepartmentID: c.QueryParam("departmentID"),
+		Status:       UserStatus(c.QueryParam("status")),
+	}
+	users, err := h.user.ListUsers(c.Request().Context(), filter)
 	if err != nil {
-		hs := HttpStatusPbFromRPC(GRPCStatusFromErr(err))
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		return err
 	}
-	if err := UserPage(users).Render(c.Request().Context(), c.Response().Writer); err != nil {
+	if err := tracing.Component("UserPage", UserPage(users, filter)).Render(c.Request().Context(), c.Response().Writer); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (h *handler) addUserPage(c echo.Context) error {
-	if err := AddUserPage().Render(c.Request().Context(), c.Response().Writer); err != nil {
+	if err := tracing.Component("AddUserPage", AddUserPage()).Render(c.Request().Context(), c.Response().Writer); err != nil {
 		return err
 	}
 	return nil
@@ -72,53 +112,39 @@ func (h *handler) addUserPage(c echo.Context) error {
 
 func (h *handler) listUsers(c echo.Context) error {
 	ctx := c.Request().Context()
-	filter := FilterUser{
-		ID:       c.QueryParam("id"),
-		Username: c.QueryParam("username"),
-		Phone:    c.QueryParam("phone"),
-	}
+	filter := filterFromQuery(c)
 	res, err := h.user.ListUsers(ctx, filter)
 	if err != nil {
-		hs := HttpStatusPbFromRPC(GRPCStatusFromErr(err))
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		return err
 	}
 	return c.JSON(http.StatusOK, res)
 }
 
 func (h *handler) createUser(c echo.Context) error {
 	var req User
-	var act activity.Activity
 	if err := c.Bind(&req); err != nil {
-		logrus.Errorf("bind: %v\n", err)
-		hs := HttpStatusPbFromRPC(StatusBindingFailure)
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		logger.FromContext(c.Request().Context()).Warn("bind request", "err", err)
+		return apperror.StatusBindingFailure.Err()
 	}
 	if err := req.Validate(); err != nil {
-		hs := HttpStatusPbFromRPC(StatusBadRequest)
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		return err
 	}
 	ctx := c.Request().Context()
-	act.CreatedBy = middleware.UserClaimFromContext(ctx).ID
-	act.DepartmentID = middleware.UserClaimFromContext(ctx).DepartmentID
-	req.CreatedBy = middleware.UserClaimFromContext(ctx).ID
-	if err := h.user.CreateUser(ctx, req, act); err != nil {
-		hs := HttpStatusPbFromRPC(GRPCStatusFromErr(err))
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+	claim := middleware.UserClaimFromContext(ctx)
+	req.CreatedBy = claim.ID
+	actor := event.Actor{ID: claim.ID, DepartmentID: claim.DepartmentID}
+	if err := h.user.CreateUser(ctx, req, actor); err != nil {
+		return err
 	}
 	return c.JSON(http.StatusCreated, echo.Map{"message": "created"})
 }
 
 func (h *handler) createUserPage(c echo.Context) error {
-	var act activity.Activity
 	req := User{
 		Email:        c.FormValue("email"),
 		RoleID:       c.FormValue("roleID"),
-		FirstName:    c.FormValue("firstName"),
-		LastName:     c.FormValue("lastName"),
+		FirstName:    c.FormValue("firstname"),
+		LastName:     c.FormValue("lastname"),
 		Gender:       c.FormValue("gender"),
 		Phone:        c.FormValue("phone"),
 		DepartmentID: c.FormValue("departmentID"),
@@ -126,32 +152,29 @@ func (h *handler) createUserPage(c echo.Context) error {
 		Password:     c.FormValue("password"),
 	}
 	ctx := c.Request().Context()
-	req.CreatedBy = middleware.UserClaimFromContext(ctx).ID
-	fmt.Printf("context: %#v\n", middleware.UserClaimFromContext(ctx))
+	claim := middleware.UserClaimFromContext(ctx)
+	req.CreatedBy = claim.ID
 	if err := req.Validate(); err != nil {
-		logrus.Errorf("req.Validate(): %v", err)
-		hs := HttpStatusPbFromRPC(StatusBadRequest)
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
-	}
-	act.CreatedBy = middleware.UserClaimFromContext(ctx).ID
-	act.DepartmentID = middleware.UserClaimFromContext(ctx).DepartmentID
-	if err := h.user.CreateUser(ctx, req, act); err != nil {
-		hs := HttpStatusPbFromRPC(GRPCStatusFromErr(err))
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		return err
+	}
+	actor := event.Actor{ID: claim.ID, DepartmentID: claim.DepartmentID}
+	if err := h.user.CreateUser(ctx, req, actor); err != nil {
+		return err
 	}
 	return c.NoContent(201)
 }
 
 func (h *handler) getUser(c echo.Context) error {
 	id := c.Param("id")
+	v := validation.New()
+	v.UUID("id", id)
+	if err := v.Err(); err != nil {
+		return err
+	}
 	ctx := c.Request().Context()
 	res, err := h.user.GetUser(ctx, FilterUser{ID: id})
 	if err != nil {
-		hs := HttpStatusPbFromRPC(GRPCStatusFromErr(err))
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		return err
 	}
 	return c.JSON(http.StatusOK, res)
 }
@@ -160,33 +183,25 @@ func (r *handler) uploadAvatar(c echo.Context) error {
 	ctx := c.Request().Context()
 	userID := middleware.UserClaimFromContext(ctx).ID
 	if userID == "" {
-		hs := HttpStatusPbFromRPC(StatusPermissionDenied)
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		return apperror.StatusPermissionDenied.Err()
 	}
 	file, err := c.FormFile("file")
 	if err != nil {
-		hs := HttpStatusPbFromRPC(StatusBindingFailure)
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		return apperror.StatusBindingFailure.Err()
 	}
 
 	bucketName := os.Getenv("MINIO_BUCKET")
 
 	buffer, err := file.Open()
 	if err != nil {
-		hs := HttpStatusPbFromRPC(StatusBindingFailure)
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		return apperror.StatusBindingFailure.Err()
 	}
 	defer buffer.Close()
 
-	minioClient, err := MinioConnection()
+	minioClient, err := MinioConnection(ctx)
 	if err != nil {
-		fmt.Printf("connection error: %v\n", err)
-		hs := HttpStatusPbFromRPC(StatusInternalServerError)
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		logger.FromContext(ctx).Error("minio connection", "err", err)
+		return apperror.StatusInternalServerError.Err()
 	}
 
 	objectName := file.Filename
@@ -194,60 +209,65 @@ func (r *handler) uploadAvatar(c echo.Context) error {
 	contentType := file.Header["Content-Type"][0]
 	fileSize := file.Size
 
-	info, err := minioClient.PutObject(ctx, bucketName, "/avatar/"+objectName, fileBuffer, fileSize, minio.PutObjectOptions{ContentType: contentType, PartSize: partSize})
+	putCtx, span := tracing.Start(ctx, "minio.PutObject",
+		attribute.String("minio.bucket", bucketName),
+		attribute.String("minio.object", "/avatar/"+objectName),
+		attribute.Int64("minio.size", fileSize),
+	)
+	info, err := minioClient.PutObject(putCtx, bucketName, "/avatar/"+objectName, fileBuffer, fileSize, minio.PutObjectOptions{ContentType: contentType, PartSize: partSize})
+	tracing.End(span, err)
 	if err != nil {
-		hs := HttpStatusPbFromRPC(StatusInternalServerError)
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		logger.FromContext(ctx).Error("upload avatar", "object", objectName, "err", err)
+		return apperror.StatusInternalServerError.Err()
 	}
 
-	log.Printf("Successfully uploaded %s of size %d\n", objectName, info.Size)
+	metrics.Upload("avatar", info.Size)
+	logger.FromContext(ctx).Info("avatar uploaded", "object", objectName, "size", info.Size)
 	return c.JSON(http.StatusOK, echo.Map{"message": "uploaded"})
 }
 
-func MinioConnection() (*minio.Client, error) {
-	ctx := context.Background()
+func MinioConnection(ctx context.Context) (_ *minio.Client, err error) {
 	endpoint := os.Getenv("MINIO_ENDPOINT")
 	accessKeyID := os.Getenv("MINIO_ACCESSKEY")
 	secretAccessKey := os.Getenv("MINIO_SECRETKEY")
 	bucketName := os.Getenv("MINIO_BUCKET")
 
-	fmt.Printf("env: %v, %v, %v, %v\n", endpoint, accessKeyID, secretAccessKey, bucketName)
+	log := logger.FromContext(ctx).With("endpoint", endpoint, "bucket", bucketName)
+
+	ctx, span := tracing.Start(ctx, "minio.Connect", attribute.String("minio.bucket", bucketName))
+	defer func() { tracing.End(span, err) }()
 
 	useSSL := false
 	// Initialize minio client object.
-	minioClient, errInit := minio.New(endpoint, &minio.Options{
+	minioClient, err := minio.New(endpoint, &minio.Options{
 		Creds:  credentials.NewStaticV4(accessKeyID, secretAccessKey, ""),
 		Secure: useSSL,
 	})
-	if errInit != nil {
-		log.Fatalln(errInit)
+	if err != nil {
+		return nil, err
 	}
 
 	location := "us-east-1"
 
-	err := minioClient.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{Region: location})
+	err = minioClient.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{Region: location})
 	if err != nil {
 		// Check to see if we already own this bucket (which happens if you run this twice)
 		exists, errBucketExists := minioClient.BucketExists(ctx, bucketName)
-		if errBucketExists == nil && exists {
-			log.Printf("We already own %s\n", bucketName)
-		} else {
-			log.Fatalln(err)
+		if errBucketExists != nil || !exists {
+			return nil, err
 		}
+		log.Debug("minio bucket already exists")
 	} else {
-		log.Printf("Successfully created %s\n", bucketName)
+		log.Info("minio bucket created")
 	}
-	return minioClient, errInit
+	return minioClient, nil
 }
 
 func (r handler) listRoles(c echo.Context) error {
 	ctx := c.Request().Context()
 	res, err := r.user.ListRoles(ctx)
 	if err != nil {
-		hs := HttpStatusPbFromRPC(GRPCStatusFromErr(err))
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		return err
 	}
 	return c.JSON(http.StatusOK, res)
 }
@@ -256,9 +276,7 @@ func (r handler) listAllPermission(c echo.Context) error {
 	ctx := c.Request().Context()
 	res, err := r.user.ListAllPermissions(ctx)
 	if err != nil {
-		hs := HttpStatusPbFromRPC(GRPCStatusFromErr(err))
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		return err
 	}
 	return c.JSON(http.StatusOK, res)
 }
@@ -268,9 +286,7 @@ func (r handler) listPermission(c echo.Context) error {
 	ctx := c.Request().Context()
 	res, err := r.user.ListPermissions(ctx, id)
 	if err != nil {
-		hs := HttpStatusPbFromRPC(GRPCStatusFromErr(err))
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		return err
 	}
 	return c.JSON(http.StatusOK, echo.Map{"permissions": res})
 }
@@ -279,22 +295,16 @@ func (r handler) createPermission(c echo.Context) error {
 	var req Permission
 	var err error
 	if err = c.Bind(&req); err != nil {
-		logrus.Errorf("bind: %v\n", err)
-		hs := HttpStatusPbFromRPC(StatusBindingFailure)
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		logger.FromContext(c.Request().Context()).Warn("bind request", "err", err)
+		return apperror.StatusBindingFailure.Err()
 	}
-	if req.RoleID == "" {
-		hs := HttpStatusPbFromRPC(StatusBindingFailure)
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+	if err := req.Validate(); err != nil {
+		return err
 	}
 	ctx := c.Request().Context()
 	res, err := r.user.CreatePermission(ctx, req)
 	if err != nil {
-		hs := HttpStatusPbFromRPC(GRPCStatusFromErr(err))
-		b, _ := protojson.Marshal(hs)
-		return c.JSONBlob(int(hs.Error.Code), b)
+		return err
 	}
 	return c.JSON(http.StatusOK, res)
 }
@@ -303,14 +313,224 @@ var (
 	PermissionColumn = [5]string{"create", "update", "list", "delete", "get"}
 )
 
-func (r handler) permissionKey(vals []string) bool {
-	if len(vals) > 0 {
-		for i := 0; i < len(vals); i++ {
-			ok, _ := utils.InArray(vals[i], PermissionColumn)
-			if !ok {
-				return false
+// filterFromQuery reads the filter of the user list from the query.
+func filterFromQuery(c echo.Context) FilterUser {
+	return FilterUser{
+		ID:           c.QueryParam("id"),
+		Username:     c.QueryParam("username"),
+		Email:        c.QueryParam("email"),
+		Phone:        c.QueryParam("phone"),
+		DepartmentID: c.QueryParam("departmentID"),
+		Status:       UserStatus(c.QueryParam("status")),
+	}
+}
+
+// exportUsers writes the users of the filter as a CSV or XLSX file.
+func (h *handler) exportUsers(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = sheet.FormatCSV
+	}
+	filter := filterFromQuery(c)
+	v := validation.New()
+	validation.Enum(v, "format", format, sheet.Formats...)
+	validation.Enum(v, "status", filter.Status, UserStatusActive, UserStatusInActive)
+	if err := v.Err(); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	rows, err := h.user.ExportUsers(ctx, filter)
+	if err != nil {
+		return err
+	}
+	name := "users-" + time.Now().Format("20060102") + "." + format
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, sheet.ContentType(format))
+	res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+name+`"`)
+	res.WriteHeader(http.StatusOK)
+	if err := sheet.Write(res, format, rows); err != nil {
+		logger.FromContext(ctx).Error("export users", "format", format, "err", err)
+	}
+	return nil
+}
+
+// importFile reads the file of an import form, its name tells the format.
+func importFile(c echo.Context) (string, []byte, error) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		return "", nil, apperror.StatusBindingFailure.Err()
+	}
+	f, err := file.Open()
+	if err != nil {
+		return "", nil, apperror.StatusBindingFailure.Err()
+	}
+	defer f.Close()
+	// one byte over the limit is enough for the validation to refuse it.
+	data, err := io.ReadAll(io.LimitReader(f, MaxImportSize+1))
+	if err != nil {
+		return "", nil, apperror.StatusBindingFailure.Err()
+	}
+	return file.Filename, data, nil
+}
+
+// mappingFromForm reads the column of each field from the "map.<field>"
+// values, the fields left empty are not imported.
+func mappingFromForm(c echo.Context) Mapping {
+	m := Mapping{}
+	for _, field := range ImportFields {
+		if i, err := strconv.Atoi(c.FormValue("map." + field)); err == nil {
+			m[field] = i
+		}
+	}
+	return m
+}
+
+// importUsers checks the users of a CSV or XLSX file and reports what
+// importing them would do, with dryRun=false the valid rows are imported
+// too. The columns are guessed from the header unless mapped.
+func (h *handler) importUsers(c echo.Context) error {
+	name, data, err := importFile(c)
+	if err != nil {
+		return err
+	}
+	table, err := Parse(name, data)
+	if err != nil {
+		return err
+	}
+	mapping := mappingFromForm(c)
+	if len(mapping) == 0 {
+		mapping = GuessMapping(table[0])
+	}
+	ctx := c.Request().Context()
+	claim := middleware.UserClaimFromContext(ctx)
+	report, err := h.user.DryRun(ctx, table, mapping, claim.ID)
+	if err != nil {
+		return err
+	}
+	if c.QueryParam("dryRun") != "false" {
+		return c.JSON(http.StatusOK, report)
+	}
+	actor := event.Actor{ID: claim.ID, DepartmentID: claim.DepartmentID}
+	res, err := h.user.Import(ctx, report, actor, func(ImportProgress) {})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *handler) importPage(c echo.Context) error {
+	return tracing.Component("ImportPage", ImportPage()).Render(c.Request().Context(), c.Response().Writer)
+}
+
+func (h *handler) uploadImportPage(c echo.Context) error {
+	name, data, err := importFile(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	up, err := h.importer.Upload(ctx, middleware.UserClaimFromContext(ctx).ID, name, data)
+	if err != nil {
+		return err
+	}
+	return tracing.Component("ImportMappingPage", ImportMappingPage(up)).Render(ctx, c.Response().Writer)
+}
+
+func (h *handler) importMappingPage(c echo.Context) error {
+	ctx := c.Request().Context()
+	up, err := h.importer.Get(ctx, middleware.UserClaimFromContext(ctx).ID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return tracing.Component("ImportMappingPage", ImportMappingPage(up)).Render(ctx, c.Response().Writer)
+}
+
+func (h *handler) previewImportPage(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := middleware.UserClaimFromContext(ctx).ID
+	up, err := h.importer.Get(ctx, userID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	up.Mapping = mappingFromForm(c)
+	report, err := h.importer.DryRun(ctx, userID, up.ID, up.Mapping)
+	if err != nil {
+		return err
+	}
+	return tracing.Component("ImportPreviewPage", ImportPreviewPage(up, report)).Render(ctx, c.Response().Writer)
+}
+
+func (h *handler) applyImportPage(c echo.Context) error {
+	ctx := c.Request().Context()
+	claim := middleware.UserClaimFromContext(ctx)
+	actor := event.Actor{ID: claim.ID, DepartmentID: claim.DepartmentID}
+	id := c.Param("id")
+	p, err := h.importer.Start(ctx, id, mappingFromForm(c), actor)
+	if err != nil {
+		return err
+	}
+	return tracing.Component("ImportProgressPage", ImportProgressPage(id, p)).Render(ctx, c.Response().Writer)
+}
+
+// importProgress streams the progress of an import as server sent events,
+// "progress" carries the html of its bar while it runs and "done" the
+// outcome once finished, the stream ends then.
+func (h *handler) importProgress(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := middleware.UserClaimFromContext(ctx).ID
+	id := c.Param("id")
+	p, err := h.importer.Progress(userID, id)
+	if err != nil {
+		return err
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	t := time.NewTicker(importPoll)
+	defer t.Stop()
+	last := -1
+	for {
+		if p.Finished {
+			_ = send(ctx, c, "done", ImportDone(p))
+			return nil
+		}
+		if p.Done != last {
+			last = p.Done
+			if err := send(ctx, c, "progress", ImportProgressBar(p)); err != nil {
+				return nil
 			}
 		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+		if p, err = h.importer.Progress(userID, id); err != nil {
+			return nil
+		}
 	}
-	return true
+}
+
+// send writes comp as the data of an event.
+func send(ctx context.Context, c echo.Context, event string, comp templ.Component) error {
+	var buf bytes.Buffer
+	if err := comp.Render(ctx, &buf); err != nil {
+		return err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "event: %s\n", event)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	res := c.Response()
+	if _, err := res.Write([]byte(b.String())); err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
 }
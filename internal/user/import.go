@@ -0,0 +1,403 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/event"
+	"github.com/anousonefs/golang-htmx-template/internal/i18n"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/middleware"
+	"github.com/anousonefs/golang-htmx-template/internal/utils"
+	"github.com/anousonefs/golang-htmx-template/internal/validation"
+)
+
+// importChunk is the number of users created in one transaction, a failing
+// row only rolls its chunk back.
+const importChunk = 100
+
+// The fields of a user an import column can fill. Role, department and
+// position are given by code, or by id.
+const (
+	FieldFirstName  = "firstname"
+	FieldLastName   = "lastname"
+	FieldGender     = "gender"
+	FieldEmail      = "email"
+	FieldPhone      = "phone"
+	FieldPassword   = "password"
+	FieldRole       = "role"
+	FieldDepartment = "department"
+	FieldPosition   = "position"
+)
+
+var ImportFields = []string{
+	FieldFirstName, FieldLastName, FieldGender, FieldEmail, FieldPhone,
+	FieldPassword, FieldRole, FieldDepartment, FieldPosition,
+}
+
+// requiredFields must be mapped to a column.
+var requiredFields = []string{FieldFirstName, FieldLastName, FieldEmail, FieldPhone, FieldPassword}
+
+// fieldAliases are the headers GuessMapping recognizes besides the field
+// names, compared without case, spaces, "_" and "-".
+var fieldAliases = map[string][]string{
+	FieldFirstName:  {"givenname", "name"},
+	FieldLastName:   {"surname", "familyname"},
+	FieldGender:     {"sex"},
+	FieldEmail:      {"mail", "emailaddress"},
+	FieldPhone:      {"phonenumber", "mobile", "tel"},
+	FieldRole:       {"rolecode", "roleid"},
+	FieldDepartment: {"departmentcode", "departmentid", "dept"},
+	FieldPosition:   {"positioncode", "positionid", "title"},
+}
+
+// Mapping tells the column of the file each field is read from, the
+// fields left out stay empty.
+type Mapping map[string]int
+
+func normalizeHeader(s string) string {
+	return strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(strings.TrimSpace(s)))
+}
+
+// GuessMapping maps the fields to the columns of header named after them.
+func GuessMapping(header []string) Mapping {
+	res := Mapping{}
+	for i, h := range header {
+		h = normalizeHeader(h)
+		for _, f := range ImportFields {
+			if _, ok := res[f]; ok {
+				continue
+			}
+			if h == f || utils.ContainsString(fieldAliases[f], h) {
+				res[f] = i
+				break
+			}
+		}
+	}
+	return res
+}
+
+// Validate checks the required fields are mapped to a column of header.
+func (m Mapping) Validate(header []string) error {
+	v := validation.New()
+	for _, f := range requiredFields {
+		if i, ok := m[f]; !ok || i < 0 || i >= len(header) {
+			v.Required(f, "")
+		}
+	}
+	return v.Err()
+}
+
+func (m Mapping) value(row []string, field string) string {
+	i, ok := m[field]
+	if !ok || i < 0 || i >= len(row) {
+		return ""
+	}
+	return row[i]
+}
+
+// Problem is why a row cannot be imported, Field is empty when it is not
+// about one of them.
+type Problem struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// problemsOf turns err into problems described in the language of ctx.
+func problemsOf(ctx context.Context, field string, err error) []Problem {
+	lang := i18n.Lang(ctx)
+	s := apperror.Localize(apperror.StatusFromErr(err, lang), lang)
+	if fvs := apperror.FieldViolations(s); len(fvs) > 0 {
+		res := make([]Problem, len(fvs))
+		for i, fv := range fvs {
+			res[i] = Problem{Field: fv.GetField(), Message: fv.GetDescription()}
+		}
+		return res
+	}
+	return []Problem{{Field: field, Message: apperror.LocalizedMessage(s)}}
+}
+
+// ImportRow is a line of the file and the user read from it.
+type ImportRow struct {
+	Line      int       `json:"line"`
+	FirstName string    `json:"firstname"`
+	LastName  string    `json:"lastname"`
+	Email     string    `json:"email"`
+	Phone     string    `json:"phone"`
+	Problems  []Problem `json:"problems"`
+	user      User
+}
+
+func (r ImportRow) Valid() bool {
+	return len(r.Problems) == 0
+}
+
+// ImportReport is the outcome of a dry run, only the valid rows are
+// imported.
+type ImportReport struct {
+	Total   int         `json:"total"`
+	Valid   int         `json:"valid"`
+	Invalid int         `json:"invalid"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+// ImportProgress tells how far an import went.
+type ImportProgress struct {
+	Total    int      `json:"total"`
+	Done     int      `json:"done"`
+	Created  int      `json:"created"`
+	Failed   int      `json:"failed"`
+	Errors   []string `json:"errors"`
+	Finished bool     `json:"finished"`
+}
+
+// Percent is the share of the rows done, 100 for an empty import.
+func (p ImportProgress) Percent() int {
+	if p.Total == 0 {
+		return 100
+	}
+	return p.Done * 100 / p.Total
+}
+
+// references resolves the codes and ids of the roles, departments and
+// positions a row may name.
+type references struct {
+	roles, departments, positions map[string]string
+}
+
+func (u *Service) references(ctx context.Context) (references, error) {
+	roles, err := u.repo.listRoles(ctx)
+	if err != nil {
+		return references{}, err
+	}
+	res := references{roles: map[string]string{}}
+	for _, r := range roles {
+		if r.ID != nil {
+			res.roles[r.Code] = *r.ID
+		}
+	}
+	if res.departments, res.positions, err = u.directory.Codes(ctx); err != nil {
+		return references{}, err
+	}
+	return res, nil
+}
+
+// resolve returns the id named by value, its code or the id itself.
+func resolve(ids map[string]string, value string) (string, bool) {
+	if value == "" {
+		return "", true
+	}
+	if id, ok := ids[value]; ok {
+		return id, true
+	}
+	for _, id := range ids {
+		if id == value {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// DryRun reads the users of the rows of a file, the first one being its
+// header, and reports the rows that could not be imported: invalid fields,
+// unknown references, departments out of the caller's scope and emails or
+// phones taken by another user or another row.
+func (u *Service) DryRun(ctx context.Context, table [][]string, mapping Mapping, createdBy string) (res ImportReport, err error) {
+	defer func() {
+		var verr *validation.Error
+		if err != nil && !errors.As(err, &verr) {
+			logger.FromContext(ctx).Error("user.DryRun", "err", err)
+		}
+	}()
+	if len(table) == 0 {
+		return ImportReport{Rows: []ImportRow{}}, nil
+	}
+	if err := mapping.Validate(table[0]); err != nil {
+		return ImportReport{}, err
+	}
+	refs, err := u.references(ctx)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	rows := table[1:]
+	var emails, phones []string
+	for _, row := range rows {
+		emails = append(emails, mapping.value(row, FieldEmail))
+		phones = append(phones, mapping.value(row, FieldPhone))
+	}
+	takenEmails, takenPhones, err := u.repo.takenContacts(ctx, emails, phones)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	scope, scoped := middleware.ScopeFromContext(ctx)
+	// the role check CreateUser runs, once per role.
+	e, err := u.repo.enforcer()
+	if err != nil {
+		return ImportReport{}, err
+	}
+	caller := middleware.UserClaimFromContext(ctx).RoleID
+	exceeding := map[string]bool{}
+
+	res = ImportReport{Total: len(rows), Rows: make([]ImportRow, 0, len(rows))}
+	seenEmails, seenPhones := map[string]bool{}, map[string]bool{}
+	for i, row := range rows {
+		user := User{
+			FirstName: mapping.value(row, FieldFirstName),
+			LastName:  mapping.value(row, FieldLastName),
+			Gender:    strings.ToUpper(mapping.value(row, FieldGender)),
+			Email:     mapping.value(row, FieldEmail),
+			Phone:     mapping.value(row, FieldPhone),
+			Password:  mapping.value(row, FieldPassword),
+			Status:    UserStatusActive,
+			CreatedBy: createdBy,
+		}
+		if user.Gender == "" {
+			user.Gender = string(GendersO)
+		}
+		r := ImportRow{
+			// the header is line 1.
+			Line:      i + 2,
+			FirstName: user.FirstName,
+			LastName:  user.LastName,
+			Email:     user.Email,
+			Phone:     user.Phone,
+		}
+		if err := user.Validate(); err != nil {
+			r.Problems = append(r.Problems, problemsOf(ctx, "", err)...)
+		}
+		v := validation.New()
+		var ok bool
+		role, department, position := mapping.value(row, FieldRole), mapping.value(row, FieldDepartment), mapping.value(row, FieldPosition)
+		user.RoleID, ok = resolve(refs.roles, role)
+		v.Exists(FieldRole, role, ok)
+		user.DepartmentID, ok = resolve(refs.departments, department)
+		v.Exists(FieldDepartment, department, ok)
+		user.PositionID, ok = resolve(refs.positions, position)
+		v.Exists(FieldPosition, position, ok)
+		if err := v.Err(); err != nil {
+			r.Problems = append(r.Problems, problemsOf(ctx, "", err)...)
+		} else if err := u.directory.CheckAssignment(ctx, user.DepartmentID, user.PositionID); err != nil {
+			// the check CreateUser runs, on the departmentID and positionID
+			// fields.
+			var verr *validation.Error
+			if !errors.As(err, &verr) {
+				return ImportReport{}, err
+			}
+			for _, p := range problemsOf(ctx, "", err) {
+				p.Field = strings.TrimSuffix(p.Field, "ID")
+				r.Problems = append(r.Problems, p)
+			}
+		} else if scoped && !scope.Contains(user.DepartmentID) {
+			r.Problems = append(r.Problems, problemsOf(ctx, FieldDepartment, apperror.ErrPermissionDenied)...)
+		}
+		exceeds, checked := exceeding[user.RoleID]
+		if !checked {
+			if exceeds, err = exceedsRole(e, caller, user.RoleID); err != nil {
+				return ImportReport{}, err
+			}
+			exceeding[user.RoleID] = exceeds
+		}
+		if exceeds {
+			r.Problems = append(r.Problems, problemsOf(ctx, FieldRole, apperror.ErrPermissionDenied)...)
+		}
+		if user.Email != "" && (takenEmails[user.Email] || seenEmails[user.Email]) {
+			r.Problems = append(r.Problems, problemsOf(ctx, FieldEmail, apperror.ErrEmailAlreadyExist)...)
+		}
+		if user.Phone != "" && (takenPhones[user.Phone] || seenPhones[user.Phone]) {
+			r.Problems = append(r.Problems, problemsOf(ctx, FieldPhone, apperror.ErrPhoneAlreadyExist)...)
+		}
+		seenEmails[user.Email], seenPhones[user.Phone] = true, true
+
+		r.user = user
+		if r.Valid() {
+			res.Valid++
+		} else {
+			res.Invalid++
+		}
+		res.Rows = append(res.Rows, r)
+	}
+	return res, nil
+}
+
+// Import creates the users of the valid rows of report, importChunk of them
+// per transaction. A chunk failing, e.g. on an email taken since the dry
+// run, is rolled back and counted as failed, the next ones still run.
+// progress is called after each chunk.
+func (u *Service) Import(ctx context.Context, report ImportReport, actor event.Actor, progress func(ImportProgress)) (res ImportProgress, err error) {
+	defer func() {
+		if err != nil {
+			logger.FromContext(ctx).Error("user.Import", "err", err)
+		}
+	}()
+	var users []User
+	var lines []int
+	for _, r := range report.Rows {
+		if r.Valid() {
+			users = append(users, r.user)
+			lines = append(lines, r.Line)
+		}
+	}
+	res = ImportProgress{Total: len(users), Errors: []string{}}
+	for start := 0; start < len(users); start += importChunk {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		chunk := users[start:min(start+importChunk, len(users))]
+		// hashing is slow, it is done before the transaction starts.
+		for i := range chunk {
+			if chunk[i].Password, err = utils.HashPassword(chunk[i].Password); err != nil {
+				return res, err
+			}
+		}
+		err := u.repo.inTx(ctx, func(tx *sql.Tx) error {
+			repo := u.repo.withTx(tx)
+			for i, user := range chunk {
+				if err := repo.createUser(ctx, user); err != nil {
+					return fmt.Errorf("line %d: %w", lines[start+i], duplicateErr(err))
+				}
+				created, err := repo.getUser(ctx, FilterUser{Email: user.Email})
+				if err != nil {
+					return err
+				}
+				if err := u.events.Publish(ctx, tx, event.UserCreated{
+					UserID:       created.ID,
+					Email:        user.Email,
+					FirstName:    user.FirstName,
+					LastName:     user.LastName,
+					RoleID:       user.RoleID,
+					DepartmentID: user.DepartmentID,
+					PositionID:   user.PositionID,
+					Actor:        actor,
+				}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		res.Done += len(chunk)
+		if err != nil {
+			logger.FromContext(ctx).Warn("user import chunk failed", "from", lines[start], "size", len(chunk), "err", err)
+			res.Failed += len(chunk)
+			res.Errors = append(res.Errors, chunkError(ctx, lines[start], lines[start+len(chunk)-1], err))
+		} else {
+			res.Created += len(chunk)
+		}
+		progress(res)
+	}
+	res.Finished = true
+	progress(res)
+	return res, nil
+}
+
+// chunkError describes the failure of the chunk of the lines from to to.
+func chunkError(ctx context.Context, from, to int, err error) string {
+	var msgs []string
+	for _, p := range problemsOf(ctx, "", err) {
+		msgs = append(msgs, strings.TrimSpace(p.Field+" "+p.Message))
+	}
+	return i18n.T(ctx, "import.chunk_failed", from, to, strings.Join(msgs, ", "))
+}
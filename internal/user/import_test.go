@@ -0,0 +1,36 @@
+package user
+
+import "testing"
+
+func TestDryRunRole(t *testing.T) {
+	s := newTestService(t)
+	table := [][]string{
+		{"First name", "Last name", "Email", "Phone", "Password", "Role", "Department"},
+		{"Ann", "Admin", "ann@example.com", "2055500001", "secret123", "ADMIN", "HQ"},
+		{"Mo", "Manager", "mo@example.com", "2055500002", "secret123", "MANAGER", "HQ"},
+		{"Sam", "Staff", "sam@example.com", "2055500003", "secret123", "STAFF", "HQ"},
+		{"Nia", "Nobody", "nia@example.com", "2055500004", "secret123", "", "HQ"},
+	}
+	mapping := Mapping{
+		FieldFirstName:  0,
+		FieldLastName:   1,
+		FieldEmail:      2,
+		FieldPhone:      3,
+		FieldPassword:   4,
+		FieldRole:       5,
+		FieldDepartment: 6,
+	}
+	report, err := s.DryRun(asManager(), table, mapping, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// staff lists the users of every department, the manager only theirs.
+	if report.Valid != 2 || report.Invalid != 2 {
+		t.Fatalf("got %d valid and %d invalid rows, want 2 and 2: %+v", report.Valid, report.Invalid, report.Rows)
+	}
+	for _, r := range []ImportRow{report.Rows[0], report.Rows[2]} {
+		if len(r.Problems) != 1 || r.Problems[0].Field != FieldRole {
+			t.Fatalf("line %d: got problems %+v, want one on %s", r.Line, r.Problems, FieldRole)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/blob"
+	"github.com/anousonefs/golang-htmx-template/internal/event"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/sheet"
+	"github.com/anousonefs/golang-htmx-template/internal/validation"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// MaxImportSize is the largest file accepted for an import.
+	MaxImportSize = 5 << 20
+	// importRetention is how long the progress of a finished import is
+	// kept for its page.
+	importRetention = time.Hour
+	// importPoll is how often the progress page looks at an import.
+	importPoll = 300 * time.Millisecond
+)
+
+// importIDs are the ids given to the uploads, a uuid and the format.
+var importIDs = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\.(csv|xlsx)$`)
+
+// Upload is a file waiting to be imported.
+type Upload struct {
+	ID      string     `json:"id"`
+	Header  []string   `json:"header"`
+	Sample  [][]string `json:"sample"`
+	Rows    int        `json:"rows"`
+	Mapping Mapping    `json:"mapping"`
+}
+
+// Importer runs the import wizard: the uploaded file waits in the blob
+// store between its steps, then the import runs in the background while the
+// page follows its progress. The progress is kept in memory, an import only
+// runs on the instance it was started on.
+type Importer struct {
+	user  *Service
+	blobs blob.Store
+
+	mu   sync.Mutex
+	runs map[string]*importRun
+}
+
+func NewImporter(user *Service, blobs blob.Store) *Importer {
+	return &Importer{user: user, blobs: blobs, runs: map[string]*importRun{}}
+}
+
+type importRun struct {
+	userID string
+
+	mu       sync.Mutex
+	progress ImportProgress
+	updated  time.Time
+}
+
+func (r *importRun) set(p ImportProgress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.progress = p
+	r.updated = time.Now()
+}
+
+func (r *importRun) get() (ImportProgress, time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.progress, r.updated
+}
+
+// importKey is where the upload id of the user is stored, under their id so
+// no one else can reach it.
+func importKey(userID, id string) string {
+	return "imports/" + userID + "/" + id
+}
+
+// Upload checks data is a sheet with a header and rows, and keeps it for
+// the next steps. name tells its format.
+func (im *Importer) Upload(ctx context.Context, userID, name string, data []byte) (res Upload, err error) {
+	defer func() {
+		var verr *validation.Error
+		if err != nil && !errors.As(err, &verr) {
+			logger.FromContext(ctx).Error("user.Upload", "err", err)
+		}
+	}()
+	table, err := Parse(name, data)
+	if err != nil {
+		return Upload{}, err
+	}
+	format, _ := sheet.FormatOf(name)
+	res = Upload{ID: uuid.NewString() + "." + format}
+	if err := im.blobs.Put(ctx, importKey(userID, res.ID), bytes.NewReader(data), int64(len(data)), sheet.ContentType(format)); err != nil {
+		return Upload{}, err
+	}
+	return uploadOf(res.ID, table), nil
+}
+
+// Parse reads the rows of the CSV or XLSX file name, there must be at least
+// one under its header.
+func Parse(name string, data []byte) ([][]string, error) {
+	v := validation.New()
+	v.MaxSize("file", int64(len(data)), MaxImportSize)
+	format, ok := sheet.FormatOf(name)
+	v.FileType("file", ok, "CSV or XLSX")
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+	return readTable(data, format)
+}
+
+// readTable reads a sheet with at least one row under its header.
+func readTable(data []byte, format string) ([][]string, error) {
+	v := validation.New()
+	table, err := sheet.Read(bytes.NewReader(data), format)
+	switch {
+	case errors.Is(err, sheet.ErrTooManyRows):
+		v.MaxRows("file", sheet.MaxRows+1, sheet.MaxRows)
+	case err != nil:
+		v.FileType("file", false, "CSV or XLSX")
+	case len(table) < 2:
+		v.Required("file", "")
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+func uploadOf(id string, table [][]string) Upload {
+	return Upload{
+		ID:      id,
+		Header:  table[0],
+		Sample:  table[1:min(4, len(table))],
+		Rows:    len(table) - 1,
+		Mapping: GuessMapping(table[0]),
+	}
+}
+
+// load reads the upload id of the user.
+func (im *Importer) load(ctx context.Context, userID, id string) ([][]string, error) {
+	if !importIDs.MatchString(id) {
+		return nil, apperror.ErrStatusNotFound
+	}
+	r, err := im.blobs.Get(ctx, importKey(userID, id))
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil, apperror.ErrStatusNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	format, _ := sheet.FormatOf(id)
+	return readTable(buf.Bytes(), format)
+}
+
+// Get returns the upload id of the user.
+func (im *Importer) Get(ctx context.Context, userID, id string) (Upload, error) {
+	table, err := im.load(ctx, userID, id)
+	if err != nil {
+		return Upload{}, err
+	}
+	return uploadOf(id, table), nil
+}
+
+// DryRun reports what importing the upload id with mapping would do.
+func (im *Importer) DryRun(ctx context.Context, userID, id string, mapping Mapping) (ImportReport, error) {
+	table, err := im.load(ctx, userID, id)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	return im.user.DryRun(ctx, table, mapping, userID)
+}
+
+// Start imports the valid rows of the upload id in the background, the
+// upload is deleted once done. Starting it again while it runs returns its
+// progress instead.
+func (im *Importer) Start(ctx context.Context, id string, mapping Mapping, actor event.Actor) (ImportProgress, error) {
+	run, started := im.claim(id, actor.ID)
+	if run == nil {
+		return ImportProgress{}, apperror.ErrStatusNotFound
+	}
+	if started {
+		p, _ := run.get()
+		return p, nil
+	}
+
+	// the dry run reads the upload and the database, outside of im.mu so
+	// the progress of the other imports can be read meanwhile.
+	report, err := im.DryRun(ctx, actor.ID, id, mapping)
+	if err != nil {
+		im.mu.Lock()
+		delete(im.runs, id)
+		im.mu.Unlock()
+		return ImportProgress{}, err
+	}
+	run.set(ImportProgress{Total: report.Valid, Errors: []string{}})
+	// the import outlives the request starting it, with its values.
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		p, err := im.user.Import(ctx, report, actor, run.set)
+		if err != nil {
+			p.Errors = append(p.Errors, err.Error())
+			p.Finished = true
+			run.set(p)
+		}
+		if err := im.blobs.Delete(ctx, importKey(actor.ID, id)); err != nil {
+			logger.FromContext(ctx).Error("delete import upload", "id", id, "err", err)
+		}
+	}()
+	p, _ := run.get()
+	return p, nil
+}
+
+// claim returns the run of the import id for the user, started is true when
+// it already existed. It is nil when the id is another user's.
+func (im *Importer) claim(id, userID string) (run *importRun, started bool) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	for k, r := range im.runs {
+		if p, updated := r.get(); p.Finished && time.Since(updated) > importRetention {
+			delete(im.runs, k)
+		}
+	}
+	if r, ok := im.runs[id]; ok {
+		if r.userID != userID {
+			return nil, false
+		}
+		return r, true
+	}
+	run = &importRun{userID: userID}
+	run.set(ImportProgress{Errors: []string{}})
+	im.runs[id] = run
+	return run, false
+}
+
+// Progress returns the progress of the import id started by the user.
+func (im *Importer) Progress(userID, id string) (ImportProgress, error) {
+	im.mu.Lock()
+	run, ok := im.runs[id]
+	im.mu.Unlock()
+	if !ok || run.userID != userID {
+		return ImportProgress{}, apperror.ErrStatusNotFound
+	}
+	p, _ := run.get()
+	return p, nil
+}
@@ -2,35 +2,106 @@ package user
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 
-	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/database"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/metrics"
 	"github.com/anousonefs/golang-htmx-template/internal/middleware"
+	"github.com/anousonefs/golang-htmx-template/internal/rbac"
 
+	"github.com/Masterminds/squirrel"
 	"github.com/casbin/casbin/v2"
-	"github.com/casbin/casbin/v2/model"
-	casbinpgadapter "github.com/cychiuae/casbin-pg-adapter"
 )
 
 type Repo struct {
-	db      *sql.DB
+	db      database.DBTX
+	sb      squirrel.StatementBuilderType
 	authz   *middleware.CasbinMiddleware
-	adapter *casbinpgadapter.Adapter
+	adapter *rbac.Adapter
 	model   string
 }
 
-func NewRepo(db *sql.DB, model string, adapter *casbinpgadapter.Adapter, authz *middleware.CasbinMiddleware) *Repo {
+func NewRepo(db *sql.DB, sb squirrel.StatementBuilderType, model string, adapter *rbac.Adapter, authz *middleware.CasbinMiddleware) *Repo {
 	return &Repo{
 		db:      db,
+		sb:      sb,
 		adapter: adapter,
 		model:   model,
 		authz:   authz,
 	}
 }
 
+// withTx returns a copy of the repo whose queries and casbin writes run on tx.
+func (r *Repo) withTx(tx *sql.Tx) *Repo {
+	return &Repo{
+		db:      tx,
+		sb:      r.sb,
+		adapter: r.adapter.WithTx(tx),
+		model:   r.model,
+		authz:   r.authz,
+	}
+}
+
+func (r *Repo) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return database.WithTx(ctx, r.db, fn)
+}
+
+// reloadPolicy refreshes the middleware enforcer. Call it after the
+// transaction that changed the policy has committed.
+func (r *Repo) reloadPolicy() {
+	r.authz.ReloadEnforcer(r.model, r.adapter)
+}
+
+// policyVersion is a digest of the rules of the permissions table, it
+// changes with any of them whichever process wrote it.
+func (r *Repo) policyVersion(ctx context.Context) (string, error) {
+	defer metrics.ObserveQuery("user", "policyVersion")()
+	cols := []string{"p_type", "v0", "v1", "v2", "v3", "v4", "v5"}
+	query, args, err := r.sb.Select(cols...).From("permissions").OrderBy(cols...).ToSql()
+	if err != nil {
+		return "", err
+	}
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	h := sha256.New()
+	rule := make([]string, len(cols))
+	dest := make([]any, len(cols))
+	for i := range rule {
+		dest[i] = &rule[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%q\n", rule)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// scoped limits the users to the departments of the request scope, the
+// ones the permission checked by middleware.Authorize reaches.
+func scoped(ctx context.Context) squirrel.Sqlizer {
+	s, ok := middleware.ScopeFromContext(ctx)
+	if !ok || s.All {
+		return nil
+	}
+	return squirrel.Eq{"u.department_id": s.Departments}
+}
+
 func (r Repo) listUsers(ctx context.Context, filter FilterUser) ([]UserList, error) {
-	query, args := config.Psql().
+	defer metrics.ObserveQuery("user", "listUsers")()
+	query, args := r.sb.
 		Select(
 			"u.id",
 			"u.first_name",
@@ -53,8 +124,9 @@ func (r Repo) listUsers(ctx context.Context, filter FilterUser) ([]UserList, err
 		).From("users u").
 		LeftJoin("roles r ON r.id = u.role_id").
 		Where(filter).
+		Where(scoped(ctx)).
 		MustSql()
-	fmt.Printf("query: %v\n", query)
+	logger.FromContext(ctx).Debug("user query", "sql", query)
 
 	rows, err := r.db.QueryContext(ctx, query, args...)
 	defer rows.Close()
@@ -92,7 +164,8 @@ func (r Repo) listUsers(ctx context.Context, filter FilterUser) ([]UserList, err
 }
 
 func (r Repo) createUser(ctx context.Context, req User) error {
-	query, args, err := config.Psql().
+	defer metrics.ObserveQuery("user", "createUser")()
+	query, args, err := r.sb.
 		Insert("users").
 		Columns(
 			"role_id",
@@ -133,12 +206,51 @@ func (r Repo) createUser(ctx context.Context, req User) error {
 	return nil
 }
 
+// takenContacts returns the given emails and phones some user already has,
+// whatever their department.
+func (r Repo) takenContacts(ctx context.Context, emails, phones []string) (takenEmails, takenPhones map[string]bool, err error) {
+	defer metrics.ObserveQuery("user", "takenContacts")()
+	takenEmails, takenPhones = map[string]bool{}, map[string]bool{}
+	// a few hundred values at a time stays under the bind limits.
+	const batch = 500
+	for len(emails) > 0 || len(phones) > 0 {
+		e, p := emails[:min(batch, len(emails))], phones[:min(batch, len(phones))]
+		emails, phones = emails[len(e):], phones[len(p):]
+		query, args, err := r.sb.
+			Select("email", "phone").
+			From("users").
+			Where(squirrel.Or{squirrel.Eq{"email": e}, squirrel.Eq{"phone": p}}).
+			ToSql()
+		if err != nil {
+			return nil, nil, err
+		}
+		rows, err := r.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, nil, err
+		}
+		for rows.Next() {
+			var email, phone string
+			if err := rows.Scan(&email, &phone); err != nil {
+				rows.Close()
+				return nil, nil, err
+			}
+			takenEmails[email], takenPhones[phone] = true, true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return takenEmails, takenPhones, nil
+}
+
 func (r Repo) getUser(ctx context.Context, filter FilterUser) (res *UserDetail, err error) {
-	query, args := config.Psql().
+	defer metrics.ObserveQuery("user", "getUser")()
+	query, args := r.sb.
 		Select(
 			"u.id",
-			"r.id",
-			"r.code",
+			"COALESCE(r.id, '')",
+			"COALESCE(r.code, '')",
 			"u.first_name",
 			"u.last_name",
 			"u.gender",
@@ -150,11 +262,16 @@ func (r Repo) getUser(ctx context.Context, filter FilterUser) (res *UserDetail,
 			"u.created_by",
 			"u.updated_at",
 			"u.updated_by",
+			"u.locale",
+			"u.tenant_id",
+			"u.department_id",
 		).
 		From("users u").
 		LeftJoin("roles r ON r.id = u.role_id").
-		Where(filter).MustSql()
-	fmt.Printf("query: %v\n", query)
+		Where(filter).
+		Where(scoped(ctx)).
+		MustSql()
+	logger.FromContext(ctx).Debug("user query", "sql", query)
 	var i UserDetail
 	row := r.db.QueryRowContext(ctx, query, args...)
 	if err := row.Scan(
@@ -172,16 +289,40 @@ func (r Repo) getUser(ctx context.Context, filter FilterUser) (res *UserDetail,
 		&i.CreatedBy,
 		&i.UpdatedAt,
 		&i.UpdatedBy,
+		&i.Locale,
+		&i.TenantID,
+		&i.DepartmentID,
 	); err != nil {
 		return nil, err
 	}
 	return &i, nil
 }
 
+func (r Repo) updateLocale(ctx context.Context, userID, locale string) error {
+	defer metrics.ObserveQuery("user", "updateLocale")()
+	query, args, err := r.sb.
+		Update("users").
+		Set("locale", locale).
+		Set("updated_by", userID).
+		Where(squirrel.Eq{"id": userID}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 func (r *Repo) createPermission(_ context.Context, req Permission) error {
+	defer metrics.ObserveQuery("user", "createPermission")()
 	var role string = req.RoleID
-	m, _ := model.NewModelFromString(r.model)
-	e, err := casbin.NewEnforcer(m, r.adapter)
+	e, err := r.enforcer()
 	if err != nil {
 		return err
 	}
@@ -193,21 +334,21 @@ func (r *Repo) createPermission(_ context.Context, req Permission) error {
 	}
 	if !e.HasPolicy(role) {
 		for i := 0; i < len(req.User); i++ {
-			if _, err := e.AddPolicy(role, "user", req.User[i]); err != nil {
+			if _, err := e.AddPolicy(role, "user", req.User[i], req.Scope); err != nil {
 				return err
 			}
 		}
 	}
 
-	r.authz.ReloadEnforcer(r.model, r.adapter)
-
 	return nil
 }
 
 func (r *Repo) getRole(ctx context.Context, filter FilterRole) (Role, error) {
-	query, args := config.Psql().
+	defer metrics.ObserveQuery("user", "getRole")()
+	query, args := r.sb.
 		Select(
 			"id",
+			"code",
 			"name",
 			"status",
 			"created_at",
@@ -219,6 +360,7 @@ func (r *Repo) getRole(ctx context.Context, filter FilterRole) (Role, error) {
 	var i Role
 	err := row.Scan(
 		&i.ID,
+		&i.Code,
 		&i.Name,
 		&i.Status,
 		&i.CreatedAt,
@@ -227,9 +369,11 @@ func (r *Repo) getRole(ctx context.Context, filter FilterRole) (Role, error) {
 }
 
 func (r *Repo) listRoles(ctx context.Context) ([]Role, error) {
-	query, args, err := config.Psql().
+	defer metrics.ObserveQuery("user", "listRoles")()
+	query, args, err := r.sb.
 		Select(
 			"id",
+			"code",
 			"name",
 			"status",
 			"created_at",
@@ -248,6 +392,7 @@ func (r *Repo) listRoles(ctx context.Context) ([]Role, error) {
 		var i Role
 		if err := rows.Scan(
 			&i.ID,
+			&i.Code,
 			&i.Name,
 			&i.Status,
 			&i.CreatedAt,
@@ -266,10 +411,12 @@ func (r *Repo) listRoles(ctx context.Context) ([]Role, error) {
 }
 
 func (r *Repo) listPermissions(ctx context.Context, roleID string) ([]ListPermission, error) {
-	query, args, err := config.Psql().
+	defer metrics.ObserveQuery("user", "listPermissions")()
+	query, args, err := r.sb.
 		Select(
 			"v1",
 			"v2",
+			"v3",
 		).From("permissions").
 		Where("v0 = ?", roleID).
 		ToSql()
@@ -287,6 +434,7 @@ func (r *Repo) listPermissions(ctx context.Context, roleID string) ([]ListPermis
 		if err := rows.Scan(
 			&i.Domain,
 			&i.Action,
+			&i.Scope,
 		); err != nil {
 			return nil, err
 		}
@@ -302,10 +450,12 @@ func (r *Repo) listPermissions(ctx context.Context, roleID string) ([]ListPermis
 }
 
 func (r *Repo) getPermissions(ctx context.Context, filter FilterPermission) (ListPermission, error) {
-	query, args := config.Psql().
+	defer metrics.ObserveQuery("user", "getPermissions")()
+	query, args := r.sb.
 		Select(
 			"v1",
 			"v2",
+			"v3",
 		).From("permissions").
 		Where(filter).MustSql()
 	row := r.db.QueryRowContext(ctx, query, args...)
@@ -313,6 +463,7 @@ func (r *Repo) getPermissions(ctx context.Context, filter FilterPermission) (Lis
 	if err := row.Scan(
 		&i.Domain,
 		&i.Action,
+		&i.Scope,
 	); err != nil {
 		return ListPermission{}, err
 	}
@@ -320,7 +471,8 @@ func (r *Repo) getPermissions(ctx context.Context, filter FilterPermission) (Lis
 }
 
 func (r *Repo) listAllPermissions(ctx context.Context) ([]AllPermission, error) {
-	query, args, err := config.Psql().
+	defer metrics.ObserveQuery("user", "listAllPermissions")()
+	query, args, err := r.sb.
 		Select(
 			"resource",
 			"action",
@@ -349,3 +501,134 @@ func (r *Repo) listAllPermissions(ctx context.Context) ([]AllPermission, error)
 	}
 	return items, nil
 }
+
+func (r *Repo) createRole(ctx context.Context, req Role) (bool, error) {
+	defer metrics.ObserveQuery("user", "createRole")()
+	query, args, err := r.sb.
+		Insert("roles").
+		Columns(
+			"id",
+			"code",
+			"name",
+			"status",
+		).
+		Values(
+			req.ID,
+			req.Code,
+			req.Name,
+			req.Status,
+		).
+		ToSql()
+	if err != nil {
+		return false, err
+	}
+	if _, err := r.db.ExecContext(ctx, query, args...); err != nil {
+		if errors.Is(database.NormalizeError(err), database.ErrUniqueViolation) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *Repo) createAllPermission(ctx context.Context, req AllPermission) (bool, error) {
+	defer metrics.ObserveQuery("user", "createAllPermission")()
+	query, args, err := r.sb.
+		Insert("all_permissions").
+		Columns(
+			"resource",
+			"action",
+		).
+		Values(
+			req.Resource,
+			req.Action,
+		).
+		ToSql()
+	if err != nil {
+		return false, err
+	}
+	if _, err := r.db.ExecContext(ctx, query, args...); err != nil {
+		if errors.Is(database.NormalizeError(err), database.ErrUniqueViolation) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// addPolicy allows the role action on resource within scope, replacing the
+// rule of the same action with another scope.
+func (r *Repo) addPolicy(_ context.Context, roleID, resource, action, scope string) (bool, error) {
+	defer metrics.ObserveQuery("user", "addPolicy")()
+	e, err := r.enforcer()
+	if err != nil {
+		return false, err
+	}
+	if e.HasPolicy(roleID, resource, action, scope) {
+		return false, nil
+	}
+	if _, err := e.RemoveFilteredPolicy(0, roleID, resource, action); err != nil {
+		return false, err
+	}
+	return e.AddPolicy(roleID, resource, action, scope)
+}
+
+// removePolicy removes the rule of the action, whatever its scope.
+func (r *Repo) removePolicy(_ context.Context, roleID, resource, action string) (bool, error) {
+	defer metrics.ObserveQuery("user", "removePolicy")()
+	e, err := r.enforcer()
+	if err != nil {
+		return false, err
+	}
+	return e.RemoveFilteredPolicy(0, roleID, resource, action)
+}
+
+// enforcer loads a fresh enforcer from the permissions table, independent of
+// the one the middleware holds.
+func (r *Repo) enforcer() (*casbin.Enforcer, error) {
+	defer metrics.ObserveQuery("user", "loadPolicy")()
+	return middleware.NewEnforcer(r.model, r.adapter)
+}
+
+func (r Repo) updateStatus(ctx context.Context, userID string, status UserStatus, updatedBy string) error {
+	defer metrics.ObserveQuery("user", "updateStatus")()
+	return r.updateUser(ctx, userID, map[string]interface{}{
+		"status":     status,
+		"updated_by": updatedBy,
+	})
+}
+
+func (r Repo) updatePassword(ctx context.Context, userID, password, updatedBy string) error {
+	defer metrics.ObserveQuery("user", "updatePassword")()
+	return r.updateUser(ctx, userID, map[string]interface{}{
+		"password":   password,
+		"updated_by": updatedBy,
+	})
+}
+
+func (r Repo) updateRole(ctx context.Context, userID, roleID, updatedBy string) error {
+	defer metrics.ObserveQuery("user", "updateRole")()
+	return r.updateUser(ctx, userID, map[string]interface{}{
+		"role_id":    roleID,
+		"updated_by": updatedBy,
+	})
+}
+
+func (r Repo) updateUser(ctx context.Context, userID string, set map[string]interface{}) error {
+	query, args, err := r.sb.
+		Update("users").
+		SetMap(set).
+		Where(squirrel.Eq{"id": userID}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
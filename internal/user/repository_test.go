@@ -0,0 +1,98 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/middleware"
+)
+
+// TestRepoRoundTrip writes and reads users, roles and rules on sqlite: the
+// placeholders, the unique violations mapped to domain errors and the
+// policy rewritten through the rbac adapter.
+func TestRepoRoundTrip(t *testing.T) {
+	r := newTestService(t).repo
+	ctx := context.Background()
+
+	id := "admin"
+	if created, err := r.createRole(ctx, Role{ID: &id, Code: "ADMIN", Name: "admin", Status: "active"}); err != nil || created {
+		t.Fatalf("createRole of an existing role: got %v, %v", created, err)
+	}
+
+	ann := User{
+		FirstName:    "Ann",
+		LastName:     "Example",
+		Gender:       string(GendersF),
+		Email:        "ann@example.com",
+		Phone:        "2055500001",
+		Password:     "hash",
+		RoleID:       "staff",
+		DepartmentID: testDepartment,
+		Status:       UserStatusActive,
+		CreatedBy:    "test",
+	}
+	if err := r.createUser(ctx, ann); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.getUser(ctx, FilterUser{Email: ann.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID == "" || got.FirstName != ann.FirstName || got.Phone != ann.Phone || got.Role.ID != "staff" ||
+		got.Role.Name != "STAFF" || got.DepartmentID != testDepartment || got.Status != UserStatusActive {
+		t.Fatalf("getUser: got %+v", got)
+	}
+
+	dup := ann
+	dup.Phone = "2055500002"
+	if err := duplicateErr(r.createUser(ctx, dup)); !errors.Is(err, apperror.ErrEmailAlreadyExist) {
+		t.Fatalf("same email: got %v", err)
+	}
+	dup = ann
+	dup.Email = "other@example.com"
+	if err := duplicateErr(r.createUser(ctx, dup)); !errors.Is(err, apperror.ErrPhoneAlreadyExist) {
+		t.Fatalf("same phone: got %v", err)
+	}
+
+	list, err := r.listUsers(ctx, FilterUser{DepartmentID: testDepartment})
+	if err != nil || len(list) != 1 || list[0].ID != got.ID {
+		t.Fatalf("listUsers: got %+v, %v", list, err)
+	}
+	emails, phones, err := r.takenContacts(ctx, []string{ann.Email, "free@example.com"}, []string{"2055500009"})
+	if err != nil || !emails[ann.Email] || emails["free@example.com"] || phones["2055500009"] {
+		t.Fatalf("takenContacts: got %v, %v, %v", emails, phones, err)
+	}
+
+	if err := r.updateStatus(ctx, got.ID, UserStatusInActive, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = r.getUser(ctx, FilterUser{ID: got.ID}); err != nil || got.Status != UserStatusInActive {
+		t.Fatalf("after updateStatus: got %+v, %v", got, err)
+	}
+
+	// addPolicy replaces the rule of the action whatever its scope.
+	before, err := r.policyVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added, err := r.addPolicy(ctx, "staff", "user", "list", middleware.ScopeDepartment); err != nil || !added {
+		t.Fatalf("addPolicy: got %v, %v", added, err)
+	}
+	after, err := r.policyVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after {
+		t.Fatal("policyVersion did not change with the policy")
+	}
+	e, err := r.enforcer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := e.GetFilteredPolicy(0, "staff", "user", "list")
+	if len(rules) != 1 || rules[0][3] != middleware.ScopeDepartment {
+		t.Fatalf("rules of staff user list: got %v", rules)
+	}
+}
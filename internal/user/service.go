@@ -4,57 +4,105 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"fmt"
+	"strings"
+	"time"
 
-	"github.com/anousonefs/golang-htmx-template/internal/activity"
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/database"
+	"github.com/anousonefs/golang-htmx-template/internal/event"
+	"github.com/anousonefs/golang-htmx-template/internal/i18n"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/middleware"
 	"github.com/anousonefs/golang-htmx-template/internal/utils"
+	"github.com/anousonefs/golang-htmx-template/internal/validation"
 
-	"github.com/lib/pq"
-	"github.com/sirupsen/logrus"
+	"github.com/casbin/casbin/v2"
 )
 
+// Directory checks the departments and positions given to the users, the
+// department package implements it.
+type Directory interface {
+	CheckAssignment(ctx context.Context, departmentID, positionID string) error
+	// Codes maps the codes of the departments and of the positions to
+	// their ids.
+	Codes(ctx context.Context) (departments, positions map[string]string, err error)
+}
+
 type Service struct {
-	repo     *Repo
-	activity *activity.Service
+	repo      *Repo
+	events    *event.Bus
+	directory Directory
 }
 
-func NewService(repo *Repo, activity *activity.Service) Service {
-	return Service{repo, activity}
+func NewService(repo *Repo, events *event.Bus, directory Directory) Service {
+	return Service{repo, events, directory}
 }
 
-func (u *Service) CreateUser(ctx context.Context, req User, act activity.Activity) (err error) {
+func (u *Service) CreateUser(ctx context.Context, req User, actor event.Actor) (err error) {
 	defer func() {
 		if err != nil {
-			logrus.Errorf("user.CreateUser(): %v\n", err)
+			logger.FromContext(ctx).Error("user.CreateUser", "err", err)
 		}
 	}()
+	if err := u.directory.CheckAssignment(ctx, req.DepartmentID, req.PositionID); err != nil {
+		return err
+	}
+	if scope, ok := middleware.ScopeFromContext(ctx); ok && !scope.Contains(req.DepartmentID) {
+		return apperror.StatusPermissionDenied.Err()
+	}
+	if err := u.checkRole(ctx, req.RoleID); err != nil {
+		return err
+	}
 	req.Status = UserStatusActive
-	utils.PrettyPrint(req)
-	if err = u.repo.createUser(ctx, req); err != nil {
-		fmt.Printf("err: %v\n", err)
-		pgErr, isPGErr := err.(*pq.Error)
-		if isPGErr && pgErr.Code == "23505" {
-			return ErrDuplicateKey
-		}
+	if req.Password, err = utils.HashPassword(req.Password); err != nil {
 		return err
 	}
+	return u.repo.inTx(ctx, func(tx *sql.Tx) error {
+		repo := u.repo.withTx(tx)
+		if err := repo.createUser(ctx, req); err != nil {
+			return duplicateErr(err)
+		}
+		// the id is generated by the database.
+		created, err := repo.getUser(ctx, FilterUser{Email: req.Email})
+		if err != nil {
+			return err
+		}
+		return u.events.Publish(ctx, tx, event.UserCreated{
+			UserID:       created.ID,
+			Email:        req.Email,
+			FirstName:    req.FirstName,
+			LastName:     req.LastName,
+			RoleID:       req.RoleID,
+			DepartmentID: req.DepartmentID,
+			PositionID:   req.PositionID,
+			Actor:        actor,
+		})
+	})
+}
 
-	act.Title = "Create User"
-	act.Resource = "user"
-	act.Action = "create"
-	act.ResData = []byte(fmt.Sprintf("%+v", req))
-	act.ResData = []byte(fmt.Sprintf("%+v", req))
-	if err := u.activity.CreateActivity(ctx, act); err != nil {
+// duplicateErr maps a unique violation on users to the matching domain error,
+// whichever driver reported it.
+func duplicateErr(err error) error {
+	err = database.NormalizeError(err)
+	if !errors.Is(err, database.ErrUniqueViolation) {
 		return err
 	}
-
-	return nil
+	constraint := database.ConstraintName(err)
+	switch {
+	case strings.Contains(constraint, "email"):
+		return apperror.ErrEmailAlreadyExist
+	case strings.Contains(constraint, "phone"):
+		return apperror.ErrPhoneAlreadyExist
+	case strings.Contains(constraint, "username"):
+		return apperror.ErrUsernameAlreadyExist
+	}
+	return apperror.ErrDuplicateKey
 }
 
 func (u *Service) ListUsers(ctx context.Context, filter FilterUser) (res []UserList, err error) {
 	defer func() {
 		if err != nil {
-			logrus.Errorf("user.ListUsers(): %v\n", err)
+			logger.FromContext(ctx).Error("user.ListUsers", "err", err)
 		}
 	}()
 	res, err = u.repo.listUsers(ctx, filter)
@@ -66,41 +114,71 @@ func (u *Service) ListUsers(ctx context.Context, filter FilterUser) (res []UserL
 
 func (u *Service) GetUser(ctx context.Context, filter FilterUser) (res *UserDetail, err error) {
 	defer func() {
-		if err != nil {
-			logrus.Errorf("user.GetUser(%#v): %v\n", filter, err)
+		if err != nil && !errors.Is(err, apperror.ErrStatusNotFound) {
+			logger.FromContext(ctx).Error("user.GetUser", "filter", filter, "err", err)
 		}
 	}()
 	res, err = u.repo.getUser(ctx, filter)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrStatusNotFound
+			return nil, apperror.ErrStatusNotFound
 		}
 		return nil, err
 	}
 	return res, err
 }
 
+// SetLocale stores the language the user picked, it is restored into the
+// i18n cookie on their next login.
+func (u *Service) SetLocale(ctx context.Context, userID, locale string) (err error) {
+	defer func() {
+		if err != nil {
+			logger.FromContext(ctx).Error("user.SetLocale", "err", err)
+		}
+	}()
+	v := validation.New()
+	v.Required("lang", locale)
+	validation.Enum(v, "lang", locale, i18n.Supported...)
+	if err := v.Err(); err != nil {
+		return err
+	}
+	if err := u.repo.updateLocale(ctx, userID, locale); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperror.ErrStatusNotFound
+		}
+		return err
+	}
+	return nil
+}
+
 func (u *Service) CreatePermission(ctx context.Context, req Permission) (res []ListPermission, err error) {
 	defer func() {
 		if err != nil {
-			logrus.Errorf("u.CreatePermission(): %v\n", err)
+			logger.FromContext(ctx).Error("user.CreatePermission", "err", err)
 		}
 	}()
-	utils.PrettyPrint(req)
 	if _, err = u.GetRole(ctx, FilterRole{ID: req.RoleID}); err != nil {
 		return nil, err
 	}
-	println("pass")
-	if err = u.repo.createPermission(ctx, req); err != nil {
+	if req.Scope == "" {
+		req.Scope = middleware.ScopeAll
+	}
+	if err = u.repo.inTx(ctx, func(tx *sql.Tx) error {
+		if err := u.repo.withTx(tx).createPermission(ctx, req); err != nil {
+			return err
+		}
+		return u.events.Publish(ctx, tx, event.PermissionsChanged{RoleID: req.RoleID})
+	}); err != nil {
 		return nil, err
 	}
+	u.repo.reloadPolicy()
 	return u.ListPermissions(ctx, req.RoleID)
 }
 
 func (u *Service) ListRoles(ctx context.Context) (res []Role, err error) {
 	res, err = u.repo.listRoles(ctx)
 	if err != nil {
-		logrus.Errorf("u.ListRoles(): %v\n", err)
+		logger.FromContext(ctx).Error("user.ListRoles", "err", err)
 		return []Role{}, err
 	}
 	return res, nil
@@ -109,14 +187,13 @@ func (u *Service) ListRoles(ctx context.Context) (res []Role, err error) {
 func (u *Service) GetRole(ctx context.Context, filter FilterRole) (res Role, err error) {
 	defer func() {
 		if err != nil {
-			logrus.Errorf("u.GetRoles(): %v\n", err)
+			logger.FromContext(ctx).Error("user.GetRoles", "err", err)
 		}
 	}()
-	utils.PrettyPrint(filter)
 	res, err = u.repo.getRole(ctx, filter)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return Role{}, ErrStatusNotFound
+			return Role{}, apperror.ErrStatusNotFound
 		}
 		return Role{}, err
 	}
@@ -126,7 +203,7 @@ func (u *Service) GetRole(ctx context.Context, filter FilterRole) (res Role, err
 func (u *Service) ListPermissions(ctx context.Context, roleID string) (res []ListPermission, err error) {
 	defer func() {
 		if err != nil {
-			logrus.Errorf("u.ListPermissions(): %v\n", err)
+			logger.FromContext(ctx).Error("user.ListPermissions", "err", err)
 		}
 	}()
 	res, err = u.repo.listPermissions(ctx, roleID)
@@ -142,13 +219,13 @@ func (u *Service) ListPermissions(ctx context.Context, roleID string) (res []Lis
 func (u *Service) GetPermissions(ctx context.Context, filter FilterPermission) (res ListPermission, err error) {
 	defer func() {
 		if err != nil {
-			logrus.Errorf("u.GetPermissions(): %v\n", err)
+			logger.FromContext(ctx).Error("user.GetPermissions", "err", err)
 		}
 	}()
 	res, err = u.repo.getPermissions(ctx, filter)
 	if err != nil {
 		if errors.Is(sql.ErrNoRows, err) {
-			return ListPermission{}, ErrStatusNotFound
+			return ListPermission{}, apperror.ErrStatusNotFound
 		}
 		return ListPermission{}, err
 	}
@@ -158,15 +235,324 @@ func (u *Service) GetPermissions(ctx context.Context, filter FilterPermission) (
 func (u *Service) ListAllPermissions(ctx context.Context) (res []AllPermission, err error) {
 	defer func() {
 		if err != nil {
-			logrus.Errorf("u.ListPermissions(): %v\n", err)
+			logger.FromContext(ctx).Error("user.ListPermissions", "err", err)
 		}
 	}()
 	res, err = u.repo.listAllPermissions(ctx)
 	if err != nil {
 		if errors.Is(sql.ErrNoRows, err) {
-			return nil, ErrStatusNotFound
+			return nil, apperror.ErrStatusNotFound
 		}
 		return nil, err
 	}
 	return res, nil
 }
+
+// CreateRole inserts the role unless one with the same id already exists.
+func (u *Service) CreateRole(ctx context.Context, req Role) (created bool, err error) {
+	defer func() {
+		if err != nil {
+			logger.FromContext(ctx).Error("user.CreateRole", "err", err)
+		}
+	}()
+	if req.ID == nil || *req.ID == "" || req.Name == "" {
+		return false, apperror.ErrBadRequest
+	}
+	if req.Status == "" {
+		req.Status = string(UserStatusActive)
+	}
+	return u.repo.createRole(ctx, req)
+}
+
+// CreateAllPermission adds a resource/action pair to the permission catalog
+// unless it is already there.
+func (u *Service) CreateAllPermission(ctx context.Context, req AllPermission) (created bool, err error) {
+	defer func() {
+		if err != nil {
+			logger.FromContext(ctx).Error("user.CreateAllPermission", "err", err)
+		}
+	}()
+	if req.Resource == "" || req.Action == "" {
+		return false, apperror.ErrBadRequest
+	}
+	return u.repo.createAllPermission(ctx, req)
+}
+
+// GrantPermission adds a single casbin policy for the role, keeping the
+// role's other policies untouched. An empty scope is middleware.ScopeAll, a
+// rule of the same action with another scope is replaced.
+func (u *Service) GrantPermission(ctx context.Context, roleID, resource, action, scope string) (added bool, err error) {
+	defer func() {
+		if err != nil {
+			logger.FromContext(ctx).Error("user.GrantPermission", "err", err)
+		}
+	}()
+	if scope == "" {
+		scope = middleware.ScopeAll
+	}
+	v := validation.New()
+	validation.Enum(v, "scope", scope, middleware.Scopes...)
+	if err := v.Err(); err != nil {
+		return false, err
+	}
+	if _, err = u.GetRole(ctx, FilterRole{ID: roleID}); err != nil {
+		return false, err
+	}
+	if err = u.repo.inTx(ctx, func(tx *sql.Tx) error {
+		if added, err = u.repo.withTx(tx).addPolicy(ctx, roleID, resource, action, scope); err != nil || !added {
+			return err
+		}
+		return u.events.Publish(ctx, tx, event.PermissionsChanged{RoleID: roleID})
+	}); err != nil {
+		return false, err
+	}
+	if added {
+		u.repo.reloadPolicy()
+	}
+	return added, nil
+}
+
+// RevokePermission removes a single casbin policy of the role, whatever its
+// scope.
+func (u *Service) RevokePermission(ctx context.Context, roleID, resource, action string) (removed bool, err error) {
+	defer func() {
+		if err != nil {
+			logger.FromContext(ctx).Error("user.RevokePermission", "err", err)
+		}
+	}()
+	if err = u.repo.inTx(ctx, func(tx *sql.Tx) error {
+		if removed, err = u.repo.withTx(tx).removePolicy(ctx, roleID, resource, action); err != nil || !removed {
+			return err
+		}
+		return u.events.Publish(ctx, tx, event.PermissionsChanged{RoleID: roleID})
+	}); err != nil {
+		return false, err
+	}
+	if removed {
+		u.repo.reloadPolicy()
+	}
+	return removed, nil
+}
+
+// WatchPolicy reloads the enforcer of the middleware when the permissions
+// table changes, every interval until ctx is done. The rules granted or
+// revoked by another process, the CLI or another server, apply then.
+func (u *Service) WatchPolicy(ctx context.Context, interval time.Duration) error {
+	log := logger.FromContext(ctx)
+	last, err := u.repo.policyVersion(ctx)
+	if err != nil {
+		log.Error("read policy version", "err", err)
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+		v, err := u.repo.policyVersion(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Error("read policy version", "err", err)
+			}
+			continue
+		}
+		if v != last {
+			last = v
+			u.repo.reloadPolicy()
+			log.Info("policy reloaded")
+		}
+	}
+}
+
+// ListPolicies returns every role/resource/action rule of the permissions
+// table.
+func (u *Service) ListPolicies(ctx context.Context) (res [][]string, err error) {
+	defer func() {
+		if err != nil {
+			logger.FromContext(ctx).Error("user.ListPolicies", "err", err)
+		}
+	}()
+	e, err := u.repo.enforcer()
+	if err != nil {
+		return nil, err
+	}
+	return e.GetPolicy(), nil
+}
+
+// CheckPermission returns the scope in which the role may perform action
+// on resource, using the same model as the middleware. It is empty when the
+// role may not do it.
+func (u *Service) CheckPermission(ctx context.Context, roleID, resource, action string) (scope string, err error) {
+	defer func() {
+		if err != nil {
+			logger.FromContext(ctx).Error("user.CheckPermission", "err", err)
+		}
+	}()
+	e, err := u.repo.enforcer()
+	if err != nil {
+		return "", err
+	}
+	return permissionScope(e, roleID, resource, action)
+}
+
+// permissionScope is CheckPermission on a loaded enforcer.
+func permissionScope(e *casbin.Enforcer, roleID, resource, action string) (string, error) {
+	// without an owner only the ScopeAll rules match, with a record of the
+	// subject's own department the ScopeDepartment ones match too.
+	for _, c := range []struct {
+		scope       string
+		owner       string
+		departments []string
+	}{
+		{middleware.ScopeAll, "", nil},
+		{middleware.ScopeDepartment, "own", []string{"own"}},
+	} {
+		ok, err := e.Enforce(roleID, resource, action, c.owner, c.departments)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return c.scope, nil
+		}
+	}
+	return "", nil
+}
+
+// exceedsRole tells whether roleID grants more than the role caller: a rule
+// caller does not hold, or holds for its department only where roleID holds
+// it for all. Without a caller, e.g. from the CLI, nothing exceeds.
+func exceedsRole(e *casbin.Enforcer, caller, roleID string) (bool, error) {
+	if caller == "" || caller == roleID {
+		return false, nil
+	}
+	rules, err := e.GetImplicitPermissionsForUser(roleID)
+	if err != nil {
+		return false, err
+	}
+	for _, rule := range rules {
+		// sub, obj, act, scope as in policy.conf.
+		if len(rule) < 4 {
+			continue
+		}
+		scope, err := permissionScope(e, caller, rule[1], rule[2])
+		if err != nil {
+			return false, err
+		}
+		if scope == "" || (rule[3] == middleware.ScopeAll && scope != middleware.ScopeAll) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkRole rejects a role that does not exist or that grants more than the
+// role of the signed in user, who could otherwise give a user more rights
+// than their own. Empty ids pass.
+func (u *Service) checkRole(ctx context.Context, roleID string) error {
+	if roleID == "" {
+		return nil
+	}
+	_, err := u.repo.getRole(ctx, FilterRole{ID: roleID})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	v := validation.New()
+	v.Exists("roleID", roleID, err == nil)
+	if err := v.Err(); err != nil {
+		return err
+	}
+	e, err := u.repo.enforcer()
+	if err != nil {
+		return err
+	}
+	exceeds, err := exceedsRole(e, middleware.UserClaimFromContext(ctx).RoleID, roleID)
+	if err != nil {
+		return err
+	}
+	if exceeds {
+		return apperror.StatusPermissionDenied.Err()
+	}
+	return nil
+}
+
+// SetStatus activates or disables the user, a disabled user can no longer
+// sign in.
+func (u *Service) SetStatus(ctx context.Context, userID string, status UserStatus, actor event.Actor) (err error) {
+	defer func() {
+		if err != nil {
+			logger.FromContext(ctx).Error("user.SetStatus", "err", err)
+		}
+	}()
+	v := validation.New()
+	v.Required("status", string(status))
+	validation.Enum(v, "status", status, UserStatusActive, UserStatusInActive)
+	if err := v.Err(); err != nil {
+		return err
+	}
+	return u.repo.inTx(ctx, func(tx *sql.Tx) error {
+		if err := u.repo.withTx(tx).updateStatus(ctx, userID, status, actor.ID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperror.ErrStatusNotFound
+			}
+			return err
+		}
+		return u.events.Publish(ctx, tx, event.StatusChanged{UserID: userID, Status: string(status), Actor: actor})
+	})
+}
+
+// ResetPassword replaces the password of the user, the event only tells
+// that it happened.
+func (u *Service) ResetPassword(ctx context.Context, userID, password string, actor event.Actor) (err error) {
+	defer func() {
+		if err != nil {
+			logger.FromContext(ctx).Error("user.ResetPassword", "err", err)
+		}
+	}()
+	v := validation.New()
+	v.Required("password", password)
+	v.MinLength("password", password, 8)
+	if err := v.Err(); err != nil {
+		return err
+	}
+	if password, err = utils.HashPassword(password); err != nil {
+		return err
+	}
+	return u.repo.inTx(ctx, func(tx *sql.Tx) error {
+		if err := u.repo.withTx(tx).updatePassword(ctx, userID, password, actor.ID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperror.ErrStatusNotFound
+			}
+			return err
+		}
+		return u.events.Publish(ctx, tx, event.PasswordReset{UserID: userID, Actor: actor})
+	})
+}
+
+// SetRole moves the user to another role, their permissions change with
+// their next token.
+func (u *Service) SetRole(ctx context.Context, userID, roleID string, actor event.Actor) (err error) {
+	defer func() {
+		if err != nil {
+			logger.FromContext(ctx).Error("user.SetRole", "err", err)
+		}
+	}()
+	v := validation.New()
+	v.Required("roleID", roleID)
+	if err := v.Err(); err != nil {
+		return err
+	}
+	if _, err = u.GetRole(ctx, FilterRole{ID: roleID}); err != nil {
+		return err
+	}
+	return u.repo.inTx(ctx, func(tx *sql.Tx) error {
+		if err := u.repo.withTx(tx).updateRole(ctx, userID, roleID, actor.ID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperror.ErrStatusNotFound
+			}
+			return err
+		}
+		return u.events.Publish(ctx, tx, event.RoleChanged{UserID: userID, RoleID: roleID, Actor: actor})
+	})
+}
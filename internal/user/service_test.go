@@ -0,0 +1,143 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/event"
+	"github.com/anousonefs/golang-htmx-template/internal/middleware"
+	"github.com/anousonefs/golang-htmx-template/internal/migration"
+	"github.com/anousonefs/golang-htmx-template/internal/rbac"
+	"github.com/anousonefs/golang-htmx-template/internal/validation"
+
+	_ "modernc.org/sqlite"
+)
+
+const testDepartment = "0f5c2a8e-6a4b-4d8e-9a3e-2b1f4c6d7e80"
+
+// directory accepts every department and position, testDepartment has the
+// code HQ.
+type directory struct{}
+
+func (directory) CheckAssignment(context.Context, string, string) error { return nil }
+
+func (directory) Codes(context.Context) (map[string]string, map[string]string, error) {
+	return map[string]string{"HQ": testDepartment}, map[string]string{}, nil
+}
+
+// newTestService is a user service on a migrated sqlite database with the
+// admin, manager and staff roles of seed.yaml.
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", filepath.Join(t.TempDir(), "test.db"))
+	db, err := sql.Open(config.DriverSqlite, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	m, err := migration.New(db, config.DriverSqlite, os.DirFS("../../cmd/migrations"), config.DriverSqlite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	model, err := os.ReadFile("../../cmd/policy.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb := config.StatementBuilder(config.DriverSqlite)
+	adapter := rbac.NewAdapter(db, sb, "permissions")
+	authz := middleware.New(middleware.Config{ModelFilePath: string(model), PolicyAdapter: adapter})
+	repo := NewRepo(db, sb, string(model), adapter, authz)
+	bus := event.NewBus(event.NewRepo(db, sb, config.DriverSqlite), time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s := NewService(repo, bus, directory{})
+
+	for _, code := range []string{"admin", "manager", "staff"} {
+		id := code
+		if _, err := repo.createRole(ctx, Role{ID: &id, Code: strings.ToUpper(code), Name: code, Status: "active"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e, err := repo.enforcer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rule := range [][]string{
+		{"admin", "*", "*", middleware.ScopeAll},
+		{"manager", "user", "create", middleware.ScopeDepartment},
+		{"manager", "user", "list", middleware.ScopeDepartment},
+		{"manager", "department", "list", middleware.ScopeAll},
+		{"staff", "department", "list", middleware.ScopeAll},
+		{"staff", "user", "list", middleware.ScopeAll},
+	} {
+		if _, err := e.AddPolicy(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &s
+}
+
+// asManager is the context of a request of a manager of testDepartment.
+func asManager() context.Context {
+	ctx := middleware.WithUserClaim(context.Background(), middleware.UserClaim{ID: "m", RoleID: "manager", DepartmentID: testDepartment})
+	return middleware.WithScope(ctx, middleware.Scope{Departments: []string{testDepartment}})
+}
+
+func TestCreateUserRole(t *testing.T) {
+	s := newTestService(t)
+	for i, c := range []struct {
+		name    string
+		ctx     context.Context
+		roleID  string
+		wantErr func(error) bool
+	}{
+		{"manager gives admin", asManager(), "admin", isPermissionDenied},
+		{"manager gives a rule held for the department only", asManager(), "staff", isPermissionDenied},
+		{"manager gives an unknown role", asManager(), "nobody", isValidation},
+		{"manager gives their own role", asManager(), "manager", nil},
+		{"manager gives no role", asManager(), "", nil},
+		{"admin gives admin", middleware.WithUserClaim(context.Background(), middleware.UserClaim{RoleID: "admin"}), "admin", nil},
+		{"cli gives admin", context.Background(), "admin", nil},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			err := s.CreateUser(c.ctx, User{
+				FirstName:    "Test",
+				LastName:     "User",
+				Gender:       string(GendersO),
+				Email:        fmt.Sprintf("u%d@example.com", i),
+				Phone:        fmt.Sprintf("2055500%03d", i),
+				Password:     "secret123",
+				RoleID:       c.roleID,
+				DepartmentID: testDepartment,
+			}, event.Actor{ID: "test"})
+			switch {
+			case c.wantErr == nil && err != nil:
+				t.Fatalf("CreateUser: %v", err)
+			case c.wantErr != nil && !c.wantErr(err):
+				t.Fatalf("CreateUser: got %v", err)
+			}
+		})
+	}
+}
+
+func isPermissionDenied(err error) bool {
+	return errors.Is(err, apperror.StatusPermissionDenied.Err())
+}
+
+func isValidation(err error) bool {
+	var verr *validation.Error
+	return errors.As(err, &verr)
+}
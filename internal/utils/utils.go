@@ -2,9 +2,7 @@ package utils
 
 import (
 	"encoding/base64"
-	"encoding/json"
 	"errors"
-	"fmt"
 	"image"
 	"io"
 	"math/rand"
@@ -24,7 +22,6 @@ import (
 
 	"github.com/disintegration/imaging"
 	"github.com/h2non/filetype"
-	"github.com/sirupsen/logrus"
 )
 
 func StringSliceToInterfaceSlice(arr []string) []interface{} {
@@ -114,7 +111,6 @@ func GetFileType(header *multipart.FileHeader) (string, error) {
 	fileType := mime.TypeByExtension(filepath.Ext(header.Filename))
 	fileTypeArr := strings.Split(fileType, "/")
 	fileType = fileTypeArr[len(fileTypeArr)-1]
-	fmt.Printf("=> filetype: %+v\n", fileType)
 	return fileType, nil
 }
 
@@ -181,7 +177,6 @@ func ReSize(imgDecode image.Config, maxSize float32) (int, int) {
 func RemoveFile(file string) error {
 	_, err := os.Stat(file)
 	if os.IsNotExist(err) {
-		logrus.Errorf("%s file does not exist", err)
 		return err
 	}
 	err = os.Remove(file)
@@ -247,12 +242,12 @@ func EncodeToString(max int) (string, error) {
 	return string(b), nil
 }
 
-func PrettyPrint(v interface{}) (err error) {
-	b, err := json.MarshalIndent(v, "", "\t")
-	if err == nil {
-		fmt.Println(string(b))
+func HashPassword(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
 	}
-	return err
+	return string(b), nil
 }
 
 func ComparePassword(password, hash string) error {
@@ -0,0 +1,235 @@
+package validation
+
+import (
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/i18n"
+
+	edpb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+)
+
+type kind int
+
+const (
+	kindInput kind = iota
+	kindEnum
+	kindUUID
+)
+
+var (
+	phoneRegex = regexp.MustCompile(`^\+?[0-9]{8,15}$`)
+	uuidRegex  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// Validator collects every failing field of a request instead of stopping
+// at the first one, so the client can show all of them at once.
+type Validator struct {
+	violations []violation
+	kinds      map[kind]bool
+}
+
+// violation keeps the i18n key of the description so it can be rendered in
+// the locale of the request.
+type violation struct {
+	field string
+	key   string
+	args  []interface{}
+}
+
+func New() *Validator {
+	return &Validator{kinds: map[kind]bool{}}
+}
+
+func (v *Validator) add(k kind, field, key string, args ...interface{}) {
+	for _, fv := range v.violations {
+		// keep the first problem of a field, e.g. "required" over "invalid email".
+		if fv.field == field {
+			return
+		}
+	}
+	v.violations = append(v.violations, violation{field, key, args})
+	v.kinds[k] = true
+}
+
+func (v *Validator) Required(field, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.add(kindInput, field, "validation.required")
+	}
+}
+
+func (v *Validator) MinLength(field, value string, n int) {
+	if value != "" && len([]rune(value)) < n {
+		v.add(kindInput, field, "validation.min_length", n)
+	}
+}
+
+func (v *Validator) MaxLength(field, value string, n int) {
+	if len([]rune(value)) > n {
+		v.add(kindInput, field, "validation.max_length", n)
+	}
+}
+
+func (v *Validator) Email(field, value string) {
+	if value == "" {
+		return
+	}
+	if a, err := mail.ParseAddress(value); err != nil || a.Address != value {
+		v.add(kindInput, field, "validation.email")
+	}
+}
+
+// URL accepts absolute http and https URLs.
+func (v *Validator) URL(field, value string) {
+	if value == "" {
+		return
+	}
+	if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.add(kindInput, field, "validation.url")
+	}
+}
+
+func (v *Validator) Phone(field, value string) {
+	if value != "" && !phoneRegex.MatchString(value) {
+		v.add(kindInput, field, "validation.phone")
+	}
+}
+
+func (v *Validator) UUID(field, value string) {
+	if value != "" && !uuidRegex.MatchString(value) {
+		v.add(kindUUID, field, "validation.uuid")
+	}
+}
+
+// Exists reports a value referring to a record that does not exist, empty
+// values pass.
+func (v *Validator) Exists(field, value string, exists bool) {
+	if value != "" && !exists {
+		v.add(kindInput, field, "validation.exists")
+	}
+}
+
+// Cycle reports a parent reference that would loop back to the record,
+// e.g. a department moved under one of its own children.
+func (v *Validator) Cycle(field, value string, cycle bool) {
+	if value != "" && cycle {
+		v.add(kindInput, field, "validation.cycle")
+	}
+}
+
+// Image reports an uploaded file that is not an image of the accepted
+// types, listed in the message.
+func (v *Validator) Image(field string, ok bool, types string) {
+	if !ok {
+		v.add(kindInput, field, "validation.image", types)
+	}
+}
+
+// PublicURL reports a URL whose host is the local machine or a private
+// network.
+func (v *Validator) PublicURL(field string, ok bool) {
+	if !ok {
+		v.add(kindInput, field, "validation.public_url")
+	}
+}
+
+// FileType reports an uploaded file that is not of the accepted types,
+// listed in the message.
+func (v *Validator) FileType(field string, ok bool, types string) {
+	if !ok {
+		v.add(kindInput, field, "validation.file_type", types)
+	}
+}
+
+// MaxRows reports a sheet with more than n rows.
+func (v *Validator) MaxRows(field string, rows, n int) {
+	if rows > n {
+		v.add(kindInput, field, "validation.max_rows", n)
+	}
+}
+
+// MaxSize reports an uploaded file larger than n bytes.
+func (v *Validator) MaxSize(field string, size, n int64) {
+	if size > n {
+		v.add(kindInput, field, "validation.max_size", n>>10)
+	}
+}
+
+// Enum checks value against the allowed constants, empty values pass so
+// optional enums can be combined with Required.
+func Enum[T ~string](v *Validator, field string, value T, allowed ...T) {
+	if value == "" {
+		return
+	}
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	names := make([]string, len(allowed))
+	for i, a := range allowed {
+		names[i] = string(a)
+	}
+	v.add(kindEnum, field, "validation.enum", strings.Join(names, ", "))
+}
+
+// Err returns nil when every check passed.
+func (v *Validator) Err() error {
+	if len(v.violations) == 0 {
+		return nil
+	}
+	return &Error{violations: v.violations, kinds: v.kinds}
+}
+
+// Error is returned by Validator.Err. It implements GRPCStatus so
+// apperror.GRPCStatusFromErr turns it into an InvalidArgument status with a
+// google.rpc.BadRequest detail listing each field.
+type Error struct {
+	violations []violation
+	kinds      map[kind]bool
+}
+
+func (e *Error) Error() string {
+	parts := make([]string, len(e.violations))
+	for i, fv := range e.violations {
+		parts[i] = fv.field + " " + i18n.Translate(i18n.English, fv.key, fv.args...)
+	}
+	return "validation failed: " + strings.Join(parts, "; ")
+}
+
+// FieldViolations returns the violations with descriptions in lang.
+func (e *Error) FieldViolations(lang string) []*edpb.BadRequest_FieldViolation {
+	res := make([]*edpb.BadRequest_FieldViolation, len(e.violations))
+	for i, fv := range e.violations {
+		res[i] = &edpb.BadRequest_FieldViolation{
+			Field:       fv.field,
+			Description: i18n.Translate(lang, fv.key, fv.args...),
+		}
+	}
+	return res
+}
+
+func (e *Error) GRPCStatus() *status.Status {
+	return e.LocalizedStatus(i18n.English)
+}
+
+// LocalizedStatus is GRPCStatus with the field descriptions in lang, see
+// apperror.HTTPErrorHandler.
+func (e *Error) LocalizedStatus(lang string) *status.Status {
+	base := apperror.StatusBadRequest
+	switch {
+	case len(e.kinds) == 1 && e.kinds[kindEnum]:
+		base = apperror.StatusInvalidENUM
+	case len(e.kinds) == 1 && e.kinds[kindUUID]:
+		base = apperror.StatusInvalidUUID
+	}
+	s, err := base.WithDetails(&edpb.BadRequest{FieldViolations: e.FieldViolations(lang)})
+	if err != nil {
+		return base
+	}
+	return s
+}
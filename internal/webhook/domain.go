@@ -0,0 +1,118 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/event"
+	"github.com/anousonefs/golang-htmx-template/internal/validation"
+)
+
+// KindDeliver is the job kind sending a Delivery.
+const KindDeliver = "webhook.deliver"
+
+// AllEvents subscribes a webhook to every event, the ones added later
+// included.
+const AllEvents = "*"
+
+// maxAttempts is the number of tries of a delivery, with the backoff of the
+// job queue they span about four hours.
+const maxAttempts = 12
+
+type Webhook struct {
+	ID          int64     `json:"id"`
+	URL         string    `json:"url"`
+	Secret      string    `json:"secret,omitempty"`
+	Events      []string  `json:"events"`
+	Description string    `json:"description"`
+	Active      bool      `json:"active"`
+	CreatedBy   string    `json:"createdBy"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// EventNames are the events a webhook may subscribe to.
+func EventNames() []string {
+	return append([]string{AllEvents}, event.Names()...)
+}
+
+func (w Webhook) Validate() error {
+	v := validation.New()
+	v.Required("url", w.URL)
+	v.URL("url", w.URL)
+	v.MaxLength("description", w.Description, 255)
+	if len(w.Events) == 0 {
+		v.Required("events", "")
+	}
+	for _, e := range w.Events {
+		validation.Enum(v, "events", e, EventNames()...)
+	}
+	return v.Err()
+}
+
+// Subscribed reports whether w wants event.
+func (w Webhook) Subscribed(event string) bool {
+	for _, e := range w.Events {
+		if e == AllEvents || e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// host is shown in the logs instead of the url, which may carry a token.
+func (w Webhook) host() string {
+	if u, err := url.Parse(w.URL); err == nil {
+		return u.Host
+	}
+	return ""
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// Delivery is one event sent to one webhook, with the outcome of its last
+// attempt.
+type Delivery struct {
+	ID           int64          `json:"id"`
+	WebhookID    int64          `json:"webhookID"`
+	Event        string         `json:"event"`
+	Payload      string         `json:"payload"`
+	Status       DeliveryStatus `json:"status"`
+	Attempts     int            `json:"attempts"`
+	ResponseCode int            `json:"responseCode"`
+	ResponseBody string         `json:"responseBody"`
+	LastError    string         `json:"lastError"`
+	DurationMs   int64          `json:"durationMs"`
+	CreatedAt    time.Time      `json:"createdAt"`
+	UpdatedAt    time.Time      `json:"updatedAt"`
+	DeliveredAt  *time.Time     `json:"deliveredAt"`
+}
+
+// Payload is the body POSTed to the webhooks.
+type Payload struct {
+	// ID identifies the event, it is the same for every webhook and every
+	// redelivery so receivers can drop duplicates.
+	ID        string          `json:"id"`
+	Event     string          `json:"event"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func joinEvents(events []string) string {
+	return strings.Join(events, ",")
+}
+
+func splitEvents(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
@@ -0,0 +1,142 @@
+package webhook
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/middleware"
+	"github.com/anousonefs/golang-htmx-template/internal/ratelimit"
+
+	"github.com/labstack/echo/v4"
+)
+
+type handler struct {
+	webhook *Service
+	authz   *middleware.CasbinMiddleware
+}
+
+func NewHandler(webhook *Service, authz *middleware.CasbinMiddleware) *handler {
+	return &handler{webhook, authz}
+}
+
+// Install adds the API managing the webhooks and their delivery log.
+func (h *handler) Install(e *echo.Echo, cfg config.Config, rl *ratelimit.Limiter) {
+	mws := append(middleware.Auth(cfg), rl.ReadWrite())
+
+	api := e.Group("/api/v1/webhooks", mws...)
+	api.GET("", h.list, h.authz.Authorize("webhook", "list"))
+	api.POST("", h.create, h.authz.Authorize("webhook", "create"))
+	api.GET("/events", h.events, h.authz.Authorize("webhook", "list"))
+	api.GET("/:id", h.get, h.authz.Authorize("webhook", "get"))
+	api.PUT("/:id", h.update, h.authz.Authorize("webhook", "update"))
+	api.DELETE("/:id", h.delete, h.authz.Authorize("webhook", "delete"))
+	api.GET("/:id/deliveries", h.listDeliveries, h.authz.Authorize("webhook", "get"))
+	api.POST("/:id/deliveries/:deliveryID/redeliver", h.redeliver, h.authz.Authorize("webhook", "update"))
+}
+
+func paramID(c echo.Context, name string) (int64, error) {
+	id, err := strconv.ParseInt(c.Param(name), 10, 64)
+	if err != nil {
+		return 0, apperror.ErrBadRequest
+	}
+	return id, nil
+}
+
+func (h *handler) list(c echo.Context) error {
+	res, err := h.webhook.List(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *handler) events(c echo.Context) error {
+	return c.JSON(http.StatusOK, EventNames())
+}
+
+func (h *handler) create(c echo.Context) error {
+	var req Webhook
+	if err := c.Bind(&req); err != nil {
+		logger.FromContext(c.Request().Context()).Warn("bind request", "err", err)
+		return apperror.StatusBindingFailure.Err()
+	}
+	ctx := c.Request().Context()
+	req.CreatedBy = middleware.UserClaimFromContext(ctx).ID
+	res, err := h.webhook.Create(ctx, req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, res)
+}
+
+func (h *handler) get(c echo.Context) error {
+	id, err := paramID(c, "id")
+	if err != nil {
+		return err
+	}
+	res, err := h.webhook.Get(c.Request().Context(), id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *handler) update(c echo.Context) error {
+	id, err := paramID(c, "id")
+	if err != nil {
+		return err
+	}
+	var req Webhook
+	if err := c.Bind(&req); err != nil {
+		logger.FromContext(c.Request().Context()).Warn("bind request", "err", err)
+		return apperror.StatusBindingFailure.Err()
+	}
+	req.ID = id
+	res, err := h.webhook.Update(c.Request().Context(), req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *handler) delete(c echo.Context) error {
+	id, err := paramID(c, "id")
+	if err != nil {
+		return err
+	}
+	if err := h.webhook.Delete(c.Request().Context(), id); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *handler) listDeliveries(c echo.Context) error {
+	id, err := paramID(c, "id")
+	if err != nil {
+		return err
+	}
+	res, err := h.webhook.ListDeliveries(c.Request().Context(), id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *handler) redeliver(c echo.Context) error {
+	id, err := paramID(c, "id")
+	if err != nil {
+		return err
+	}
+	deliveryID, err := paramID(c, "deliveryID")
+	if err != nil {
+		return err
+	}
+	res, err := h.webhook.Redeliver(c.Request().Context(), id, deliveryID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusAccepted, res)
+}
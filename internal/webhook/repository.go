@@ -0,0 +1,254 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/database"
+	"github.com/anousonefs/golang-htmx-template/internal/metrics"
+
+	"github.com/Masterminds/squirrel"
+)
+
+type Repo struct {
+	db     database.DBTX
+	sb     squirrel.StatementBuilderType
+	driver string
+}
+
+func NewRepo(db *sql.DB, sb squirrel.StatementBuilderType, driver string) *Repo {
+	return &Repo{db: db, sb: sb, driver: driver}
+}
+
+// WithTx returns a copy of the repo that runs its queries on tx.
+func (r Repo) WithTx(tx *sql.Tx) *Repo {
+	return &Repo{db: tx, sb: r.sb, driver: r.driver}
+}
+
+func (r *Repo) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return database.WithTx(ctx, r.db, fn)
+}
+
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// insertID runs the insert q and returns the id of the new row.
+func (r *Repo) insertID(ctx context.Context, q squirrel.InsertBuilder) (int64, error) {
+	if r.driver == config.DriverPostgres {
+		query, args, err := q.Suffix("RETURNING id").ToSql()
+		if err != nil {
+			return 0, err
+		}
+		var id int64
+		err = r.db.QueryRowContext(ctx, query, args...).Scan(&id)
+		return id, err
+	}
+	query, args, err := q.ToSql()
+	if err != nil {
+		return 0, err
+	}
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (r *Repo) exec(ctx context.Context, q squirrel.Sqlizer) (int64, error) {
+	query, args, err := q.ToSql()
+	if err != nil {
+		return 0, err
+	}
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *Repo) createWebhook(ctx context.Context, w Webhook) (int64, error) {
+	defer metrics.ObserveQuery("webhook", "createWebhook")()
+	t := now()
+	return r.insertID(ctx, r.sb.
+		Insert("webhooks").
+		Columns("url", "secret", "events", "description", "active", "created_by", "created_at", "updated_at").
+		Values(w.URL, w.Secret, joinEvents(w.Events), w.Description, w.Active, w.CreatedBy, t, t))
+}
+
+func (r *Repo) updateWebhook(ctx context.Context, w Webhook) error {
+	defer metrics.ObserveQuery("webhook", "updateWebhook")()
+	n, err := r.exec(ctx, r.sb.
+		Update("webhooks").
+		SetMap(squirrel.Eq{
+			"url":         w.URL,
+			"secret":      w.Secret,
+			"events":      joinEvents(w.Events),
+			"description": w.Description,
+			"active":      w.Active,
+			"updated_at":  now(),
+		}).
+		Where(squirrel.Eq{"id": w.ID}))
+	if err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+// deleteWebhook removes the webhook and its deliveries, sqlite does not
+// enforce the cascade.
+func (r *Repo) deleteWebhook(ctx context.Context, id int64) error {
+	defer metrics.ObserveQuery("webhook", "deleteWebhook")()
+	if _, err := r.exec(ctx, r.sb.Delete("webhook_deliveries").Where(squirrel.Eq{"webhook_id": id})); err != nil {
+		return err
+	}
+	n, err := r.exec(ctx, r.sb.Delete("webhooks").Where(squirrel.Eq{"id": id}))
+	if err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+func (r *Repo) listWebhooks(ctx context.Context, where squirrel.Sqlizer) ([]Webhook, error) {
+	defer metrics.ObserveQuery("webhook", "listWebhooks")()
+	query, args, err := r.sb.
+		Select("id", "url", "secret", "events", "description", "active", "created_by", "created_at", "updated_at").
+		From("webhooks").
+		Where(where).
+		OrderBy("id").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []Webhook{}
+	for rows.Next() {
+		var w Webhook
+		var events string
+		if err := rows.Scan(&w.ID, &w.URL, &w.Secret, &events, &w.Description, &w.Active, &w.CreatedBy, &w.CreatedAt, &w.UpdatedAt); err != nil {
+			return nil, err
+		}
+		w.Events = splitEvents(events)
+		res = append(res, w)
+	}
+	return res, rows.Err()
+}
+
+func (r *Repo) getWebhook(ctx context.Context, id int64) (Webhook, error) {
+	res, err := r.listWebhooks(ctx, squirrel.Eq{"id": id})
+	if err != nil {
+		return Webhook{}, err
+	}
+	if len(res) == 0 {
+		return Webhook{}, sql.ErrNoRows
+	}
+	return res[0], nil
+}
+
+func (r *Repo) createDelivery(ctx context.Context, d Delivery) (int64, error) {
+	defer metrics.ObserveQuery("webhook", "createDelivery")()
+	t := now()
+	return r.insertID(ctx, r.sb.
+		Insert("webhook_deliveries").
+		Columns("webhook_id", "event", "payload", "status", "response_body", "last_error", "created_at", "updated_at").
+		Values(d.WebhookID, d.Event, d.Payload, DeliveryPending, "", "", t, t))
+}
+
+// saveAttempt records the outcome of an attempt of the delivery.
+func (r *Repo) saveAttempt(ctx context.Context, d Delivery) error {
+	defer metrics.ObserveQuery("webhook", "saveAttempt")()
+	t := now()
+	set := squirrel.Eq{
+		"status":        d.Status,
+		"attempts":      squirrel.Expr("attempts + 1"),
+		"response_code": d.ResponseCode,
+		"response_body": d.ResponseBody,
+		"last_error":    d.LastError,
+		"duration_ms":   d.DurationMs,
+		"updated_at":    t,
+	}
+	if d.Status == DeliverySucceeded {
+		set["delivered_at"] = t
+	}
+	_, err := r.exec(ctx, r.sb.Update("webhook_deliveries").SetMap(set).Where(squirrel.Eq{"id": d.ID}))
+	return err
+}
+
+var deliveryColumns = []string{
+	"id",
+	"webhook_id",
+	"event",
+	"payload",
+	"status",
+	"attempts",
+	"response_code",
+	"response_body",
+	"last_error",
+	"duration_ms",
+	"created_at",
+	"updated_at",
+	"delivered_at",
+}
+
+func (r *Repo) listDeliveries(ctx context.Context, where squirrel.Sqlizer, limit uint64) ([]Delivery, error) {
+	defer metrics.ObserveQuery("webhook", "listDeliveries")()
+	query, args, err := r.sb.
+		Select(deliveryColumns...).
+		From("webhook_deliveries").
+		Where(where).
+		OrderBy("id DESC").
+		Limit(limit).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(
+			&d.ID,
+			&d.WebhookID,
+			&d.Event,
+			&d.Payload,
+			&d.Status,
+			&d.Attempts,
+			&d.ResponseCode,
+			&d.ResponseBody,
+			&d.LastError,
+			&d.DurationMs,
+			&d.CreatedAt,
+			&d.UpdatedAt,
+			&deliveredAt,
+		); err != nil {
+			return nil, err
+		}
+		if deliveredAt.Valid {
+			d.DeliveredAt = &deliveredAt.Time
+		}
+		res = append(res, d)
+	}
+	return res, rows.Err()
+}
+
+func (r *Repo) getDelivery(ctx context.Context, id int64) (Delivery, error) {
+	res, err := r.listDeliveries(ctx, squirrel.Eq{"id": id}, 1)
+	if err != nil {
+		return Delivery{}, err
+	}
+	if len(res) == 0 {
+		return Delivery{}, sql.ErrNoRows
+	}
+	return res[0], nil
+}
@@ -0,0 +1,140 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxResponseBody is the part of the response kept in the delivery log.
+const maxResponseBody = 2048
+
+// errPrivateAddress refuses to dial the local machine or a private network.
+var errPrivateAddress = errors.New("webhook: the address is not public")
+
+// sharedAddresses is the carrier grade NAT range of RFC 6598.
+var sharedAddresses = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP reports whether ip is outside the loopback, private, link-local,
+// shared, multicast and unspecified ranges.
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		ip.IsUnspecified() || sharedAddresses.Contains(ip))
+}
+
+// publicURL reports whether the host of rawURL may be public: localhost and
+// the literal addresses of private ranges are not. A name is only resolved
+// when dialing, where dialControl checks its addresses.
+func publicURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return publicIP(ip)
+	}
+	return true
+}
+
+// dialControl refuses the connections to addresses that are not public,
+// after the name of the webhook was resolved so rebinding it does not help.
+func dialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// Sender POSTs the deliveries to the webhooks.
+type Sender struct {
+	client       *http.Client
+	allowPrivate bool
+}
+
+// NewSender returns a Sender using client, nil uses a client with a 10s
+// timeout that only dials public addresses unless allowPrivate, which is
+// meant for development.
+func NewSender(client *http.Client, allowPrivate bool) *Sender {
+	if client == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if !allowPrivate {
+			// a proxy would be dialed instead of the webhook.
+			transport.Proxy = nil
+			transport.DialContext = (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+				Control:   dialControl,
+			}).DialContext
+		}
+		client = &http.Client{Timeout: 10 * time.Second, Transport: transport}
+	}
+	return &Sender{client: client, allowPrivate: allowPrivate}
+}
+
+// Response is what a delivery attempt got back.
+type Response struct {
+	StatusCode int
+	Body       string
+	Duration   time.Duration
+}
+
+// Sign returns the X-Webhook-Signature of body sent at timestamp, the hex
+// HMAC-SHA256 of "<timestamp>.<body>" with the secret of the webhook.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send POSTs the payload of d to w, any answer but a 2xx is an error.
+func (s *Sender) Send(ctx context.Context, w Webhook, d Delivery) (Response, error) {
+	body := []byte(d.Payload)
+	ts := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return Response{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "golang-htmx-template-webhook")
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(ts, 10))
+	req.Header.Set("X-Webhook-Signature", Sign(w.Secret, ts, body))
+
+	start := time.Now()
+	res, err := s.client.Do(req)
+	if err != nil {
+		return Response{Duration: time.Since(start)}, err
+	}
+	defer res.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(res.Body, maxResponseBody))
+	// drain the rest so the connection is reused.
+	_, _ = io.Copy(io.Discard, res.Body)
+	out := Response{StatusCode: res.StatusCode, Body: string(b), Duration: time.Since(start)}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return out, fmt.Errorf("webhook answered %d", res.StatusCode)
+	}
+	return out, nil
+}
@@ -0,0 +1,303 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/event"
+	"github.com/anousonefs/golang-htmx-template/internal/job"
+	"github.com/anousonefs/golang-htmx-template/internal/logger"
+	"github.com/anousonefs/golang-htmx-template/internal/validation"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+)
+
+// maxDeliveries bounds the delivery log returned for a webhook.
+const maxDeliveries = 50
+
+type Service struct {
+	repo   *Repo
+	jobs   *job.Service
+	sender *Sender
+}
+
+func NewService(repo *Repo, jobs *job.Service, sender *Sender) *Service {
+	return &Service{repo: repo, jobs: jobs, sender: sender}
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperror.ErrStatusNotFound
+	}
+	return err
+}
+
+// validate checks w, its URL must reach a public host unless the sender may
+// call private ones.
+func (s *Service) validate(w Webhook) error {
+	if err := w.Validate(); err != nil {
+		return err
+	}
+	if s.sender.allowPrivate {
+		return nil
+	}
+	v := validation.New()
+	v.PublicURL("url", publicURL(w.URL))
+	return v.Err()
+}
+
+// Create adds a webhook, a secret is generated when w has none. The secret
+// is only returned here, the receiver needs it to check the signatures.
+func (s *Service) Create(ctx context.Context, w Webhook) (res Webhook, err error) {
+	defer func() {
+		if err != nil {
+			logger.FromContext(ctx).Error("webhook.Create", "err", err)
+		}
+	}()
+	if err := s.validate(w); err != nil {
+		return Webhook{}, err
+	}
+	if w.Secret == "" {
+		if w.Secret, err = newSecret(); err != nil {
+			return Webhook{}, err
+		}
+	}
+	id, err := s.repo.createWebhook(ctx, w)
+	if err != nil {
+		return Webhook{}, err
+	}
+	if res, err = s.repo.getWebhook(ctx, id); err != nil {
+		return Webhook{}, err
+	}
+	return res, nil
+}
+
+func (s *Service) List(ctx context.Context) (res []Webhook, err error) {
+	res, err = s.repo.listWebhooks(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Error("webhook.List", "err", err)
+		return []Webhook{}, err
+	}
+	for i := range res {
+		res[i].Secret = ""
+	}
+	return res, nil
+}
+
+func (s *Service) Get(ctx context.Context, id int64) (res Webhook, err error) {
+	defer func() {
+		if err != nil && !errors.Is(err, apperror.ErrStatusNotFound) {
+			logger.FromContext(ctx).Error("webhook.Get", "err", err)
+		}
+	}()
+	res, err = s.repo.getWebhook(ctx, id)
+	if err != nil {
+		return Webhook{}, notFound(err)
+	}
+	res.Secret = ""
+	return res, nil
+}
+
+// Update replaces the webhook, an empty secret keeps the current one.
+func (s *Service) Update(ctx context.Context, w Webhook) (res Webhook, err error) {
+	defer func() {
+		if err != nil && !errors.Is(err, apperror.ErrStatusNotFound) {
+			logger.FromContext(ctx).Error("webhook.Update", "err", err)
+		}
+	}()
+	if err := s.validate(w); err != nil {
+		return Webhook{}, err
+	}
+	current, err := s.repo.getWebhook(ctx, w.ID)
+	if err != nil {
+		return Webhook{}, notFound(err)
+	}
+	if w.Secret == "" {
+		w.Secret = current.Secret
+	}
+	if err := s.repo.updateWebhook(ctx, w); err != nil {
+		return Webhook{}, notFound(err)
+	}
+	return s.Get(ctx, w.ID)
+}
+
+// Delete removes the webhook and its delivery log, the pending deliveries
+// are dropped by the worker.
+func (s *Service) Delete(ctx context.Context, id int64) (err error) {
+	defer func() {
+		if err != nil && !errors.Is(err, apperror.ErrStatusNotFound) {
+			logger.FromContext(ctx).Error("webhook.Delete", "err", err)
+		}
+	}()
+	return notFound(s.repo.inTx(ctx, func(tx *sql.Tx) error {
+		return s.repo.WithTx(tx).deleteWebhook(ctx, id)
+	}))
+}
+
+// ListDeliveries returns the latest deliveries of the webhook, newest
+// first.
+func (s *Service) ListDeliveries(ctx context.Context, webhookID int64) (res []Delivery, err error) {
+	defer func() {
+		if err != nil && !errors.Is(err, apperror.ErrStatusNotFound) {
+			logger.FromContext(ctx).Error("webhook.ListDeliveries", "err", err)
+		}
+	}()
+	if _, err := s.repo.getWebhook(ctx, webhookID); err != nil {
+		return []Delivery{}, notFound(err)
+	}
+	res, err = s.repo.listDeliveries(ctx, squirrel.Eq{"webhook_id": webhookID}, maxDeliveries)
+	if err != nil {
+		return []Delivery{}, err
+	}
+	return res, nil
+}
+
+// Redeliver sends the payload of a past delivery again as a new delivery,
+// the receiver sees the same event id.
+func (s *Service) Redeliver(ctx context.Context, webhookID, deliveryID int64) (res Delivery, err error) {
+	defer func() {
+		if err != nil && !errors.Is(err, apperror.ErrStatusNotFound) {
+			logger.FromContext(ctx).Error("webhook.Redeliver", "err", err)
+		}
+	}()
+	d, err := s.repo.getDelivery(ctx, deliveryID)
+	if err != nil {
+		return Delivery{}, notFound(err)
+	}
+	if d.WebhookID != webhookID {
+		return Delivery{}, apperror.ErrStatusNotFound
+	}
+	var id int64
+	if err := s.repo.inTx(ctx, func(tx *sql.Tx) error {
+		id, err = s.enqueue(ctx, tx, d)
+		return err
+	}); err != nil {
+		return Delivery{}, err
+	}
+	return s.repo.getDelivery(ctx, id)
+}
+
+// enqueue records the delivery d and queues it for the workers.
+func (s *Service) enqueue(ctx context.Context, tx *sql.Tx, d Delivery) (int64, error) {
+	id, err := s.repo.WithTx(tx).createDelivery(ctx, d)
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.jobs.WithTx(tx).Enqueue(ctx, KindDeliver, deliverPayload{DeliveryID: id}, job.MaxAttempts(maxAttempts))
+	return id, err
+}
+
+// Subscribe queues a delivery of every event published on bus for the
+// active webhooks subscribed to it.
+func (s *Service) Subscribe(bus *event.Bus) {
+	bus.Subscribe(s.onEvent)
+}
+
+func (s *Service) onEvent(ctx context.Context, tx *sql.Tx, e event.Event) error {
+	hooks, err := s.repo.WithTx(tx).listWebhooks(ctx, squirrel.Eq{"active": true})
+	if err != nil {
+		return err
+	}
+	var body []byte
+	for _, w := range hooks {
+		if !w.Subscribed(e.EventName()) {
+			continue
+		}
+		if body == nil {
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if body, err = json.Marshal(Payload{
+				ID:        uuid.NewString(),
+				Event:     e.EventName(),
+				CreatedAt: time.Now().UTC(),
+				Data:      data,
+			}); err != nil {
+				return err
+			}
+		}
+		if _, err := s.enqueue(ctx, tx, Delivery{WebhookID: w.ID, Event: e.EventName(), Payload: string(body)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type deliverPayload struct {
+	DeliveryID int64 `json:"deliveryID"`
+}
+
+// Install registers the job sending the deliveries on w.
+func (s *Service) Install(w *job.Worker) {
+	w.Handle(KindDeliver, s.deliver)
+}
+
+// deliver sends a delivery and records the outcome, the job queue retries
+// the failed attempts with its backoff.
+func (s *Service) deliver(ctx context.Context, j *job.Job) error {
+	var p deliverPayload
+	if err := json.Unmarshal(j.Payload, &p); err != nil {
+		return job.Permanent(err)
+	}
+	d, err := s.repo.getDelivery(ctx, p.DeliveryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the webhook was deleted with its deliveries.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	w, err := s.repo.getWebhook(ctx, d.WebhookID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !w.Active {
+		d.Status, d.LastError = DeliveryFailed, "webhook inactive"
+		if err := s.repo.saveAttempt(ctx, d); err != nil {
+			return err
+		}
+		return job.Permanent(errors.New(d.LastError))
+	}
+
+	res, sendErr := s.sender.Send(ctx, w, d)
+	d.ResponseCode, d.ResponseBody, d.DurationMs = res.StatusCode, res.Body, res.Duration.Milliseconds()
+	d.LastError = ""
+	switch {
+	case sendErr == nil:
+		d.Status = DeliverySucceeded
+	case j.Attempts >= j.MaxAttempts:
+		d.Status, d.LastError = DeliveryFailed, sendErr.Error()
+	default:
+		d.Status, d.LastError = DeliveryPending, sendErr.Error()
+	}
+	if err := s.repo.saveAttempt(ctx, d); err != nil {
+		return err
+	}
+	logger.FromContext(ctx).Info("webhook delivered",
+		"webhook_id", w.ID,
+		"host", w.host(),
+		"delivery_id", d.ID,
+		"status", d.Status,
+		"code", d.ResponseCode,
+	)
+	return sendErr
+}
@@ -0,0 +1,334 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anousonefs/golang-htmx-template/internal/apperror"
+	"github.com/anousonefs/golang-htmx-template/internal/config"
+	"github.com/anousonefs/golang-htmx-template/internal/event"
+	"github.com/anousonefs/golang-htmx-template/internal/job"
+	"github.com/anousonefs/golang-htmx-template/internal/migration"
+
+	_ "modernc.org/sqlite"
+)
+
+// received is a request the test receiver got.
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver is a webhook endpoint answering with the codes of status in
+// turn, the last one repeated.
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   []int
+	requests []received
+}
+
+func newReceiver(t *testing.T, status ...int) *receiver {
+	r := &receiver{status: status}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.requests = append(r.requests, received{header: req.Header.Clone(), body: body})
+		code := r.status[min(len(r.requests), len(r.status))-1]
+		r.mu.Unlock()
+		w.WriteHeader(code)
+		fmt.Fprintf(w, "answered %d", code)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) got() []received {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]received(nil), r.requests...)
+}
+
+// testEnv is a webhook service on a migrated sqlite database.
+type testEnv struct {
+	db       *sql.DB
+	webhooks *Service
+	jobs     *job.Service
+}
+
+func newTestEnv(t *testing.T) testEnv {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", filepath.Join(t.TempDir(), "test.db"))
+	db, err := sql.Open(config.DriverSqlite, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	m, err := migration.New(db, config.DriverSqlite, os.DirFS("../../cmd/migrations"), config.DriverSqlite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	sb := config.StatementBuilder(config.DriverSqlite)
+	jobs := job.NewService(job.NewRepo(db, sb, config.DriverSqlite))
+	return testEnv{
+		db:       db,
+		webhooks: NewService(NewRepo(db, sb, config.DriverSqlite), jobs, NewSender(nil, true)),
+		jobs:     jobs,
+	}
+}
+
+// work runs a worker sending the deliveries until the test ends.
+func (e testEnv) work(t *testing.T) {
+	w := job.NewWorker(job.NewRepo(e.db, config.StatementBuilder(config.DriverSqlite), config.DriverSqlite),
+		job.WorkerConfig{PollInterval: 10 * time.Millisecond},
+		slog.New(slog.NewTextHandler(io.Discard, nil)))
+	e.webhooks.Install(w)
+	ctx, cancel := context.WithCancel(context.Background())
+	go w.Run(ctx)
+	t.Cleanup(func() {
+		cancel()
+		_ = w.Wait(context.Background())
+	})
+}
+
+// publish sends e to the webhooks as the event bus does, in a transaction.
+func (e testEnv) publish(t *testing.T, ev event.Event) {
+	t.Helper()
+	ctx := context.Background()
+	if err := e.webhooks.repo.inTx(ctx, func(tx *sql.Tx) error {
+		return e.webhooks.onEvent(ctx, tx, ev)
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (e testEnv) create(t *testing.T, url string) Webhook {
+	t.Helper()
+	w, err := e.webhooks.Create(context.Background(), Webhook{URL: url, Events: []string{AllEvents}, Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func (e testEnv) deliveries(t *testing.T, webhookID int64) []Delivery {
+	t.Helper()
+	res, err := e.webhooks.ListDeliveries(context.Background(), webhookID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// waitFor polls cond until it holds, failing the test after 5s.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSendSignsTimestampAndBody(t *testing.T) {
+	r := newReceiver(t, http.StatusOK)
+	w := Webhook{URL: r.URL, Secret: "s3cret"}
+	d := Delivery{ID: 7, Event: "user.created", Payload: `{"id":"1"}`}
+
+	before := time.Now().Unix()
+	res, err := NewSender(nil, true).Send(context.Background(), w, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || res.Body != "answered 200" {
+		t.Fatalf("response = %+v", res)
+	}
+
+	got := r.got()
+	if len(got) != 1 {
+		t.Fatalf("got %d requests, want 1", len(got))
+	}
+	h := got[0].header
+	ts, err := strconv.ParseInt(h.Get("X-Webhook-Timestamp"), 10, 64)
+	if err != nil || ts < before || ts > time.Now().Unix() {
+		t.Fatalf("X-Webhook-Timestamp = %q", h.Get("X-Webhook-Timestamp"))
+	}
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10) + "." + d.Payload))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); h.Get("X-Webhook-Signature") != want {
+		t.Errorf("X-Webhook-Signature = %q, want %q", h.Get("X-Webhook-Signature"), want)
+	}
+	if string(got[0].body) != d.Payload {
+		t.Errorf("body = %s, want %s", got[0].body, d.Payload)
+	}
+	if h.Get("X-Webhook-Event") != d.Event || h.Get("X-Webhook-Delivery") != "7" {
+		t.Errorf("event headers = %q, %q", h.Get("X-Webhook-Event"), h.Get("X-Webhook-Delivery"))
+	}
+	if h.Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type = %q", h.Get("Content-Type"))
+	}
+}
+
+func TestSendRefusesPrivateAddresses(t *testing.T) {
+	r := newReceiver(t, http.StatusOK)
+	_, err := NewSender(nil, false).Send(context.Background(), Webhook{URL: r.URL}, Delivery{Payload: "{}"})
+	if !errors.Is(err, errPrivateAddress) {
+		t.Fatalf("err = %v, want %v", err, errPrivateAddress)
+	}
+	if n := len(r.got()); n != 0 {
+		t.Fatalf("the receiver got %d requests", n)
+	}
+}
+
+func TestPublicURL(t *testing.T) {
+	for url, want := range map[string]bool{
+		"https://example.com/hook":           true,
+		"http://93.184.216.34/hook":          true,
+		"http://localhost:8080/hook":         false,
+		"http://api.localhost/hook":          false,
+		"http://127.0.0.1/hook":              false,
+		"http://10.0.0.5/hook":               false,
+		"http://172.16.3.4/hook":             false,
+		"http://192.168.1.1/hook":            false,
+		"http://169.254.169.254/latest":      false,
+		"http://100.64.0.1/hook":             false,
+		"http://0.0.0.0/hook":                false,
+		"http://[::1]/hook":                  false,
+		"http://[fe80::1]/hook":              false,
+		"http://[fd00::1]/hook":              false,
+		"http://[::ffff:127.0.0.1]/hook":     false,
+		"http://[2606:2800:220:1::248]/hook": true,
+	} {
+		if got := publicURL(url); got != want {
+			t.Errorf("publicURL(%q) = %v, want %v", url, got, want)
+		}
+	}
+}
+
+func TestCreateRefusesPrivateURLs(t *testing.T) {
+	e := newTestEnv(t)
+	s := NewService(e.webhooks.repo, e.jobs, NewSender(nil, false))
+	_, err := s.Create(context.Background(), Webhook{URL: "http://169.254.169.254/", Events: []string{AllEvents}})
+	if apperror.FieldViolations(apperror.StatusFromErr(err, "en")) == nil {
+		t.Fatalf("err = %v, want a validation error", err)
+	}
+}
+
+func TestDeliverRetriesWithBackoff(t *testing.T) {
+	e := newTestEnv(t)
+	r := newReceiver(t, http.StatusServiceUnavailable, http.StatusOK)
+	w := e.create(t, r.URL)
+	e.work(t)
+	e.publish(t, event.UserCreated{UserID: "u1", Email: "a@b.co"})
+
+	ctx := context.Background()
+	var jobs []job.Job
+	waitFor(t, "the failed attempt", func() bool {
+		var err error
+		jobs, err = e.jobs.ListJobs(ctx, job.FilterJob{Status: job.StatusPending})
+		return err == nil && len(jobs) == 1 && jobs[0].Attempts == 1
+	})
+	j := jobs[0]
+	if j.MaxAttempts != maxAttempts {
+		t.Errorf("max attempts = %d, want %d", j.MaxAttempts, maxAttempts)
+	}
+	if wait := time.Until(j.RunAt); wait < 9*time.Second {
+		t.Errorf("retried in %s, want a backoff of about 10s", wait)
+	}
+	ds := e.deliveries(t, w.ID)
+	if len(ds) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(ds))
+	}
+	d := ds[0]
+	if d.Status != DeliveryPending || d.Attempts != 1 || d.ResponseCode != http.StatusServiceUnavailable ||
+		d.ResponseBody != "answered 503" || d.LastError == "" || d.DeliveredAt != nil {
+		t.Fatalf("after the 503 delivery = %+v", d)
+	}
+
+	// skip the backoff.
+	if _, err := e.db.Exec("UPDATE jobs SET run_at = ?", time.Now().UTC().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the retry", func() bool {
+		ds := e.deliveries(t, w.ID)
+		return ds[0].Status != DeliveryPending
+	})
+	d = e.deliveries(t, w.ID)[0]
+	if d.Status != DeliverySucceeded || d.Attempts != 2 || d.ResponseCode != http.StatusOK ||
+		d.LastError != "" || d.DeliveredAt == nil {
+		t.Fatalf("after the retry delivery = %+v", d)
+	}
+	got := r.got()
+	if len(got) != 2 {
+		t.Fatalf("got %d requests, want 2", len(got))
+	}
+	if string(got[0].body) != string(got[1].body) || got[0].header.Get("X-Webhook-Delivery") != got[1].header.Get("X-Webhook-Delivery") {
+		t.Error("the retry did not send the same delivery")
+	}
+}
+
+func TestRedeliver(t *testing.T) {
+	e := newTestEnv(t)
+	r := newReceiver(t, http.StatusOK)
+	w := e.create(t, r.URL)
+	e.work(t)
+	e.publish(t, event.UserCreated{UserID: "u1", Email: "a@b.co"})
+	waitFor(t, "the delivery", func() bool { return len(r.got()) == 1 })
+	waitFor(t, "the delivery log", func() bool { return e.deliveries(t, w.ID)[0].Status == DeliverySucceeded })
+	first := e.deliveries(t, w.ID)[0]
+
+	ctx := context.Background()
+	if _, err := e.webhooks.Redeliver(ctx, w.ID+1, first.ID); !errors.Is(err, apperror.ErrStatusNotFound) {
+		t.Fatalf("redeliver to another webhook: err = %v, want not found", err)
+	}
+	again, err := e.webhooks.Redeliver(ctx, w.ID, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID == first.ID || again.Payload != first.Payload || again.Status != DeliveryPending {
+		t.Fatalf("redelivery = %+v", again)
+	}
+	waitFor(t, "the redelivery", func() bool { return len(r.got()) == 2 })
+	waitFor(t, "the redelivery log", func() bool { return e.deliveries(t, w.ID)[0].Status == DeliverySucceeded })
+
+	ds := e.deliveries(t, w.ID)
+	if len(ds) != 2 || ds[0].ID != again.ID || ds[1].ID != first.ID {
+		t.Fatalf("deliveries = %+v", ds)
+	}
+	got := r.got()
+	var p0, p1 Payload
+	if err := json.Unmarshal(got[0].body, &p0); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(got[1].body, &p1); err != nil {
+		t.Fatal(err)
+	}
+	if p0.ID == "" || p0.ID != p1.ID || p1.Event != "user.created" {
+		t.Errorf("payloads = %+v, %+v, want the same event", p0, p1)
+	}
+	if got[1].header.Get("X-Webhook-Delivery") != strconv.FormatInt(again.ID, 10) {
+		t.Errorf("X-Webhook-Delivery = %q, want %d", got[1].header.Get("X-Webhook-Delivery"), again.ID)
+	}
+}
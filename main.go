@@ -1,11 +1,15 @@
 package main
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/anousonefs/golang-htmx-template/cmd"
 )
 
 func main() {
-	if err := cmd.Run(); err != nil {
-		panic(err)
+	if err := cmd.Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
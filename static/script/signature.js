@@ -0,0 +1,64 @@
+/*
+Signature pad of the signature page: draws on the <canvas data-signature-pad>
+of a <form data-signature-form> and sends the drawing as the "data" field,
+a PNG data URL. The page is swapped in by htmx, the listeners are on the
+document so they work whenever it is shown.
+*/
+(function () {
+  var drawing = null;
+
+  function point(canvas, evt) {
+    var r = canvas.getBoundingClientRect();
+    return {
+      x: (evt.clientX - r.left) * canvas.width / r.width,
+      y: (evt.clientY - r.top) * canvas.height / r.height
+    };
+  }
+
+  document.addEventListener("pointerdown", function (evt) {
+    var canvas = evt.target.closest && evt.target.closest("canvas[data-signature-pad]");
+    if (!canvas) return;
+    var ctx = canvas.getContext("2d");
+    var p = point(canvas, evt);
+    ctx.lineWidth = 3;
+    ctx.lineCap = "round";
+    ctx.lineJoin = "round";
+    ctx.strokeStyle = "#000";
+    ctx.beginPath();
+    ctx.moveTo(p.x, p.y);
+    canvas.setPointerCapture(evt.pointerId);
+    canvas.dataset.signed = "true";
+    drawing = canvas;
+  });
+
+  document.addEventListener("pointermove", function (evt) {
+    if (!drawing) return;
+    var ctx = drawing.getContext("2d");
+    var p = point(drawing, evt);
+    ctx.lineTo(p.x, p.y);
+    ctx.stroke();
+  });
+
+  document.addEventListener("pointerup", function () {
+    drawing = null;
+  });
+
+  document.addEventListener("click", function (evt) {
+    var button = evt.target.closest && evt.target.closest("[data-signature-clear]");
+    if (!button) return;
+    var canvas = button.form.querySelector("canvas[data-signature-pad]");
+    canvas.getContext("2d").clearRect(0, 0, canvas.width, canvas.height);
+    delete canvas.dataset.signed;
+  });
+
+  document.addEventListener("htmx:configRequest", function (evt) {
+    var form = evt.detail.elt;
+    if (!form.matches || !form.matches("form[data-signature-form]")) return;
+    var canvas = form.querySelector("canvas[data-signature-pad]");
+    if (canvas.dataset.signed !== "true") {
+      evt.preventDefault();
+      return;
+    }
+    evt.detail.parameters["data"] = canvas.toDataURL("image/png");
+  });
+})();
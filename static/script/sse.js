@@ -0,0 +1,119 @@
+/*
+Server Sent Events extension for htmx 1.9, the sse-connect and sse-swap
+attributes of the upstream extension:
+
+  <div hx-ext="sse" sse-connect="/stream">
+    <span sse-swap="message"></span>
+  </div>
+
+The browser reconnects a dropped stream by itself with the Last-Event-ID
+header. A source the server closed for good is opened again with backoff,
+the last event id is then passed as the last_event_id query parameter.
+*/
+(function () {
+  /** @type {import("../htmx").HtmxInternalApi} */
+  var api;
+
+  htmx.defineExtension("sse", {
+    init: function (apiRef) {
+      api = apiRef;
+    },
+
+    onEvent: function (name, evt) {
+      var elt = evt.target || evt.detail.elt;
+      switch (name) {
+        case "htmx:beforeCleanupElement":
+          var data = api.getInternalData(elt);
+          if (data.sseEventSource) {
+            data.sseEventSource.close();
+          }
+          return;
+        case "htmx:afterProcessNode":
+          connect(elt, 0);
+          registerSwaps(elt);
+      }
+    },
+  });
+
+  function connect(elt, retries) {
+    if (!api.hasAttribute(elt, "sse-connect")) {
+      return;
+    }
+    var data = api.getInternalData(elt);
+    var url = api.getAttributeValue(elt, "sse-connect");
+    if (data.sseLastEventId) {
+      url += (url.indexOf("?") < 0 ? "?" : "&") + "last_event_id=" + encodeURIComponent(data.sseLastEventId);
+    }
+    var source = htmx.createEventSource(url);
+    data.sseEventSource = source;
+
+    source.onopen = function () {
+      retries = 0;
+      api.triggerEvent(elt, "htmx:sseOpen", { source: source });
+    };
+    source.onerror = function (err) {
+      api.triggerErrorEvent(elt, "htmx:sseError", { error: err, source: source });
+      if (source.readyState !== EventSource.CLOSED || !api.bodyContains(elt)) {
+        return;
+      }
+      var delay = Math.min(1000 * Math.pow(2, retries), 64000);
+      setTimeout(function () {
+        if (api.bodyContains(elt) && data.sseEventSource === source) {
+          connect(elt, retries + 1);
+          registerSwaps(elt);
+        }
+      }, delay);
+    };
+  }
+
+  // registerSwaps listens for the sse-swap events of elt and its children.
+  function registerSwaps(elt) {
+    var elts = [];
+    if (api.hasAttribute(elt, "sse-swap")) {
+      elts.push(elt);
+    }
+    if (elt.querySelectorAll) {
+      elts.push.apply(elts, elt.querySelectorAll("[sse-swap], [data-sse-swap]"));
+    }
+    elts.forEach(function (child) {
+      var sourceElt = api.getClosestMatch(child, function (e) {
+        return api.getInternalData(e).sseEventSource != null;
+      });
+      if (!sourceElt) {
+        return;
+      }
+      var source = api.getInternalData(sourceElt).sseEventSource;
+      var data = api.getInternalData(child);
+      if (data.sseSource === source) {
+        return;
+      }
+      data.sseSource = source;
+      api.getAttributeValue(child, "sse-swap").split(",").forEach(function (name) {
+        name = name.trim();
+        var listener = function (event) {
+          if (!api.bodyContains(child)) {
+            source.removeEventListener(name, listener);
+            return;
+          }
+          if (event.lastEventId) {
+            api.getInternalData(sourceElt).sseLastEventId = event.lastEventId;
+          }
+          if (!api.triggerEvent(child, "htmx:sseBeforeMessage", event)) {
+            return;
+          }
+          swap(child, event.data);
+          api.triggerEvent(child, "htmx:sseMessage", event);
+        };
+        source.addEventListener(name, listener);
+      });
+    });
+  }
+
+  function swap(elt, content) {
+    var spec = api.getSwapSpecification(elt);
+    var target = api.getTarget(elt);
+    var settleInfo = api.makeSettleInfo(elt);
+    api.selectAndSwap(spec.swapStyle, target, elt, content, settleInfo);
+    api.settleImmediately(settleInfo.tasks);
+  }
+})();
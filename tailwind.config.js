@@ -2,12 +2,7 @@ const colors = require("tailwindcss/colors");
 
 /** @type {import('tailwindcss').Config} */
 module.exports = {
-  content: [
-    "internal/user/views/*.templ",
-    "internal/templates/*.templ",
-    "internal/dashboard/views/*.templ",
-    "internal/auth/views/*.templ",
-  ],
+  content: ["internal/**/*.templ"],
   theme: {
     container: {
       center: true,